@@ -0,0 +1,90 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// auditLog collects a content-blind record of every encrypted file processed
+// during a run. Only filenames and hashes of the encrypted file contents are
+// recorded, and never any key names or values.
+type auditLog struct {
+	filename string
+	files    []auditFile
+}
+
+// auditFile represents a single processed file in an audit record.
+type auditFile struct {
+	File   string `json:"file"`
+	SHA256 string `json:"sha256"`
+}
+
+// auditRecord represents a single run in the audit log.
+type auditRecord struct {
+	Time  string      `json:"time"`
+	Root  string      `json:"root"`
+	Files []auditFile `json:"files"`
+}
+
+// record adds the given file, with the given hash of its contents, to the
+// audit log. A nil audit log records nothing.
+func (a *auditLog) record(file string, sum []byte) {
+	if a == nil {
+		return
+	}
+
+	a.files = append(a.files, auditFile{File: file, SHA256: hex.EncodeToString(sum)})
+}
+
+// reset forgets every recorded file. A nil audit log has nothing to forget.
+func (a *auditLog) reset() {
+	if a == nil {
+		return
+	}
+
+	a.files = nil
+}
+
+// write appends a single record of every recorded file, processed relative to
+// the given config root, to the audit log file, and then forgets those files
+// so that the next record only holds the files processed after it. A nil
+// audit log writes nothing.
+func (a *auditLog) write(root string) error {
+	if a == nil {
+		return nil
+	}
+
+	record := auditRecord{
+		Time:  time.Now().UTC().Format(time.RFC3339),
+		Root:  root,
+		Files: append([]auditFile{}, a.files...),
+	}
+	a.reset()
+
+	body, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(a.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	// The record is written as a single line in a single write, which an
+	// append-only file keeps intact even with concurrent runs.
+	if _, err := file.Write(append(body, '\n')); err != nil {
+		file.Close()
+
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,131 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestAuditRecord(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"app.enc.yaml": "apiVersion: v1\nkind: Secret\nmetadata:\n  name: app\n  namespace: prod\nstringData:\n  api-token: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]\n",
+		"user.enc":     "ENC[AES256_GCM,data:dXNlcg==,iv:aXY=,tag:dGFn,type:str]",
+		"pass.enc":     "ENC[AES256_GCM,data:cGFzcw==,iv:aXY=,tag:dGFn,type:str]",
+	}
+	writeFiles(t, root, files)
+
+	env := pluginEnv(root, "app.enc.yaml", "db-user=user.enc", "db-pass=pass.enc")
+	env["KUSTOMIZE_PLUGIN_CONFIG_STRING"] = strings.Replace(env["KUSTOMIZE_PLUGIN_CONFIG_STRING"], "  name: generator\n", "  name: credentials\n  namespace: prod\n", 1)
+	env["KSOPS_DRY_RUN_AUDIT_LOG"] = filepath.Join(t.TempDir(), "audit.log")
+
+	if _, err := runMain(t, []string{"generator.yaml"}, env); err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := os.ReadFile(env["KSOPS_DRY_RUN_AUDIT_LOG"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(body), "\n") != 1 {
+		t.Fatalf("expected a single record but got:\n%s", body)
+	}
+
+	// The record holds nothing but the time, root, and files, and each file
+	// nothing but its name and hash.
+	var record map[string]any
+	if err := json.Unmarshal(body, &record); err != nil {
+		t.Fatal(err)
+	}
+	if got := sortedKeys(record); got != "files,root,time" {
+		t.Errorf("expected record fields files,root,time but got %s", got)
+	}
+
+	want := make(map[string]string, len(files))
+	for name, content := range files {
+		sum := sha256.Sum256([]byte(content))
+		want[filepath.Join(root, name)] = hex.EncodeToString(sum[:])
+	}
+
+	got := make(map[string]string)
+	for _, file := range record["files"].([]any) {
+		file := file.(map[string]any)
+		if fields := sortedKeys(file); fields != "file,sha256" {
+			t.Errorf("expected file fields file,sha256 but got %s", fields)
+		}
+		got[file["file"].(string)] = file["sha256"].(string)
+	}
+
+	// Every file that was read is recorded, including each key file.
+	for name, sum := range want {
+		if got[name] != sum {
+			t.Errorf("expected %s to be recorded with hash %s but got %q", name, sum, got[name])
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("expected %d files but got %v", len(want), got)
+	}
+
+	// Neither key names nor values are ever recorded.
+	for _, sensitive := range []string{"api-token", "db-user", "db-pass", "ENC["} {
+		if strings.Contains(string(body), sensitive) {
+			t.Errorf("expected no %q in the record but got:\n%s", sensitive, body)
+		}
+	}
+}
+
+func TestAuditWriteResets(t *testing.T) {
+	audit := &auditLog{filename: filepath.Join(t.TempDir(), "audit.log")}
+
+	audit.record("first.enc.yaml", []byte{1})
+	if err := audit.write("."); err != nil {
+		t.Fatal(err)
+	}
+
+	// A later run, such as a watch render, only records its own files.
+	audit.record("second.enc.yaml", []byte{2})
+	if err := audit.write("."); err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := os.ReadFile(audit.filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 records but got:\n%s", body)
+	}
+
+	for i, want := range []string{"first.enc.yaml", "second.enc.yaml"} {
+		var record auditRecord
+		if err := json.Unmarshal([]byte(lines[i]), &record); err != nil {
+			t.Fatal(err)
+		}
+		if len(record.Files) != 1 || record.Files[0].File != want {
+			t.Errorf("expected record %d to hold only %s but got %v", i, want, record.Files)
+		}
+	}
+}
+
+// sortedKeys returns the keys of the given map, sorted and comma separated.
+func sortedKeys(values map[string]any) string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return strings.Join(keys, ",")
+}
@@ -0,0 +1,63 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"gopkg.in/yaml.v3"
+)
+
+// binaryTag is the standard yaml tag for base64 encoded binary values, which
+// sops may use to represent binary data.
+const binaryTag = "!!binary"
+
+// UnmarshalYAML decodes an encrypted secret, while recording which of its
+// values were tagged as binary. A binary value that has been encrypted is no
+// longer valid base64, and would otherwise fail to decode.
+func (e *encryptedSecret) UnmarshalYAML(node *yaml.Node) error {
+	duplicates := dropDuplicates(node, "stringData", "data")
+	binary := retagBinary(node)
+	keyOrder := keysOf(node, "stringData", "data")
+
+	// Decode as a type without this method, to avoid recursing.
+	type plain encryptedSecret
+	if err := node.Decode((*plain)(e)); err != nil {
+		return err
+	}
+	e.binary = binary
+	e.duplicates = duplicates
+	e.keyOrder = keyOrder
+
+	return nil
+}
+
+// retagBinary rewrites every binary tagged value in the data or stringData of
+// the given resource as a plain string, and returns the keys of those values.
+func retagBinary(resource *yaml.Node) map[string]struct{} {
+	var binary map[string]struct{}
+
+	for _, values := range []*yaml.Node{mappingValue(resource, "data"), mappingValue(resource, "stringData")} {
+		if values == nil || values.Kind != yaml.MappingNode {
+			continue
+		}
+
+		for i := 0; i+1 < len(values.Content); i += 2 {
+			key, value := values.Content[i], values.Content[i+1]
+			if value.Kind != yaml.ScalarNode || value.ShortTag() != binaryTag {
+				continue
+			}
+
+			value.Tag = "!!str"
+			value.Style &^= yaml.TaggedStyle
+
+			if binary == nil {
+				binary = make(map[string]struct{})
+			}
+			binary[key.Value] = struct{}{}
+		}
+	}
+
+	return binary
+}
@@ -0,0 +1,41 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestBinaryValues(t *testing.T) {
+	body, err := os.ReadFile("testdata/binary/tls.enc.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := stubString(t, string(body), testOptions(t, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The binary value is kept in data, with a base64 encoded placeholder.
+	want := `apiVersion: v1
+kind: Secret
+metadata:
+    labels:
+        ksops-dry-run.joshdk.github.com: "true"
+    name: app
+    namespace: prod
+type: kubernetes.io/tls
+stringData:
+    tls.key: KSOPS_DRY_RUN_PLACEHOLDER
+data:
+    tls.crt: S1NPUFNfRFJZX1JVTl9QTEFDRUhPTERFUg==
+`
+	if output != want {
+		t.Errorf("expected output:\n%s\nbut got:\n%s", want, output)
+	}
+}
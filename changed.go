@@ -0,0 +1,76 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// changedFiles returns the set of files that have changed since the given git
+// ref, in the git repository containing the given directory. Every file is
+// named by its absolute path.
+func changedFiles(dir, ref string) (map[string]struct{}, error) {
+	toplevel, err := git(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	root := strings.TrimSpace(string(toplevel))
+
+	diff, err := git(dir, "diff", "--name-only", ref, "--")
+	if err != nil {
+		return nil, err
+	}
+
+	// Every file in the diff is named relative to the root of the repository.
+	changed := make(map[string]struct{})
+	for _, line := range strings.Split(string(diff), "\n") {
+		if line == "" {
+			continue
+		}
+		changed[filepath.Join(root, line)] = struct{}{}
+	}
+
+	return changed, nil
+}
+
+// isChanged returns true if the given file is in the given set of changed
+// files.
+func isChanged(changed map[string]struct{}, filename string) bool {
+	// Symlinks are resolved, as git always reports the real path.
+	path, err := filepath.EvalSymlinks(filename)
+	if err != nil {
+		path = filename
+	}
+
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+
+	_, found := changed[path]
+
+	return found
+}
+
+// git runs the given git subcommand from the given directory, and returns its
+// stdout.
+func git(dir string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return output, nil
+}
@@ -0,0 +1,112 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeGit is a git executable that reports the repository root, and the
+// changed files, from the environment. It fails like git outside of a
+// repository if FAKE_GIT_FAIL is set.
+const fakeGit = `#!/bin/sh
+if [ -n "$FAKE_GIT_FAIL" ]; then
+  echo "fatal: not a git repository" >&2
+  exit 128
+fi
+shift 2
+case "$1" in
+  rev-parse) echo "$FAKE_GIT_TOPLEVEL" ;;
+  diff) printf '%s\n' "$FAKE_GIT_DIFF" ;;
+esac
+`
+
+func TestChangedSince(t *testing.T) {
+	tests := []struct {
+		name     string
+		diff     string
+		fail     bool
+		want     []string
+		wantWarn string
+	}{
+		{
+			name: "only changed files",
+			diff: "secrets/cache.enc.yaml\nREADME.md",
+			want: []string{"cache"},
+		},
+		{
+			name: "no changed files",
+			diff: "README.md",
+		},
+		{
+			name:     "outside of a repository",
+			fail:     true,
+			want:     []string{"database", "cache"},
+			wantWarn: "processing all files, as changed files could not be determined: git rev-parse: exit status 128: fatal: not a git repository",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repo, err := filepath.EvalSymlinks(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			root := filepath.Join(repo, "secrets")
+			for name, fixture := range map[string]string{
+				"database.enc.yaml": "testdata/policy/compliant.enc.yaml",
+				"cache.enc.yaml":    "testdata/policy/wrong-recipient.enc.yaml",
+			} {
+				body, err := os.ReadFile(fixture)
+				if err != nil {
+					t.Fatal(err)
+				}
+				writeFiles(t, root, map[string]string{name: string(body)})
+			}
+
+			bin := t.TempDir()
+			writeFiles(t, bin, map[string]string{"git": fakeGit})
+			if err := os.Chmod(filepath.Join(bin, "git"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+			t.Setenv("FAKE_GIT_TOPLEVEL", repo)
+			t.Setenv("FAKE_GIT_DIFF", test.diff)
+			if test.fail {
+				t.Setenv("FAKE_GIT_FAIL", "true")
+			}
+
+			config := ksopsGeneratorConfig{Files: []string{"database.enc.yaml", "cache.enc.yaml"}}
+			config.Metadata.Namespace = "prod"
+
+			// Warnings are collected as errors by the test options.
+			opts := testOptions(t, map[string]string{"KSOPS_DRY_RUN_CHANGED_SINCE": "main"})
+			documents, err := generateSecrets(&config, root, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var names []string
+			for _, secret := range secretsOf(documents) {
+				names = append(names, secret.Metadata.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(test.want, ",") {
+				t.Errorf("expected secrets %v but got %v", test.want, names)
+			}
+
+			warnings := opts.errs()
+			if test.wantWarn == "" && warnings != nil {
+				t.Errorf("expected no warnings but got %v", warnings)
+			} else if test.wantWarn != "" && (warnings == nil || !strings.Contains(warnings.Error(), test.wantWarn)) {
+				t.Errorf("expected warning %q but got %v", test.wantWarn, warnings)
+			}
+		})
+	}
+}
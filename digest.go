@@ -0,0 +1,115 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// digest is an aggregate summary of the secrets generated from one or more
+// ksops generator configs. No key names or values are ever included.
+type digest struct {
+	Files   int          `json:"files" yaml:"files"`
+	Secrets int          `json:"secrets" yaml:"secrets"`
+	Keys    int          `json:"keys" yaml:"keys"`
+	PerFile []fileDigest `json:"perFile" yaml:"perFile"`
+}
+
+// fileDigest is the summary of the secrets generated from a single encrypted
+// file.
+type fileDigest struct {
+	File    string `json:"file" yaml:"file"`
+	Secrets int    `json:"secrets" yaml:"secrets"`
+	Keys    int    `json:"keys" yaml:"keys"`
+}
+
+// digestCmd parses every given ksops generator config, and writes a summary
+// of the number of files, secrets, and keys, along with a breakdown for each
+// file. Only files that contain at least one secret are counted.
+func digestCmd(args []string) error {
+	flags := flag.NewFlagSet("digest", flag.ContinueOnError)
+	format := flags.String("format", "yaml", "format of the digest, either yaml or json")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 || (*format != "yaml" && *format != "json") {
+		return fmt.Errorf("usage: ksops-dry-run digest [-format yaml|json] GENERATOR...")
+	}
+
+	opts, err := loadOptions()
+	if err != nil {
+		return err
+	}
+
+	files := make(map[string]*fileDigest)
+	for _, generator := range flags.Args() {
+		body, err := os.ReadFile(generator)
+		if err != nil {
+			return err
+		}
+
+		config, err := parseKsopsGenerator(body)
+		if err != nil {
+			return &fileError{file: generator, err: err}
+		}
+
+		// Encrypted secret files are relative to the directory containing the
+		// generator.
+		documents, err := generateSecrets(config, filepath.Dir(generator), opts)
+		if err != nil {
+			return err
+		}
+
+		for _, secret := range secretsOf(documents) {
+			file := files[secret.source]
+			if file == nil {
+				file = &fileDigest{File: secret.source}
+				files[secret.source] = file
+			}
+			file.Secrets++
+			file.Keys += len(secret.StringData) + len(secret.Data)
+		}
+	}
+
+	// Sort the files so that the output is stable.
+	summary := digest{PerFile: make([]fileDigest, 0, len(files))}
+	for _, file := range files {
+		summary.Files++
+		summary.Secrets += file.Secrets
+		summary.Keys += file.Keys
+		summary.PerFile = append(summary.PerFile, *file)
+	}
+	sort.Slice(summary.PerFile, func(i, j int) bool {
+		return summary.PerFile[i].File < summary.PerFile[j].File
+	})
+
+	if *format == "json" {
+		if err := json.NewEncoder(os.Stdout).Encode(summary); err != nil {
+			return err
+		}
+
+		return opts.errs()
+	}
+
+	encoder := yaml.NewEncoder(os.Stdout)
+	if err := encoder.Encode(summary); err != nil {
+		return err
+	}
+
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+
+	return opts.errs()
+}
@@ -0,0 +1,87 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDigest(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "single generator",
+			args: []string{"testdata/inventory/team-a/generator.yaml"},
+			want: `files: 1
+secrets: 2
+keys: 3
+perFile:
+    - file: testdata/inventory/team-a/secrets.enc.yaml
+      secrets: 2
+      keys: 3
+`,
+		},
+		{
+			name: "counts are combined across generators",
+			args: []string{"testdata/inventory/team-b/generator.yaml", "testdata/inventory/team-a/generator.yaml"},
+			want: `files: 2
+secrets: 3
+keys: 5
+perFile:
+    - file: testdata/inventory/team-a/secrets.enc.yaml
+      secrets: 2
+      keys: 3
+    - file: testdata/inventory/team-b/secrets.enc.yaml
+      secrets: 1
+      keys: 2
+`,
+		},
+		{
+			name: "json",
+			args: []string{"-format", "json", "testdata/inventory/team-a/generator.yaml", "testdata/inventory/team-b/generator.yaml"},
+			want: `{"files":2,"secrets":3,"keys":5,"perFile":[{"file":"testdata/inventory/team-a/secrets.enc.yaml","secrets":2,"keys":3},{"file":"testdata/inventory/team-b/secrets.enc.yaml","secrets":1,"keys":2}]}` + "\n",
+		},
+		{
+			name:    "no generators",
+			wantErr: "usage: ksops-dry-run digest [-format yaml|json] GENERATOR...",
+		},
+		{
+			name:    "unknown format",
+			args:    []string{"-format", "xml", "testdata/inventory/team-a/generator.yaml"},
+			wantErr: "usage: ksops-dry-run digest [-format yaml|json] GENERATOR...",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := runMain(t, append([]string{"digest"}, test.args...), nil)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("expected error %q but got %v", test.wantErr, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if output != test.want {
+				t.Errorf("expected output:\n%s\nbut got:\n%s", test.want, output)
+			}
+
+			// Neither key names nor values are ever included.
+			for _, sensitive := range []string{"username", "password", "api-key", "url", "ENC[", placeholder} {
+				if strings.Contains(output, sensitive) {
+					t.Errorf("expected no %q in the output but got:\n%s", sensitive, output)
+				}
+			}
+		})
+	}
+}
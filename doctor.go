@@ -0,0 +1,139 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// errDoctorSkip is returned by a doctor check that does not apply to the
+// current environment.
+var errDoctorSkip = errors.New("skipped")
+
+// doctorCheck represents a single, independent, diagnostic check of the
+// plugin installation.
+type doctorCheck struct {
+	name  string
+	check func() (string, error)
+}
+
+// doctorCmd runs every diagnostic check, prints a pass/fail report, and
+// returns an error if any of the checks failed.
+func doctorCmd() error {
+	checks := []doctorCheck{
+		{name: "original ksops plugin", check: doctorCheckKsopsPath},
+		{name: "plugin directory", check: doctorCheckPluginDir},
+		{name: "plugin config root", check: doctorCheckConfigRoot},
+		{name: "plugin config root writable", check: doctorCheckConfigRootWritable},
+	}
+
+	// Run every check regardless of the outcome of the previous ones, so that
+	// all problems are reported at once.
+	var failures int
+	for _, check := range checks {
+		detail, err := check.check()
+
+		switch {
+		case err == nil:
+			fmt.Fprintf(os.Stderr, "[pass] %s: %s\n", check.name, detail)
+		case errors.Is(err, errDoctorSkip):
+			fmt.Fprintf(os.Stderr, "[skip] %s: %s\n", check.name, detail)
+		default:
+			fmt.Fprintf(os.Stderr, "[fail] %s: %v\n", check.name, err)
+			failures++
+		}
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%d of %d checks failed", failures, len(checks))
+	}
+
+	return nil
+}
+
+// doctorCheckKsopsPath checks that the original ksops plugin can be located
+// and that it is an executable file.
+func doctorCheckKsopsPath() (string, error) {
+	ksopsPath, err := resolveKsopsPath()
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(ksopsPath)
+	if err != nil {
+		return "", err
+	}
+
+	if info.IsDir() {
+		return "", fmt.Errorf("%s is a directory", ksopsPath)
+	} else if info.Mode()&0o111 == 0 {
+		return "", fmt.Errorf("%s is not executable", ksopsPath)
+	}
+
+	return ksopsPath, nil
+}
+
+// doctorCheckPluginDir checks that the kustomize plugin directory for ksops
+// exists.
+func doctorCheckPluginDir() (string, error) {
+	pluginDir, err := resolvePluginDir()
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(pluginDir)
+	if err != nil {
+		return "", err
+	}
+
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", pluginDir)
+	}
+
+	return pluginDir, nil
+}
+
+// doctorCheckConfigRoot checks that the KUSTOMIZE_PLUGIN_CONFIG_ROOT directory
+// can be read from. This variable is only set by kustomize when invoking the
+// plugin, so the check is skipped if it is absent.
+func doctorCheckConfigRoot() (string, error) {
+	configRoot := os.Getenv("KUSTOMIZE_PLUGIN_CONFIG_ROOT")
+	if configRoot == "" {
+		return "KUSTOMIZE_PLUGIN_CONFIG_ROOT is not set", errDoctorSkip
+	}
+
+	entries, err := os.ReadDir(configRoot)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s (%d entries)", configRoot, len(entries)), nil
+}
+
+// doctorCheckConfigRootWritable checks that files can be created in the
+// KUSTOMIZE_PLUGIN_CONFIG_ROOT directory, by creating and then removing a
+// temporary file. Like the check that it can be read from, the check is
+// skipped if the variable is absent.
+func doctorCheckConfigRootWritable() (string, error) {
+	configRoot := os.Getenv("KUSTOMIZE_PLUGIN_CONFIG_ROOT")
+	if configRoot == "" {
+		return "KUSTOMIZE_PLUGIN_CONFIG_ROOT is not set", errDoctorSkip
+	}
+
+	file, err := os.CreateTemp(configRoot, ".ksops-dry-run-doctor-*")
+	if err != nil {
+		return "", err
+	}
+	file.Close()
+
+	if err := os.Remove(file.Name()); err != nil {
+		return "", err
+	}
+
+	return configRoot, nil
+}
@@ -0,0 +1,69 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDoctorCheckConfigRoot(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name       string
+		configRoot string
+		wantSkip   bool
+		wantErr    bool
+	}{
+		{
+			name:     "not set",
+			wantSkip: true,
+		},
+		{
+			name:       "readable and writable",
+			configRoot: dir,
+		},
+		{
+			name:       "missing",
+			configRoot: filepath.Join(dir, "missing"),
+			wantErr:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("KUSTOMIZE_PLUGIN_CONFIG_ROOT", test.configRoot)
+
+			// The checks are independent, and each reports on its own.
+			for name, check := range map[string]func() (string, error){
+				"readable": doctorCheckConfigRoot,
+				"writable": doctorCheckConfigRootWritable,
+			} {
+				_, err := check()
+				switch {
+				case test.wantSkip:
+					if !errors.Is(err, errDoctorSkip) {
+						t.Errorf("expected %s check to be skipped but got %v", name, err)
+					}
+				case test.wantErr:
+					if err == nil || errors.Is(err, errDoctorSkip) {
+						t.Errorf("expected %s check to fail but got %v", name, err)
+					}
+				case err != nil:
+					t.Errorf("expected %s check to pass but got %v", name, err)
+				}
+			}
+
+			// The writable check never leaves anything behind.
+			if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+				t.Errorf("expected the config root to be left empty but got %d entries", len(entries))
+			}
+		})
+	}
+}
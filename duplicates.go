@@ -0,0 +1,45 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"gopkg.in/yaml.v3"
+)
+
+// dropDuplicates removes every duplicated key in the given mapping fields of
+// the given resource, keeping only the last value for each key, and returns
+// the keys that were duplicated. The decoder would otherwise fail on them
+// with an error that names neither the field nor the secret.
+func dropDuplicates(resource *yaml.Node, fields ...string) []string {
+	var duplicates []string
+	for _, field := range fields {
+		values := mappingValue(resource, field)
+		if values == nil || values.Kind != yaml.MappingNode {
+			continue
+		}
+
+		// Find the position of the last value for each key.
+		last := make(map[string]int, len(values.Content)/2)
+		for i := 0; i+1 < len(values.Content); i += 2 {
+			last[values.Content[i].Value] = i
+		}
+
+		content := values.Content[:0]
+		for i := 0; i+1 < len(values.Content); i += 2 {
+			key := values.Content[i].Value
+			if last[key] != i {
+				duplicates = append(duplicates, field+"."+key)
+
+				continue
+			}
+
+			content = append(content, values.Content[i], values.Content[i+1])
+		}
+		values.Content = content
+	}
+
+	return duplicates
+}
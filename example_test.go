@@ -0,0 +1,70 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func Example_stubFiles() {
+	opts, err := loadOptions()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	// Two encrypted files, held in memory rather than on disk.
+	files := map[string][]byte{
+		"database.enc.yaml": []byte(`apiVersion: v1
+kind: Secret
+metadata:
+    name: database
+    namespace: prod
+stringData:
+    password: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]
+`),
+		"app.enc.yaml": []byte(`apiVersion: v1
+kind: Secret
+metadata:
+    name: app
+    namespace: prod
+data:
+    token: ENC[AES256_GCM,data:dG9rZW4=,iv:aXY=,tag:dGFn,type:str]
+`),
+	}
+
+	documents, err := stubFiles(files, opts)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if err := writeDocuments(os.Stdout, documents, opts); err != nil {
+		fmt.Println(err)
+	}
+
+	// Output:
+	// apiVersion: v1
+	// kind: Secret
+	// metadata:
+	//     labels:
+	//         ksops-dry-run.joshdk.github.com: "true"
+	//     name: app
+	//     namespace: prod
+	// stringData:
+	//     token: KSOPS_DRY_RUN_PLACEHOLDER
+	// ---
+	// apiVersion: v1
+	// kind: Secret
+	// metadata:
+	//     labels:
+	//         ksops-dry-run.joshdk.github.com: "true"
+	//     name: database
+	//     namespace: prod
+	// stringData:
+	//     password: KSOPS_DRY_RUN_PLACEHOLDER
+}
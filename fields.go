@@ -0,0 +1,66 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// parseFieldPolicy parses the given comma separated list of type=field pairs,
+// which map secret types to the field (either data or stringData) that the
+// values of those secrets are written to.
+func parseFieldPolicy(value string) (map[string]string, error) {
+	policy := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		secretType, field, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || secretType == "" || (field != "data" && field != "stringData") {
+			return nil, fmt.Errorf("expected KSOPS_DRY_RUN_FIELD_POLICY to be a list of type=data or type=stringData but got %q", pair)
+		}
+		policy[secretType] = field
+	}
+
+	return policy, nil
+}
+
+// moveFields returns the given stringData and data, with every value moved
+// into the given field. Values moved into data are base64 encoded, and values
+// moved into stringData are decoded, except for those that are not valid
+// base64, which are left in data.
+func moveFields(stringData, data map[string]string, field string) (map[string]string, map[string]string) {
+	switch field {
+	case "data":
+		if data == nil && len(stringData) > 0 {
+			data = make(map[string]string, len(stringData))
+		}
+		for key, value := range stringData {
+			data[key] = base64.StdEncoding.EncodeToString([]byte(value))
+		}
+
+		return nil, data
+	case "stringData":
+		for key, value := range data {
+			decoded, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				continue
+			}
+
+			if stringData == nil {
+				stringData = make(map[string]string, len(data))
+			}
+			stringData[key] = string(decoded)
+			delete(data, key)
+		}
+		if len(data) == 0 {
+			data = nil
+		}
+
+		return stringData, data
+	default:
+		return stringData, data
+	}
+}
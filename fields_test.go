@@ -0,0 +1,241 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestFieldPolicy(t *testing.T) {
+	content := `apiVersion: v1
+kind: Secret
+metadata:
+  name: tls
+type: kubernetes.io/tls
+stringData:
+  tls.crt: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]
+  tls.key: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: opaque
+type: Opaque
+data:
+  password: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: untyped
+data:
+  password: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: basic-auth
+type: kubernetes.io/basic-auth
+stringData:
+  password: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]
+`
+
+	encoded := base64.StdEncoding.EncodeToString([]byte(placeholder))
+
+	// fields describes where the values of a secret were written.
+	type fields struct {
+		stringData map[string]string
+		data       map[string]string
+	}
+
+	unchanged := map[string]fields{
+		"tls":        {stringData: map[string]string{"tls.crt": placeholder, "tls.key": placeholder}},
+		"opaque":     {stringData: map[string]string{"password": placeholder}},
+		"untyped":    {stringData: map[string]string{"password": placeholder}},
+		"basic-auth": {stringData: map[string]string{"password": placeholder}},
+	}
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		want map[string]fields
+	}{
+		{
+			name: "unset",
+			want: unchanged,
+		},
+		{
+			name: "data for a type",
+			env:  map[string]string{"KSOPS_DRY_RUN_FIELD_POLICY": "kubernetes.io/tls=data"},
+			want: map[string]fields{
+				"tls":        {data: map[string]string{"tls.crt": encoded, "tls.key": encoded}},
+				"opaque":     unchanged["opaque"],
+				"untyped":    unchanged["untyped"],
+				"basic-auth": unchanged["basic-auth"],
+			},
+		},
+		{
+			name: "data for opaque and untyped",
+			env:  map[string]string{"KSOPS_DRY_RUN_FIELD_POLICY": "Opaque=data"},
+			want: map[string]fields{
+				"tls":        unchanged["tls"],
+				"opaque":     {data: map[string]string{"password": encoded}},
+				"untyped":    {data: map[string]string{"password": encoded}},
+				"basic-auth": unchanged["basic-auth"],
+			},
+		},
+		{
+			name: "several types",
+			env:  map[string]string{"KSOPS_DRY_RUN_FIELD_POLICY": "kubernetes.io/tls=data, Opaque=data, kubernetes.io/basic-auth=stringData"},
+			want: map[string]fields{
+				"tls":        {data: map[string]string{"tls.crt": encoded, "tls.key": encoded}},
+				"opaque":     {data: map[string]string{"password": encoded}},
+				"untyped":    {data: map[string]string{"password": encoded}},
+				"basic-auth": unchanged["basic-auth"],
+			},
+		},
+		{
+			name: "server side safe takes precedence",
+			env:  map[string]string{"KSOPS_DRY_RUN_FIELD_POLICY": "Opaque=stringData", "KSOPS_DRY_RUN_SERVER_SIDE_SAFE": ""},
+			want: map[string]fields{
+				"tls":        {data: map[string]string{"tls.crt": encoded, "tls.key": encoded}},
+				"opaque":     {data: map[string]string{"password": encoded}},
+				"untyped":    {data: map[string]string{"password": encoded}},
+				"basic-auth": {data: map[string]string{"password": encoded}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := stubString(t, content, testOptions(t, test.env))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoder := yaml.NewDecoder(strings.NewReader(output))
+			for range test.want {
+				var stubbed secret
+				if err := decoder.Decode(&stubbed); err != nil {
+					t.Fatal(err)
+				}
+
+				want := test.want[stubbed.Metadata.Name]
+				if got, want := fmt.Sprint(stubbed.StringData), fmt.Sprint(want.stringData); got != want {
+					t.Errorf("expected secret %q stringData %s but got %s", stubbed.Metadata.Name, want, got)
+				}
+				if got, want := fmt.Sprint(stubbed.Data), fmt.Sprint(want.data); got != want {
+					t.Errorf("expected secret %q data %s but got %s", stubbed.Metadata.Name, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestParseFieldPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "single pair",
+			value: "kubernetes.io/tls=data",
+			want:  "map[kubernetes.io/tls:data]",
+		},
+		{
+			name:  "several pairs",
+			value: "kubernetes.io/tls=data, Opaque=stringData",
+			want:  "map[Opaque:stringData kubernetes.io/tls:data]",
+		},
+		{
+			name:    "unknown field",
+			value:   "Opaque=binaryData",
+			wantErr: `expected KSOPS_DRY_RUN_FIELD_POLICY to be a list of type=data or type=stringData but got "Opaque=binaryData"`,
+		},
+		{
+			name:    "missing field",
+			value:   "Opaque",
+			wantErr: `expected KSOPS_DRY_RUN_FIELD_POLICY to be a list of type=data or type=stringData but got "Opaque"`,
+		},
+		{
+			name:    "missing type",
+			value:   "=data",
+			wantErr: `expected KSOPS_DRY_RUN_FIELD_POLICY to be a list of type=data or type=stringData but got "=data"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy, err := parseFieldPolicy(test.value)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("expected error %q but got %v", test.wantErr, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := fmt.Sprint(policy); got != test.want {
+				t.Errorf("expected policy %s but got %s", test.want, got)
+			}
+		})
+	}
+}
+
+func TestMoveFields(t *testing.T) {
+	tests := []struct {
+		name           string
+		stringData     map[string]string
+		data           map[string]string
+		field          string
+		wantStringData string
+		wantData       string
+	}{
+		{
+			name:           "into data",
+			stringData:     map[string]string{"username": "admin"},
+			data:           map[string]string{"password": "aHVudGVyMg=="},
+			field:          "data",
+			wantStringData: "map[]",
+			wantData:       "map[password:aHVudGVyMg== username:YWRtaW4=]",
+		},
+		{
+			name:           "into stringData",
+			stringData:     map[string]string{"username": "admin"},
+			data:           map[string]string{"password": "aHVudGVyMg=="},
+			field:          "stringData",
+			wantStringData: "map[password:hunter2 username:admin]",
+			wantData:       "map[]",
+		},
+		{
+			name:           "invalid base64 is left in data",
+			data:           map[string]string{"password": "aHVudGVyMg==", "invalid": "not base64!"},
+			field:          "stringData",
+			wantStringData: "map[password:hunter2]",
+			wantData:       "map[invalid:not base64!]",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stringData, data := moveFields(test.stringData, test.data, test.field)
+			if got := fmt.Sprint(stringData); got != test.wantStringData {
+				t.Errorf("expected stringData %s but got %s", test.wantStringData, got)
+			}
+			if got := fmt.Sprint(data); got != test.wantData {
+				t.Errorf("expected data %s but got %s", test.wantData, got)
+			}
+		})
+	}
+}
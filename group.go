@@ -0,0 +1,124 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// defaultNamespace is the namespace that resources without a namespace are
+// grouped under, as that is where the cluster would place them by default.
+const defaultNamespace = "default"
+
+// writeGrouped writes every document to a file named after its namespace
+// (e.g. <dir>/<namespace>.yaml) in the given directory. Documents within each
+// file keep their original order.
+func writeGrouped(dir string, documents []document, opts *options) error {
+	groups := make(map[string][]document)
+	for _, document := range documents {
+		namespace := document.namespace()
+		if namespace == "" {
+			namespace = defaultNamespace
+		}
+
+		// The namespace is used as a filename, so guard against one that
+		// could escape the directory.
+		if !namespacePattern.MatchString(namespace) {
+			return fmt.Errorf("expected a valid namespace name but got %q", namespace)
+		}
+
+		groups[namespace] = append(groups[namespace], document)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	namespaces := make([]string, 0, len(groups))
+	for namespace := range groups {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	for _, namespace := range namespaces {
+		if err := writeGroup(filepath.Join(dir, namespace+".yaml"), groups[namespace], opts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeGroup writes the given documents to the file with the given name.
+func writeGroup(filename string, documents []document, opts *options) error {
+	output, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	if err := writeDocuments(output, documents, opts); err != nil {
+		output.Close()
+
+		return err
+	}
+
+	return output.Close()
+}
+
+// writeTree writes every stubbed secret to a separate file named after its
+// namespace and name (e.g. <dir>/<namespace>/<name>.yaml) in the given
+// directory, for browsing by namespace. Two secrets with the same namespace
+// and name are an error, and any other resources are omitted.
+func writeTree(dir string, documents []document, opts *options) error {
+	// Every filename is checked before any are written, so that an error
+	// never leaves a partial tree.
+	var filenames []string
+	var secrets []document
+	seen := make(map[string]struct{})
+	for _, resource := range documents {
+		if resource.secret == nil {
+			continue
+		}
+
+		namespace := resource.namespace()
+		if namespace == "" {
+			namespace = defaultNamespace
+		}
+
+		// The namespace and name are used as a path, so guard against ones
+		// that could escape the directory.
+		name := resource.secret.Metadata.Name
+		if !namespacePattern.MatchString(namespace) {
+			return fmt.Errorf("expected a valid namespace name but got %q", namespace)
+		} else if !filenamePattern.MatchString(name) {
+			return fmt.Errorf("secret %q cannot be written to a file without a valid name", resource.secret.displayName())
+		}
+
+		filename := filepath.Join(dir, namespace, name+".yaml")
+		if _, found := seen[filename]; found {
+			return fmt.Errorf("secret %q was generated more than once", namespace+"/"+name)
+		}
+		seen[filename] = struct{}{}
+
+		filenames = append(filenames, filename)
+		secrets = append(secrets, resource)
+	}
+
+	for i, filename := range filenames {
+		if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+			return err
+		}
+
+		if err := writeGroup(filename, secrets[i:i+1], opts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
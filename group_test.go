@@ -0,0 +1,93 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestWriteTree(t *testing.T) {
+	tests := []struct {
+		name      string
+		files     []string
+		wantFiles []string
+		wantErr   string
+	}{
+		{
+			name:  "by namespace",
+			files: []string{"policy/compliant.enc.yaml", "policy/wrong-recipient.enc.yaml", "warnings/no-namespace.enc.yaml"},
+			wantFiles: []string{
+				"default/app.yaml",
+				"prod/cache.yaml",
+				"prod/database.yaml",
+			},
+		},
+		{
+			name:    "collision",
+			files:   []string{"policy/compliant.enc.yaml", "warnings/no-namespace.enc.yaml", "policy/compliant.enc.yaml"},
+			wantErr: `secret "prod/database" was generated more than once`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "tree")
+
+			env := pluginEnv("testdata", test.files...)
+			env["KSOPS_DRY_RUN_TREE_DIR"] = dir
+
+			output, err := runMain(t, []string{"generator.yaml"}, env)
+			if output != "" {
+				t.Errorf("expected no output but got:\n%s", output)
+			}
+
+			var files []string
+			filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+				if err == nil && !entry.IsDir() {
+					relative, _ := filepath.Rel(dir, path)
+					files = append(files, filepath.ToSlash(relative))
+				}
+
+				return nil
+			})
+			sort.Strings(files)
+
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected error %q but got %v", test.wantErr, err)
+				}
+
+				// Nothing is written, as every filename is checked first.
+				if len(files) != 0 {
+					t.Errorf("expected no files but got %v", files)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if strings.Join(files, ",") != strings.Join(test.wantFiles, ",") {
+				t.Fatalf("expected files %v but got %v", test.wantFiles, files)
+			}
+			for _, file := range files {
+				body, err := os.ReadFile(filepath.Join(dir, file))
+				if err != nil {
+					t.Fatal(err)
+				}
+				name := strings.TrimSuffix(filepath.Base(file), ".yaml")
+				if !strings.Contains(string(body), "name: "+name+"\n") {
+					t.Errorf("expected %s to contain secret %q but got:\n%s", file, name, body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+)
+
+// secretHash returns a content hash of the given secret, in the same format
+// that kustomize uses as a name suffix for generated secrets.
+// See https://github.com/kubernetes-sigs/kustomize/blob/master/api/hasher/hasher.go.
+func secretHash(secret secret) (string, error) {
+	content := map[string]any{
+		"kind": "Secret",
+		"type": secret.Type,
+		"name": secret.Metadata.Name,
+		"data": secret.Data,
+	}
+	if len(secret.StringData) > 0 {
+		content["stringData"] = secret.StringData
+	}
+
+	body, err := json.Marshal(content)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(body)
+
+	// Kustomize uses the first 10 hex characters, with some characters
+	// substituted to avoid accidentally forming words.
+	return strings.NewReplacer("0", "g", "1", "h", "3", "k", "a", "m", "e", "t").Replace(hex.EncodeToString(sum[:])[:10]), nil
+}
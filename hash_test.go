@@ -0,0 +1,184 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// hashPattern matches a kustomize name hash, which never contains the
+// characters that are substituted to avoid forming words.
+var hashPattern = regexp.MustCompile(`^[2456789bcdfghkmt]{10}$`)
+
+func TestSecretHash(t *testing.T) {
+	base := secret{
+		Type: "Opaque",
+		Data: map[string]string{"password": "ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]"},
+	}
+	base.Metadata.Name = "database"
+
+	changed := base
+	changed.Data = map[string]string{"password": "ENC[AES256_GCM,data:bm90IGl0,iv:aXY=,tag:dGFn,type:str]"}
+
+	renamed := base
+	renamed.Metadata.Name = "cache"
+
+	want, err := secretHash(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hashPattern.MatchString(want) {
+		t.Fatalf("expected a kustomize style hash but got %q", want)
+	}
+
+	tests := []struct {
+		name   string
+		secret secret
+		same   bool
+	}{
+		{
+			name:   "same content",
+			secret: base,
+			same:   true,
+		},
+		{
+			name:   "changed data",
+			secret: changed,
+		},
+		{
+			name:   "changed name",
+			secret: renamed,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hash, err := secretHash(test.secret)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !hashPattern.MatchString(hash) {
+				t.Errorf("expected a kustomize style hash but got %q", hash)
+			}
+			if same := hash == want; same != test.same {
+				t.Errorf("expected hash %q compared to %q to be the same: %t", hash, want, test.same)
+			}
+		})
+	}
+}
+
+func TestHashPreview(t *testing.T) {
+	content := `apiVersion: v1
+kind: Secret
+metadata:
+  name: database
+stringData:
+  password: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]
+sops:
+  version: 3.7.3
+`
+
+	opts := testOptions(t, map[string]string{"KSOPS_DRY_RUN_HASH_PREVIEW": ""})
+	opts.quiet = false
+
+	var output string
+	stderr := captureStderr(t, func() {
+		var err error
+		if output, err = stubString(t, content, opts); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	match := regexp.MustCompile(`secret "database" would be named database-(\S+)`).FindStringSubmatch(stderr)
+	if match == nil {
+		t.Fatalf("expected a hash preview but got:\n%s", stderr)
+	}
+	if !hashPattern.MatchString(match[1]) {
+		t.Errorf("expected a kustomize style hash but got %q", match[1])
+	}
+
+	// The preview is a diagnostic only, and never changes the manifest.
+	if strings.Contains(output, match[1]) {
+		t.Errorf("expected the hash to be absent from the output but got:\n%s", output)
+	}
+}
+
+func TestHashComment(t *testing.T) {
+	content := `apiVersion: v1
+kind: Secret
+metadata:
+  name: database
+  namespace: prod
+stringData:
+  password: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: cache
+  namespace: prod
+stringData:
+  password: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]
+`
+
+	tests := []struct {
+		name         string
+		env          map[string]string
+		wantComments int
+	}{
+		{
+			name: "unset",
+		},
+		{
+			name:         "set",
+			env:          map[string]string{"KSOPS_DRY_RUN_HASH_COMMENT": ""},
+			wantComments: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := stubString(t, content, testOptions(t, test.env))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// By default, nothing is written that a strict parser of the
+			// output could trip over.
+			comments := regexp.MustCompile(`(?m)^# name-suffix: (\S+)$`).FindAllStringSubmatch(output, -1)
+			if len(comments) != test.wantComments {
+				t.Fatalf("expected %d hash comments but got:\n%s", test.wantComments, output)
+			}
+			if test.wantComments == 0 && strings.Contains(output, "#") {
+				t.Errorf("expected no comments but got:\n%s", output)
+			}
+
+			for _, comment := range comments {
+				if !hashPattern.MatchString(comment[1]) {
+					t.Errorf("expected a kustomize style hash but got %q", comment[1])
+				}
+			}
+
+			// Secrets with different names have different hashes.
+			if len(comments) == 2 && comments[0][1] == comments[1][1] {
+				t.Errorf("expected different hashes but got %q twice", comments[0][1])
+			}
+
+			// The comments never change the decoded manifest.
+			var stubbed secret
+			if err := yaml.Unmarshal([]byte(output), &stubbed); err != nil {
+				t.Fatal(err)
+			}
+			if stubbed.Metadata.Name != "database" {
+				t.Errorf("expected secret %q but got %q", "database", stubbed.Metadata.Name)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+// writeHelmValues writes the stubbed values of every secret as a single helm
+// values document, nested under the given dot separated key path, so that it
+// can be passed to a chart with --values. Each secret is a map of its keys to
+// their placeholders, keyed by the secret name. Secrets with the same name
+// (e.g. in different namespaces) share a map, and any other resources are
+// omitted.
+func writeHelmValues(output io.Writer, documents []document, keyPath []string) error {
+	secrets := make(map[string]map[string]string)
+	for _, secret := range secretsOf(documents) {
+		name := secret.Metadata.Name
+		if name == "" {
+			name = secret.Metadata.GenerateName
+		}
+
+		if secrets[name] == nil {
+			secrets[name] = make(map[string]string)
+		}
+		for _, values := range []map[string]string{secret.StringData, secret.Data} {
+			for key, value := range values {
+				secrets[name][key] = value
+			}
+		}
+	}
+
+	// Nest the secrets under the key path, from the innermost key outwards.
+	var values any = secrets
+	for i := len(keyPath) - 1; i >= 0; i-- {
+		values = map[string]any{keyPath[i]: values}
+	}
+
+	encoder := yaml.NewEncoder(output)
+	if err := encoder.Encode(values); err != nil {
+		return err
+	}
+
+	return encoder.Close()
+}
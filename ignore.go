@@ -0,0 +1,130 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFilename is the name of the file in the config root that lists the
+// encrypted files that are skipped.
+const ignoreFilename = ".ksopsignore"
+
+// ignoreFile represents a .ksopsignore file, which lists glob patterns of
+// encrypted files (relative to the config root) that are skipped, in the same
+// style as a .gitignore file.
+type ignoreFile struct {
+	root  string
+	rules []ignoreRule
+}
+
+// ignoreRule is a single pattern from an ignore file.
+type ignoreRule struct {
+	// pattern is the glob pattern, without any leading ! or trailing /.
+	pattern string
+
+	// negate re-includes any file matched by the pattern.
+	negate bool
+
+	// anchored matches the pattern against the whole path relative to the
+	// root, rather than against any single path component.
+	anchored bool
+
+	// dir matches the pattern against directories only.
+	dir bool
+}
+
+// loadIgnoreFile reads and parses the ignore file in the given config root,
+// if there is one. Blank lines and lines starting with # are ignored.
+func loadIgnoreFile(root string) (*ignoreFile, error) {
+	filename := filepath.Join(root, ignoreFilename)
+	body, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	ignore := &ignoreFile{root: root}
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var rule ignoreRule
+		line, rule.negate = strings.CutPrefix(line, "!")
+		line, rule.dir = strings.CutSuffix(line, "/")
+		rule.anchored = strings.Contains(line, "/")
+		rule.pattern = strings.TrimPrefix(line, "/")
+
+		if _, err := path.Match(rule.pattern, ""); err != nil || rule.pattern == "" {
+			return nil, &fileError{file: filename, err: fmt.Errorf("invalid pattern on line %d", number)}
+		}
+
+		ignore.rules = append(ignore.rules, rule)
+	}
+
+	return ignore, scanner.Err()
+}
+
+// matches returns true if the given resolved encrypted file is ignored. As
+// with a .gitignore file, the last matching pattern wins, and a file inside of
+// a matching directory is also matched. Files outside of the config root are
+// never ignored.
+func (i *ignoreFile) matches(filename string) bool {
+	if i == nil {
+		return false
+	}
+
+	relative, err := filepath.Rel(i.root, filename)
+	if err != nil || relative == ".." || strings.HasPrefix(relative, "../") {
+		return false
+	}
+	components := strings.Split(filepath.ToSlash(relative), "/")
+
+	ignored := false
+	for _, rule := range i.rules {
+		if rule.matches(components) {
+			ignored = !rule.negate
+		}
+	}
+
+	return ignored
+}
+
+// matches returns true if the rule matches the file with the given path
+// components, or any of its parent directories.
+func (r ignoreRule) matches(components []string) bool {
+	// The last component is the file itself, which a directory rule can never
+	// match.
+	last := len(components)
+	if r.dir {
+		last--
+	}
+
+	for n := 1; n <= last; n++ {
+		subject := components[n-1]
+		if r.anchored {
+			subject = strings.Join(components[:n], "/")
+		}
+
+		if matched, _ := path.Match(r.pattern, subject); matched {
+			return true
+		}
+	}
+
+	return false
+}
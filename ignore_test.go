@@ -0,0 +1,124 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestIgnoreFile(t *testing.T) {
+	encrypted := func(name string) string {
+		return "apiVersion: v1\nkind: Secret\nmetadata:\n  name: " + name + "\nstringData:\n  password: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]\n"
+	}
+
+	files := []string{"app.enc.yaml", "legacy/database.enc.yaml", "legacy/cache.enc.yaml", "nested/legacy.enc.yaml"}
+
+	tests := []struct {
+		name    string
+		ignore  string
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "no ignore file",
+			want: []string{"app", "legacy-database", "legacy-cache", "nested-legacy"},
+		},
+		{
+			name:   "comments and blank lines",
+			ignore: "# Nothing is ignored.\n\n   \n",
+			want:   []string{"app", "legacy-database", "legacy-cache", "nested-legacy"},
+		},
+		{
+			name:   "directory",
+			ignore: "legacy/\n",
+			want:   []string{"app", "nested-legacy"},
+		},
+		{
+			name:   "name at any depth",
+			ignore: "legacy*\n",
+			want:   []string{"app"},
+		},
+		{
+			name:   "anchored path",
+			ignore: "/app.enc.yaml\nlegacy/cache.enc.yaml\n",
+			want:   []string{"legacy-database", "nested-legacy"},
+		},
+		{
+			name:   "negated file in an ignored directory",
+			ignore: "legacy/\n!legacy/database.enc.yaml\n",
+			want:   []string{"app", "legacy-database", "nested-legacy"},
+		},
+		{
+			name:   "negated name at any depth",
+			ignore: "*.enc.yaml\n!cache.enc.yaml\n",
+			want:   []string{"legacy-cache"},
+		},
+		{
+			name:   "negation before the pattern",
+			ignore: "!legacy/database.enc.yaml\nlegacy/\n",
+			want:   []string{"app", "nested-legacy"},
+		},
+		{
+			name:   "negation of the negation",
+			ignore: "legacy/\n!legacy/database.enc.yaml\ndatabase.enc.yaml\n",
+			want:   []string{"app", "nested-legacy"},
+		},
+		{
+			name:    "invalid pattern",
+			ignore:  "legacy/\n[\n",
+			wantErr: ".ksopsignore: invalid pattern on line 2",
+		},
+		{
+			name:    "empty negation",
+			ignore:  "!\n",
+			wantErr: ".ksopsignore: invalid pattern on line 1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			for _, file := range files {
+				name := strings.ReplaceAll(strings.TrimSuffix(file, ".enc.yaml"), "/", "-")
+				writeFiles(t, root, map[string]string{file: encrypted(name)})
+			}
+			if test.ignore != "" {
+				writeFiles(t, root, map[string]string{".ksopsignore": test.ignore})
+			}
+
+			output, err := runMain(t, nil, pluginEnv(root, files...))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected error %q but got %v", test.wantErr, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			var names []string
+			decoder := yaml.NewDecoder(strings.NewReader(output))
+			for {
+				var document secret
+				if err := decoder.Decode(&document); errors.Is(err, io.EOF) {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				names = append(names, document.Metadata.Name)
+			}
+
+			if got, want := strings.Join(names, ","), strings.Join(test.want, ","); got != want {
+				t.Errorf("expected secrets %s but got %s", want, got)
+			}
+		})
+	}
+}
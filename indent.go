@@ -0,0 +1,100 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"regexp"
+)
+
+// blockScalarPattern matches a line that starts a block scalar, whose
+// following (more indented) lines are content rather than structure.
+var blockScalarPattern = regexp.MustCompile(`(^|:|-)\s*[|>][-+0-9]*$`)
+
+// indentlessSequences rewrites the given yaml, as written by the encoder, so
+// that every sequence that is the value of a mapping key is at the same
+// indentation as that key, rather than being indented further. The yaml
+// encoder has no option for this.
+func indentlessSequences(body []byte) []byte {
+	lines := bytes.SplitAfter(body, []byte("\n"))
+
+	// region is a key whose sequence is currently being dedented, by the
+	// amount that the sequence was indented past the key.
+	type region struct {
+		key    int
+		dedent int
+	}
+
+	// regions holds every region, innermost last.
+	var regions []region
+
+	// scalar is the indentation of the line that started the current block
+	// scalar, or -1 if not in a block scalar.
+	scalar := -1
+
+	var output bytes.Buffer
+	for i, line := range lines {
+		trimmed := bytes.TrimRight(line, "\r\n")
+		content := bytes.TrimLeft(trimmed, " ")
+		column := len(trimmed) - len(content)
+
+		// Blank lines are written as-is, and end nothing.
+		if len(content) == 0 {
+			output.Write(line)
+
+			continue
+		}
+
+		// Every line that is no more indented than a key ends its region.
+		for len(regions) > 0 && column <= regions[len(regions)-1].key {
+			regions = regions[:len(regions)-1]
+		}
+
+		if scalar >= 0 && column <= scalar {
+			scalar = -1
+		}
+
+		// Every line within a region is dedented by every enclosing region.
+		var dedent int
+		for _, region := range regions {
+			dedent += region.dedent
+		}
+		if dedent > column {
+			dedent = column
+		}
+		output.Write(line[dedent:])
+
+		// Structure is only found outside of block scalars.
+		if scalar >= 0 {
+			continue
+		}
+
+		if blockScalarPattern.Match(content) {
+			scalar = column
+
+			continue
+		}
+
+		// A key with no inline value, followed by a sequence item that is
+		// indented further, starts a region. The key may itself follow a
+		// sequence item marker. How much further depends on the encoder
+		// indentation, and on whether the key is within a sequence item.
+		key := column
+		for bytes.HasPrefix(content, []byte("- ")) {
+			content = content[2:]
+			key += 2
+		}
+		if bytes.HasSuffix(content, []byte(":")) && i+1 < len(lines) {
+			next := bytes.TrimRight(lines[i+1], "\r\n")
+			item := len(next) - len(bytes.TrimLeft(next, " "))
+			if bytes.HasPrefix(next[item:], []byte("- ")) && item > key {
+				regions = append(regions, region{key: key, dedent: item - key})
+			}
+		}
+	}
+
+	return output.Bytes()
+}
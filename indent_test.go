@@ -0,0 +1,142 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestIndentlessSequences(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "no sequences",
+			input: "metadata:\n    name: app\n",
+			want:  "metadata:\n    name: app\n",
+		},
+		{
+			name:  "sequence of scalars",
+			input: "metadata:\n    finalizers:\n        - first\n        - second\n    name: app\n",
+			want:  "metadata:\n    finalizers:\n    - first\n    - second\n    name: app\n",
+		},
+		{
+			name:  "sequence of mappings",
+			input: "ownerReferences:\n    - kind: Deployment\n      name: controller\n    - kind: ConfigMap\n      name: settings\nkind: Secret\n",
+			want:  "ownerReferences:\n- kind: Deployment\n  name: controller\n- kind: ConfigMap\n  name: settings\nkind: Secret\n",
+		},
+		{
+			name:  "nested sequences",
+			input: "items:\n    - names:\n        - first\n        - second\n    - names:\n        - third\n",
+			want:  "items:\n- names:\n  - first\n  - second\n- names:\n  - third\n",
+		},
+		{
+			name:  "nested sequences with two space indentation",
+			input: "items:\n  - names:\n      - first\n    x:\n      l:\n        - second\n",
+			want:  "items:\n- names:\n  - first\n  x:\n    l:\n    - second\n",
+		},
+		{
+			name:  "mappings within nested sequences",
+			input: "items:\n    - names:\n        - first\n      x:\n        l:\n            - second\n",
+			want:  "items:\n- names:\n  - first\n  x:\n    l:\n    - second\n",
+		},
+		{
+			name:  "two space indentation",
+			input: "metadata:\n  finalizers:\n    - first\n  name: app\n",
+			want:  "metadata:\n  finalizers:\n  - first\n  name: app\n",
+		},
+		{
+			name:  "block scalar contents are untouched",
+			input: "stringData:\n    script: |\n        items:\n            - first\n    token: value\n",
+			want:  "stringData:\n    script: |\n        items:\n            - first\n    token: value\n",
+		},
+		{
+			name:  "flow sequences are untouched",
+			input: "metadata:\n    finalizers: [first, second]\n",
+			want:  "metadata:\n    finalizers: [first, second]\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := string(indentlessSequences([]byte(test.input)))
+			if got != test.want {
+				t.Errorf("expected:\n%s\nbut got:\n%s", test.want, got)
+			}
+
+			// Only the style changes, never the content.
+			var before, after any
+			if err := yaml.Unmarshal([]byte(test.input), &before); err != nil {
+				t.Fatal(err)
+			}
+			if err := yaml.Unmarshal([]byte(got), &after); err != nil {
+				t.Fatal(err)
+			}
+			if a, b := marshalString(t, before), marshalString(t, after); a != b {
+				t.Errorf("expected the same content but got:\n%s\nand:\n%s", a, b)
+			}
+		})
+	}
+}
+
+func TestIndentlessSequencesOption(t *testing.T) {
+	body, err := os.ReadFile("testdata/owner-references.enc.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{
+			name: "unset",
+			want: "    ownerReferences:\n        - apiVersion: apps/v1\n",
+		},
+		{
+			name: "set",
+			env:  map[string]string{"KSOPS_DRY_RUN_INDENTLESS_SEQUENCES": ""},
+			want: "    ownerReferences:\n    - apiVersion: apps/v1\n",
+		},
+		{
+			name: "canonical",
+			env:  map[string]string{"KSOPS_DRY_RUN_INDENTLESS_SEQUENCES": "", "KSOPS_DRY_RUN_CANONICAL": ""},
+			want: "  ownerReferences:\n  - apiVersion: apps/v1\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := stubString(t, string(body), testOptions(t, test.env))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.Contains(output, test.want) {
+				t.Errorf("expected output to contain:\n%s\nbut got:\n%s", test.want, output)
+			}
+		})
+	}
+}
+
+// marshalString returns the given value marshalled as yaml.
+func marshalString(t *testing.T, value any) string {
+	t.Helper()
+
+	body, err := yaml.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(body)
+}
@@ -0,0 +1,138 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// initCmd installs ksops-dry-run into the kustomize plugin directory, in
+// place of the original ksops plugin, which is moved aside to _ksops. It is
+// safe to run more than once.
+func initCmd(args []string) error {
+	flags := flag.NewFlagSet("init", flag.ContinueOnError)
+	force := flags.Bool("force", false, "replace an existing ksops plugin even if _ksops already exists")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	pluginDir, err := resolvePluginDir()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(pluginDir, 0o755); err != nil {
+		return err
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if self, err = filepath.EvalSymlinks(self); err != nil {
+		return err
+	}
+
+	ksopsPath := filepath.Join(pluginDir, "ksops")
+	originalPath := filepath.Join(pluginDir, "_ksops")
+
+	switch target, err := filepath.EvalSymlinks(ksopsPath); {
+	case errors.Is(err, fs.ErrNotExist):
+		// There is no ksops plugin, so there is nothing to move aside. A
+		// dangling symlink is removed so that it can be replaced.
+		if exists(ksopsPath) {
+			if err := os.Remove(ksopsPath); err != nil {
+				return err
+			}
+		}
+	case err != nil:
+		return err
+	case target == self:
+		// The ksops plugin is already ksops-dry-run.
+		fmt.Fprintf(os.Stderr, "%s is already installed\n", ksopsPath)
+	case !exists(originalPath):
+		// Move the original ksops plugin aside, so that it can be exec'd.
+		if err := os.Rename(ksopsPath, originalPath); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "moved %s to %s\n", ksopsPath, originalPath)
+	case *force:
+		if err := os.Remove(ksopsPath); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "removed %s\n", ksopsPath)
+	default:
+		return fmt.Errorf("refusing to replace %s as %s already exists, use --force to replace it anyway", ksopsPath, originalPath)
+	}
+
+	// If there is still no original ksops plugin, then write a wrapper that
+	// execs the ksops plugin found on the path, if there is one. That plugin
+	// may turn out to be ksops-dry-run itself, which would exec in a loop.
+	if !exists(originalPath) {
+		switch path, err := exec.LookPath("ksops"); {
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "warning: no original ksops plugin was found, install it to %s\n", originalPath)
+		case sameFile(path, self) || sameFile(path, originalPath):
+			fmt.Fprintf(os.Stderr, "warning: %s is ksops-dry-run itself, install the original ksops plugin to %s\n", path, originalPath)
+		default:
+			wrapper := fmt.Sprintf("#!/bin/sh\nexec %s \"$@\"\n", shellQuote(path))
+			if err := os.WriteFile(originalPath, []byte(wrapper), 0o755); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "wrote %s wrapping %s\n", originalPath, path)
+		}
+	}
+
+	if !exists(ksopsPath) {
+		if err := os.Symlink(self, ksopsPath); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "linked %s to %s\n", ksopsPath, self)
+	}
+
+	fmt.Fprintln(os.Stderr, "\nto fake the decryption of ksops secrets, set the following before running kustomize:")
+	fmt.Fprintf(os.Stderr, "  export %s=\n", envName("KSOPS_DRY_RUN"))
+
+	return nil
+}
+
+// exists returns true if the given file exists. A dangling symlink is
+// considered to exist.
+func exists(filename string) bool {
+	_, err := os.Lstat(filename)
+
+	return err == nil
+}
+
+// sameFile returns true if the given files both exist, and are the same file
+// once any symlinks are followed.
+func sameFile(a, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(infoA, infoB)
+}
+
+// shellQuote returns the given value single quoted, so that it is never
+// expanded by the shell. An embedded single quote ends the quoting, is
+// escaped, and then starts it again.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
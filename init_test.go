@@ -0,0 +1,272 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setupInit points the plugin directory at a temporary home directory, and
+// the path at a temporary directory whose name needs shell quoting, which are
+// both returned.
+func setupInit(t *testing.T) (string, string) {
+	t.Helper()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	bin := filepath.Join(t.TempDir(), "bin $HOME `true` 'quoted'")
+	if err := os.Mkdir(bin, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+
+	return filepath.Join(home, ".config/kustomize/plugin/viaduct.ai/v1/ksops"), bin
+}
+
+// writeScript writes an executable shell script that echoes the given
+// message, followed by its arguments.
+func writeScript(t *testing.T, filename, message string) {
+	t.Helper()
+
+	if err := os.WriteFile(filename, []byte("#!/bin/sh\necho "+message+" \"$@\"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// runScript runs the given executable, and returns its output.
+func runScript(t *testing.T, filename string) string {
+	t.Helper()
+
+	output, err := exec.Command(filename, "arg").CombinedOutput()
+	if err != nil {
+		t.Fatalf("running %s: %v: %s", filename, err, output)
+	}
+
+	return string(output)
+}
+
+// checkInstalled checks that the ksops plugin in the given plugin directory
+// is a symlink to this executable.
+func checkInstalled(t *testing.T, pluginDir string) {
+	t.Helper()
+
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !sameFile(filepath.Join(pluginDir, "ksops"), self) {
+		t.Errorf("expected %s to be linked to %s", filepath.Join(pluginDir, "ksops"), self)
+	}
+}
+
+func TestInitWrapsKsopsOnPath(t *testing.T) {
+	pluginDir, bin := setupInit(t)
+	writeScript(t, filepath.Join(bin, "ksops"), "original")
+
+	// Running more than once leaves the same installation.
+	for i := 0; i < 2; i++ {
+		if err := initCmd(nil); err != nil {
+			t.Fatal(err)
+		}
+
+		checkInstalled(t, pluginDir)
+
+		// The path is never expanded by the shell.
+		if output := runScript(t, filepath.Join(pluginDir, "_ksops")); output != "original arg\n" {
+			t.Errorf("expected the wrapper to run the original ksops but got %q", output)
+		}
+	}
+}
+
+func TestInitMovesExistingPluginAside(t *testing.T) {
+	pluginDir, _ := setupInit(t)
+	if err := os.MkdirAll(pluginDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeScript(t, filepath.Join(pluginDir, "ksops"), "existing")
+
+	if err := initCmd(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	checkInstalled(t, pluginDir)
+	if output := runScript(t, filepath.Join(pluginDir, "_ksops")); output != "existing arg\n" {
+		t.Errorf("expected the existing ksops to be moved aside but got %q", output)
+	}
+}
+
+func TestInitRefusesToReplaceWithoutForce(t *testing.T) {
+	pluginDir, _ := setupInit(t)
+	if err := os.MkdirAll(pluginDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeScript(t, filepath.Join(pluginDir, "ksops"), "existing")
+	writeScript(t, filepath.Join(pluginDir, "_ksops"), "original")
+
+	if err := initCmd(nil); err == nil || !strings.Contains(err.Error(), "use --force") {
+		t.Fatalf("expected a refusal but got %v", err)
+	}
+	if output := runScript(t, filepath.Join(pluginDir, "ksops")); output != "existing arg\n" {
+		t.Errorf("expected the existing ksops to be kept but got %q", output)
+	}
+
+	if err := initCmd([]string{"--force"}); err != nil {
+		t.Fatal(err)
+	}
+
+	checkInstalled(t, pluginDir)
+	if output := runScript(t, filepath.Join(pluginDir, "_ksops")); output != "original arg\n" {
+		t.Errorf("expected the original ksops to be kept but got %q", output)
+	}
+}
+
+func TestInitNeverWrapsItself(t *testing.T) {
+	pluginDir, bin := setupInit(t)
+
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(self, filepath.Join(bin, "ksops")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := initCmd(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	checkInstalled(t, pluginDir)
+	if exists(filepath.Join(pluginDir, "_ksops")) {
+		t.Errorf("expected no wrapper of ksops-dry-run itself")
+	}
+}
+
+func TestFindSiblingKsops(t *testing.T) {
+	tests := []struct {
+		name  string
+		self  string
+		files map[string]os.FileMode
+		want  string
+	}{
+		{
+			name:  "underscore sibling",
+			self:  "ksops-dry-run",
+			files: map[string]os.FileMode{"_ksops": 0o755, "ksops": 0o755},
+			want:  "_ksops",
+		},
+		{
+			name:  "plain sibling",
+			self:  "ksops-dry-run",
+			files: map[string]os.FileMode{"ksops": 0o755},
+			want:  "ksops",
+		},
+		{
+			name:  "installed as ksops",
+			self:  "ksops",
+			files: map[string]os.FileMode{"_ksops": 0o755},
+			want:  "_ksops",
+		},
+		{
+			name: "installed as ksops without a sibling",
+			self: "ksops",
+		},
+		{
+			name:  "not executable",
+			self:  "ksops-dry-run",
+			files: map[string]os.FileMode{"_ksops": 0o644},
+		},
+		{
+			name: "without a sibling",
+			self: "ksops-dry-run",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeScript(t, filepath.Join(dir, test.self), "self")
+			for name, mode := range test.files {
+				writeScript(t, filepath.Join(dir, name), name)
+				if err := os.Chmod(filepath.Join(dir, name), mode); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			path, found := findSiblingKsops(filepath.Join(dir, test.self))
+			if test.want == "" {
+				if found {
+					t.Fatalf("expected no sibling but got %s", path)
+				}
+
+				return
+			}
+			if want := filepath.Join(dir, test.want); !found || path != want {
+				t.Errorf("expected sibling %s but got %q", want, path)
+			}
+		})
+	}
+}
+
+func TestResolveKsopsPath(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, filepath.Join(dir, "ksops-dry-run"), "self")
+	writeScript(t, filepath.Join(dir, "_ksops"), "sibling")
+
+	original := os.Args
+	defer func() {
+		os.Args = original
+	}()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	tests := []struct {
+		name      string
+		ksopsPath string
+		args0     string
+		want      string
+	}{
+		{
+			name:      "explicit path wins",
+			ksopsPath: "/opt/ksops",
+			args0:     filepath.Join(dir, "ksops-dry-run"),
+			want:      "/opt/ksops",
+		},
+		{
+			name:  "sibling",
+			args0: filepath.Join(dir, "ksops-dry-run"),
+			want:  filepath.Join(dir, "_ksops"),
+		},
+		{
+			name:  "found on the path",
+			args0: "ksops-dry-run",
+			want:  filepath.Join(home, ".config/kustomize/plugin/viaduct.ai/v1/ksops/_ksops"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("KSOPS_PATH", test.ksopsPath)
+			os.Args = []string{test.args0}
+
+			path, err := resolveKsopsPath()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if path != test.want {
+				t.Errorf("expected %s but got %s", test.want, path)
+			}
+		})
+	}
+}
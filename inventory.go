@@ -0,0 +1,83 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// inventoryCmd parses every given ksops generator config, and writes a
+// listing of the key names of every secret, keyed by the secret name. No
+// values are ever written.
+func inventoryCmd(generators []string) error {
+	if len(generators) == 0 {
+		return fmt.Errorf("usage: ksops-dry-run inventory GENERATOR...")
+	}
+
+	opts, err := loadOptions()
+	if err != nil {
+		return err
+	}
+
+	// The keys of every secret are collected as a set, as secrets with the
+	// same name may appear in more than one file.
+	keys := make(map[string]map[string]struct{})
+	for _, generator := range generators {
+		body, err := os.ReadFile(generator)
+		if err != nil {
+			return err
+		}
+
+		config, err := parseKsopsGenerator(body)
+		if err != nil {
+			return &fileError{file: generator, err: err}
+		}
+
+		// Encrypted secret files are relative to the directory containing the
+		// generator.
+		documents, err := generateSecrets(config, filepath.Dir(generator), opts)
+		if err != nil {
+			return err
+		}
+
+		for _, secret := range secretsOf(documents) {
+			name := secret.displayName()
+			if keys[name] == nil {
+				keys[name] = make(map[string]struct{})
+			}
+			for _, key := range keysOfSecret(&secret) {
+				keys[name][key] = struct{}{}
+			}
+		}
+	}
+
+	// Sort the keys of every secret so that the output is stable.
+	inventory := make(map[string][]string, len(keys))
+	for name, set := range keys {
+		list := make([]string, 0, len(set))
+		for key := range set {
+			list = append(list, key)
+		}
+		sort.Strings(list)
+		inventory[name] = list
+	}
+
+	encoder := yaml.NewEncoder(os.Stdout)
+	if err := encoder.Encode(inventory); err != nil {
+		return err
+	}
+
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+
+	return opts.errs()
+}
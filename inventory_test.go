@@ -0,0 +1,67 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import "testing"
+
+func TestInventory(t *testing.T) {
+	tests := []struct {
+		name       string
+		generators []string
+		want       string
+	}{
+		{
+			name:       "single generator",
+			generators: []string{"testdata/inventory/team-a/generator.yaml"},
+			want: `prod/app:
+    - password
+    - username
+prod/database:
+    - url
+`,
+		},
+		{
+			name:       "keys are combined across generators",
+			generators: []string{"testdata/inventory/team-a/generator.yaml", "testdata/inventory/team-b/generator.yaml"},
+			want: `prod/app:
+    - api-key
+    - password
+    - username
+prod/database:
+    - url
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := runMain(t, append([]string{"inventory"}, test.generators...), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if output != test.want {
+				t.Errorf("expected output:\n%s\nbut got:\n%s", test.want, output)
+			}
+		})
+	}
+}
+
+// Keys of binary values are kept in data, rather than stringData.
+func TestInventoryBinaryValues(t *testing.T) {
+	output, err := runMain(t, []string{"inventory", "testdata/binary/generator.yaml"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `prod/app:
+    - tls.crt
+    - tls.key
+`
+	if output != want {
+		t.Errorf("expected output:\n%s\nbut got:\n%s", want, output)
+	}
+}
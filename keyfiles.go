@@ -0,0 +1,89 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// secretKeyPattern matches valid secret keys.
+var secretKeyPattern = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+
+// keyFile represents a key=path entry in a generator config, in the style of
+// a kustomize secretGenerator, where the file contains the value of a single
+// key rather than an entire secret.
+type keyFile struct {
+	key  string
+	path string
+}
+
+// parseKeyFile parses the given generator files entry as a key=path entry.
+func parseKeyFile(entry string) (keyFile, bool) {
+	if isRemote(entry) {
+		return keyFile{}, false
+	}
+
+	key, path, found := strings.Cut(entry, "=")
+	if !found || path == "" || !secretKeyPattern.MatchString(key) {
+		return keyFile{}, false
+	}
+
+	return keyFile{key: key, path: path}, true
+}
+
+// stubKeyFiles returns a single stubbed secret, named after the given
+// generator config, with a key for each of the given key files, whose paths
+// have already been resolved.
+func stubKeyFiles(config *ksopsGeneratorConfig, keyFiles []keyFile, opts *options) ([]document, error) {
+	filename := keyFiles[0].path
+	if config.Metadata.Name == "" {
+		return nil, &fileError{file: filename, err: fmt.Errorf("expected ksops generator config to have a name for key=path files")}
+	}
+
+	// The synthesized secret is written out as an encrypted secret, so that it
+	// is stubbed in exactly the same way as any other.
+	secret := secret{
+		common: common{
+			APIVersion: "v1",
+			Kind:       "Secret",
+			Metadata: metadata{
+				Name:      config.Metadata.Name,
+				Namespace: config.Metadata.Namespace,
+			},
+		},
+		StringData: make(map[string]string, len(keyFiles)),
+	}
+
+	for _, keyFile := range keyFiles {
+		body, err := readFile(keyFile.path, opts)
+		if err != nil {
+			return nil, err
+		}
+		secret.StringData[keyFile.key] = string(body)
+
+		// Every key file is audited as it was read.
+		sum := sha256.Sum256(body)
+		opts.audit.record(keyFile.path, sum[:])
+	}
+
+	body, err := yaml.Marshal(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	// The synthesized secret was never read from a file, so it is not
+	// audited itself.
+	unaudited := *opts
+	unaudited.audit = nil
+
+	return stubKsopsEncryptedSecrets(bytes.NewReader(body), filename, &unaudited)
+}
@@ -0,0 +1,109 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestKeyFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   []string
+		env     map[string]string
+		ignore  string
+		want    map[string][]string
+		wantErr string
+	}{
+		{
+			name:  "key=path entries",
+			files: []string{"username=username.enc", "password=password.enc"},
+			want:  map[string][]string{"prod/credentials": {"password", "username"}},
+		},
+		{
+			name:  "key=path and plain path entries",
+			files: []string{"username=username.enc", "secret.enc.yaml"},
+			want: map[string][]string{
+				"prod/app":         {"password"},
+				"prod/credentials": {"username"},
+			},
+		},
+		{
+			name:  "relative to the file prefix",
+			files: []string{"password=password.enc"},
+			env:   map[string]string{"KSOPS_DRY_RUN_FILE_PREFIX": "testdata/keyfiles/mount"},
+			want:  map[string][]string{"prod/credentials": {"password"}},
+		},
+		{
+			name:    "missing from the file prefix",
+			files:   []string{"username=username.enc"},
+			env:     map[string]string{"KSOPS_DRY_RUN_FILE_PREFIX": "testdata/keyfiles/mount"},
+			wantErr: "testdata/keyfiles/mount/username.enc: no such file or directory",
+		},
+		{
+			name:   "ignored",
+			files:  []string{"username=username.enc", "password=password.enc"},
+			ignore: "username.enc\n",
+			want:   map[string][]string{"prod/credentials": {"password"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// The files are copied, so that an ignore file can be added.
+			root := t.TempDir()
+			for _, name := range []string{"username.enc", "password.enc", "secret.enc.yaml"} {
+				body, err := os.ReadFile("testdata/keyfiles/" + name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				writeFiles(t, root, map[string]string{name: string(body)})
+			}
+			if test.ignore != "" {
+				writeFiles(t, root, map[string]string{".ksopsignore": test.ignore})
+			}
+
+			config := ksopsGeneratorConfig{Files: test.files}
+			config.Metadata.Name = "credentials"
+			config.Metadata.Namespace = "prod"
+
+			documents, err := generateSecrets(&config, root, testOptions(t, test.env))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected error %q but got %v", test.wantErr, err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make(map[string][]string)
+			for _, secret := range secretsOf(documents) {
+				for key, value := range secret.StringData {
+					if value != placeholder {
+						t.Errorf("expected a placeholder for key %q but got %q", key, value)
+					}
+					got[secret.displayName()] = append(got[secret.displayName()], key)
+				}
+				sort.Strings(got[secret.displayName()])
+			}
+
+			if len(got) != len(test.want) {
+				t.Fatalf("expected secrets %v but got %v", test.want, got)
+			}
+			for name, keys := range test.want {
+				if strings.Join(got[name], ",") != strings.Join(keys, ",") {
+					t.Errorf("expected secret %s to have keys %v but got %v", name, keys, got[name])
+				}
+			}
+		})
+	}
+}
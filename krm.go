@@ -0,0 +1,268 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// resourceList represents a config.kubernetes.io/v1/ResourceList, which is
+// the input and output of a KRM function.
+// See https://github.com/kubernetes-sigs/kustomize/blob/master/cmd/config/docs/api-conventions/functions-spec.md.
+type resourceList struct {
+	APIVersion     string      `yaml:"apiVersion"`
+	Kind           string      `yaml:"kind"`
+	Items          []yaml.Node `yaml:"items"`
+	FunctionConfig yaml.Node   `yaml:"functionConfig,omitempty"`
+}
+
+// functionAnnotations are the annotations that declare a resource to be a KRM
+// function config, which must not be leaked into the generated resources.
+var functionAnnotations = []string{
+	"config.kubernetes.io/function",
+	"config.k8s.io/function",
+}
+
+// pathAnnotations are the annotations that kustomize uses to record the path
+// of the file that a resource was read from, in order of preference.
+var pathAnnotations = []string{
+	"internal.config.kubernetes.io/path",
+	"config.kubernetes.io/path",
+}
+
+// isPipe returns true if the given file is a pipe, as opposed to e.g. a
+// terminal.
+func isPipe(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeNamedPipe != 0
+}
+
+// krmCmd acts as a KRM function. A ResourceList is read from stdin, its
+// functionConfig is used as the ksops generator config, and the same
+// ResourceList is written to stdout with the stubbed secrets added to its
+// items.
+func krmCmd(opts *options, compliance *policy) error {
+	if err := checkKRMOptions(opts); err != nil {
+		return err
+	}
+
+	input, err := readStdin(opts.stdinTimeout)
+	if err != nil {
+		return err
+	}
+
+	var list resourceList
+	if err := yaml.Unmarshal(input, &list); err != nil {
+		return fmt.Errorf("parsing resource list: %w", err)
+	}
+
+	// Sanity check the apiVersion and kind.
+	if list.APIVersion != "config.kubernetes.io/v1" {
+		return fmt.Errorf("expected resource list apiVersion %q but got %q", "config.kubernetes.io/v1", list.APIVersion)
+	} else if list.Kind != "ResourceList" {
+		return fmt.Errorf("expected resource list kind %q but got %q", "ResourceList", list.Kind)
+	} else if list.FunctionConfig.IsZero() {
+		return errors.New("expected resource list to have a functionConfig")
+	}
+
+	// Parse the ksops generator config from the functionConfig.
+	body, err := yaml.Marshal(&list.FunctionConfig)
+	if err != nil {
+		return err
+	}
+
+	config, err := parseKsopsGenerator(body)
+	if err != nil {
+		return err
+	}
+
+	// KRM functions are run from the kustomization directory, so encrypted
+	// secret files are relative to the working directory, unless the path of
+	// the generator config itself is known.
+	root := configRoot(&list.FunctionConfig)
+	documents, err := generateSecrets(config, root, opts)
+	if err != nil {
+		return err
+	}
+
+	// Check, filter, and sort the documents in the same way as when running
+	// as an exec plugin.
+	documents, err = finishDocuments(documents, root, compliance, opts)
+	if err != nil {
+		return err
+	}
+
+	// Append each stubbed secret or passed through resource, in order, to the
+	// existing items, which are otherwise left unmodified.
+	for _, document := range documents {
+		value, err := document.value()
+		if err != nil {
+			return err
+		}
+
+		var item yaml.Node
+		if err := item.Encode(value); err != nil {
+			return err
+		}
+
+		// An encrypted file may have been copied from the generator config,
+		// so ensure that the generated resources are not themselves mistaken
+		// for function configs.
+		stripAnnotations(&item, functionAnnotations...)
+		list.Items = append(list.Items, item)
+	}
+
+	err = writeOutput(opts, func(output io.Writer) error {
+		encoder := yaml.NewEncoder(output)
+		if err := encoder.Encode(list); err != nil {
+			return err
+		}
+
+		return encoder.Close()
+	})
+	if err != nil {
+		return err
+	}
+
+	return opts.errs()
+}
+
+// checkKRMOptions returns an error naming every configured option that has no
+// meaning for a resource list, so that they are never silently ignored.
+func checkKRMOptions(opts *options) error {
+	var unsupported []string
+	for _, option := range []struct {
+		name string
+		set  bool
+	}{
+		{"KSOPS_DRY_RUN_LEADING_SEPARATOR", opts.leadingSeparator},
+		{"KSOPS_DRY_RUN_BLANK_LINE_SEPARATOR", opts.blankLineSeparator},
+		{"KSOPS_DRY_RUN_GROUP_BY_NAMESPACE", opts.groupDir != ""},
+		{"KSOPS_DRY_RUN_KUSTOMIZATION_DIR", opts.kustomizationDir != ""},
+		{"KSOPS_DRY_RUN_OUTPUT_KUSTOMIZATION_PATCH", opts.kustomizationPatch},
+		{"KSOPS_DRY_RUN_HELM_VALUES", opts.helmValues != nil},
+		{"KSOPS_DRY_RUN_TREE_DIR", opts.treeDir != ""},
+	} {
+		if option.set {
+			unsupported = append(unsupported, option.name)
+		}
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("%s cannot be used when running as a KRM function", strings.Join(unsupported, ", "))
+	}
+
+	return nil
+}
+
+// configRoot returns the directory that the files of the given generator
+// config are relative to. When kustomize reads the config from a file, it
+// records the path of that file (relative to the working directory) in an
+// annotation, and the files are relative to its directory. Otherwise, they
+// are relative to the working directory.
+func configRoot(config *yaml.Node) string {
+	node := config
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+
+	annotations := mappingValue(mappingValue(node, "metadata"), "annotations")
+	for _, key := range pathAnnotations {
+		if path := mappingValue(annotations, key); path != nil && path.Value != "" {
+			return filepath.Dir(path.Value)
+		}
+	}
+
+	return "."
+}
+
+// readStdin reads all of stdin, and fails if that takes longer than the given
+// timeout, such as when nothing is ever piped to stdin. A zero timeout waits
+// indefinitely.
+func readStdin(timeout time.Duration) ([]byte, error) {
+	if timeout <= 0 {
+		return io.ReadAll(os.Stdin)
+	}
+
+	type result struct {
+		input []byte
+		err   error
+	}
+
+	// The read cannot be interrupted, so it is abandoned on a timeout, which
+	// is always followed by exiting.
+	done := make(chan result, 1)
+	go func() {
+		input, err := io.ReadAll(os.Stdin)
+		done <- result{input, err}
+	}()
+
+	select {
+	case result := <-done:
+		return result.input, result.err
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("timed out after %s waiting for a resource list on stdin", timeout)
+	}
+}
+
+// stripAnnotations removes the given annotations from the resource in the
+// given node. An annotations field left empty is removed entirely.
+func stripAnnotations(node *yaml.Node, keys ...string) {
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+
+	metadata := mappingValue(node, "metadata")
+	annotations := mappingValue(metadata, "annotations")
+	if annotations == nil {
+		return
+	}
+
+	for _, key := range keys {
+		for i := 0; i+1 < len(annotations.Content); i += 2 {
+			if annotations.Content[i].Value == key {
+				annotations.Content = append(annotations.Content[:i], annotations.Content[i+2:]...)
+				break
+			}
+		}
+	}
+
+	if len(annotations.Content) == 0 {
+		for i := 0; i+1 < len(metadata.Content); i += 2 {
+			if metadata.Content[i].Value == "annotations" {
+				metadata.Content = append(metadata.Content[:i], metadata.Content[i+2:]...)
+				break
+			}
+		}
+	}
+}
+
+// mappingValue returns the value for the given key in the given mapping node,
+// or nil if there is no such key or the node is not a mapping.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+
+	return nil
+}
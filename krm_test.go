@@ -0,0 +1,588 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// krmInput is a resource list, as kustomize would pipe to a KRM function,
+// with an existing item and a generator config for a single secret.
+const krmInput = `apiVersion: config.kubernetes.io/v1
+kind: ResourceList
+items:
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: existing
+functionConfig:
+  apiVersion: viaduct.ai/v1
+  kind: ksops
+  metadata:
+    name: generator
+    annotations:
+      config.kubernetes.io/function: |
+        exec:
+          path: ksops
+      config.kubernetes.io/path: testdata/krm/generator.yaml
+  files:
+    - secret.enc.yaml
+`
+
+func TestKRMResourceList(t *testing.T) {
+	env := map[string]string{
+		"KSOPS_DRY_RUN":       "",
+		"KSOPS_DRY_RUN_QUIET": "",
+	}
+
+	output, err := runMain(t, nil, env, krmInput)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var list resourceList
+	if err := yaml.Unmarshal([]byte(output), &list); err != nil {
+		t.Fatal(err)
+	}
+
+	if list.APIVersion != "config.kubernetes.io/v1" || list.Kind != "ResourceList" {
+		t.Fatalf("expected a resource list but got:\n%s", output)
+	}
+
+	if list.FunctionConfig.IsZero() {
+		t.Errorf("expected the function config to be kept but got:\n%s", output)
+	}
+
+	// The existing item is kept, and the stubbed secret is appended after it.
+	if len(list.Items) != 2 {
+		t.Fatalf("expected 2 items but got:\n%s", output)
+	}
+
+	var existing common
+	if err := list.Items[0].Decode(&existing); err != nil {
+		t.Fatal(err)
+	}
+	if existing.Kind != "ConfigMap" || existing.Metadata.Name != "existing" {
+		t.Errorf("expected the existing item first but got %s %s", existing.Kind, existing.Metadata.Name)
+	}
+
+	var secret secret
+	if err := list.Items[1].Decode(&secret); err != nil {
+		t.Fatal(err)
+	}
+	if secret.Kind != "Secret" || secret.Metadata.Name != "database" {
+		t.Errorf("expected the stubbed secret second but got %s %s", secret.Kind, secret.Metadata.Name)
+	}
+	if value := secret.StringData["password"]; value != placeholder {
+		t.Errorf("expected a placeholder value but got %q", value)
+	}
+	if strings.Contains(output, "ENC[") {
+		t.Errorf("expected no encrypted values in the output but got:\n%s", output)
+	}
+}
+
+func TestKRMConfigPath(t *testing.T) {
+	const pathAnnotation = "      config.kubernetes.io/path: testdata/krm/generator.yaml\n"
+
+	tests := []struct {
+		name        string
+		annotations string
+		files       string
+		wantErr     string
+	}{
+		{
+			name:        "relative to the config file",
+			annotations: pathAnnotation,
+			files:       "    - secret.enc.yaml\n",
+		},
+		{
+			name:        "internal path is preferred",
+			annotations: "      internal.config.kubernetes.io/path: testdata/krm/generator.yaml\n      config.kubernetes.io/path: generator.yaml\n",
+			files:       "    - secret.enc.yaml\n",
+		},
+		{
+			name:  "relative to the working directory without a path",
+			files: "    - testdata/krm/secret.enc.yaml\n",
+		},
+		{
+			name:    "not found without a path",
+			files:   "    - secret.enc.yaml\n",
+			wantErr: "secret.enc.yaml",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := map[string]string{
+				"KSOPS_DRY_RUN":       "",
+				"KSOPS_DRY_RUN_QUIET": "",
+			}
+
+			input := strings.Replace(krmInput, pathAnnotation, test.annotations, 1)
+			input = strings.Replace(input, "    - secret.enc.yaml\n", test.files, 1)
+
+			output, err := runMain(t, nil, env, input)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected error %q but got %v", test.wantErr, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			var list resourceList
+			if err := yaml.Unmarshal([]byte(output), &list); err != nil {
+				t.Fatal(err)
+			}
+			if len(list.Items) != 2 {
+				t.Fatalf("expected 2 items but got:\n%s", output)
+			}
+
+			var secret secret
+			if err := list.Items[1].Decode(&secret); err != nil {
+				t.Fatal(err)
+			}
+			if secret.Metadata.Name != "database" {
+				t.Errorf("expected secret %q but got %q", "database", secret.Metadata.Name)
+			}
+		})
+	}
+}
+
+func TestKRMOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{
+			name:    "leading separator",
+			env:     map[string]string{"KSOPS_DRY_RUN_LEADING_SEPARATOR": ""},
+			wantErr: "KSOPS_DRY_RUN_LEADING_SEPARATOR cannot be used when running as a KRM function",
+		},
+		{
+			name:    "blank line separator",
+			env:     map[string]string{"KSOPS_DRY_RUN_BLANK_LINE_SEPARATOR": ""},
+			wantErr: "KSOPS_DRY_RUN_BLANK_LINE_SEPARATOR cannot be used when running as a KRM function",
+		},
+		{
+			name:    "group by namespace",
+			env:     map[string]string{"KSOPS_DRY_RUN_GROUP_BY_NAMESPACE": "grouped"},
+			wantErr: "KSOPS_DRY_RUN_GROUP_BY_NAMESPACE cannot be used when running as a KRM function",
+		},
+		{
+			name:    "kustomization dir",
+			env:     map[string]string{"KSOPS_DRY_RUN_KUSTOMIZATION_DIR": "kustomization"},
+			wantErr: "KSOPS_DRY_RUN_KUSTOMIZATION_DIR cannot be used when running as a KRM function",
+		},
+		{
+			name:    "kustomization patch",
+			env:     map[string]string{"KSOPS_DRY_RUN_OUTPUT_KUSTOMIZATION_PATCH": ""},
+			wantErr: "KSOPS_DRY_RUN_OUTPUT_KUSTOMIZATION_PATCH cannot be used when running as a KRM function",
+		},
+		{
+			name:    "helm values",
+			env:     map[string]string{"KSOPS_DRY_RUN_HELM_VALUES": "global.secrets"},
+			wantErr: "KSOPS_DRY_RUN_HELM_VALUES cannot be used when running as a KRM function",
+		},
+		{
+			name:    "tree dir",
+			env:     map[string]string{"KSOPS_DRY_RUN_TREE_DIR": "tree"},
+			wantErr: "KSOPS_DRY_RUN_TREE_DIR cannot be used when running as a KRM function",
+		},
+		{
+			name:    "several options",
+			env:     map[string]string{"KSOPS_DRY_RUN_LEADING_SEPARATOR": "", "KSOPS_DRY_RUN_GROUP_BY_NAMESPACE": "grouped"},
+			wantErr: "KSOPS_DRY_RUN_LEADING_SEPARATOR, KSOPS_DRY_RUN_GROUP_BY_NAMESPACE cannot be used when running as a KRM function",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := map[string]string{
+				"KSOPS_DRY_RUN":       "",
+				"KSOPS_DRY_RUN_QUIET": "",
+			}
+			for name, value := range test.env {
+				env[name] = value
+			}
+
+			output, err := runMain(t, nil, env, krmInput)
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("expected error %q but got %v", test.wantErr, err)
+			}
+			if output != "" {
+				t.Errorf("expected no output but got:\n%s", output)
+			}
+		})
+	}
+}
+
+func TestKRMFunctionAnnotations(t *testing.T) {
+	env := map[string]string{
+		"KSOPS_DRY_RUN":       "",
+		"KSOPS_DRY_RUN_QUIET": "",
+	}
+
+	input := strings.Replace(krmInput, "    - secret.enc.yaml\n", "    - annotated.enc.yaml\n    - function-only.enc.yaml\n", 1)
+
+	output, err := runMain(t, nil, env, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var list resourceList
+	if err := yaml.Unmarshal([]byte(output), &list); err != nil {
+		t.Fatal(err)
+	}
+
+	// The function config itself keeps its function annotation.
+	var config common
+	if err := list.FunctionConfig.Decode(&config); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := config.Metadata.Annotations["config.kubernetes.io/function"]; !found {
+		t.Errorf("expected the function config to keep its function annotation but got:\n%s", output)
+	}
+
+	if len(list.Items) != 3 {
+		t.Fatalf("expected 3 items but got:\n%s", output)
+	}
+
+	tests := []struct {
+		name            string
+		wantAnnotations map[string]string
+	}{
+		{
+			name:            "annotated",
+			wantAnnotations: map[string]string{"team": "platform"},
+		},
+		{
+			name: "function-only",
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var item secret
+			if err := list.Items[i+1].Decode(&item); err != nil {
+				t.Fatal(err)
+			}
+			if item.Metadata.Name != test.name {
+				t.Fatalf("expected secret %q but got %q", test.name, item.Metadata.Name)
+			}
+
+			if got, want := fmt.Sprint(item.Metadata.Annotations), fmt.Sprint(test.wantAnnotations); got != want {
+				t.Errorf("expected annotations %s but got %s", want, got)
+			}
+		})
+	}
+}
+
+func TestKRMNamespaces(t *testing.T) {
+	encrypted := func(namespace string) string {
+		content := "apiVersion: v1\nkind: Secret\nmetadata:\n  name: database\n"
+		if namespace != "" {
+			content += "  namespace: " + namespace + "\n"
+		}
+
+		return content + "stringData:\n  password: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]\n"
+	}
+
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"prod.enc.yaml":         encrypted("prod"),
+		"staging.enc.yaml":      encrypted("staging"),
+		"no-namespace.enc.yaml": encrypted(""),
+	})
+
+	tests := []struct {
+		name      string
+		files     []string
+		wantItems int
+		wantWarn  string
+	}{
+		{
+			name:      "consistent",
+			files:     []string{"prod.enc.yaml", "staging.enc.yaml"},
+			wantItems: 3,
+		},
+		{
+			name:      "inconsistent",
+			files:     []string{"prod.enc.yaml", "no-namespace.enc.yaml"},
+			wantItems: 3,
+			wantWarn:  `no-namespace.enc.yaml: secret "database" has no namespace, but is also generated in namespace "prod" (from ` + filepath.Join(root, "prod.enc.yaml") + ")",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			input := strings.Replace(krmInput, "testdata/krm/generator.yaml", filepath.Join(root, "generator.yaml"), 1)
+			input = strings.Replace(input, "    - secret.enc.yaml\n", "    - "+strings.Join(test.files, "\n    - ")+"\n", 1)
+
+			var (
+				output string
+				err    error
+			)
+			stderr := captureStderr(t, func() {
+				output, err = runMain(t, nil, map[string]string{"KSOPS_DRY_RUN": ""}, input)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The resources are written regardless of any warning.
+			var list resourceList
+			if err := yaml.Unmarshal([]byte(output), &list); err != nil {
+				t.Fatal(err)
+			}
+			if len(list.Items) != test.wantItems {
+				t.Errorf("expected %d items but got:\n%s", test.wantItems, output)
+			}
+
+			if test.wantWarn == "" && strings.Contains(stderr, "but is also generated in namespace") {
+				t.Errorf("expected no namespace warning but got:\n%s", stderr)
+			} else if !strings.Contains(stderr, test.wantWarn) {
+				t.Errorf("expected warning %q but got:\n%s", test.wantWarn, stderr)
+			}
+		})
+	}
+}
+
+func TestKRMOnly(t *testing.T) {
+	input := strings.Replace(krmInput, "    - secret.enc.yaml\n", "    - annotated.enc.yaml\n    - secret.enc.yaml\n    - function-only.enc.yaml\n", 1)
+
+	tests := []struct {
+		name      string
+		only      string
+		wantItems []string
+	}{
+		{
+			name:      "unset",
+			wantItems: []string{"existing", "annotated", "database", "function-only"},
+		},
+		{
+			name:      "name",
+			only:      "database",
+			wantItems: []string{"existing", "database"},
+		},
+		{
+			name:      "no match",
+			only:      "missing",
+			wantItems: []string{"existing"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := map[string]string{
+				"KSOPS_DRY_RUN":       "",
+				"KSOPS_DRY_RUN_QUIET": "",
+			}
+			if test.only != "" {
+				env["KSOPS_DRY_RUN_ONLY"] = test.only
+			}
+
+			output, err := runMain(t, nil, env, input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var list resourceList
+			if err := yaml.Unmarshal([]byte(output), &list); err != nil {
+				t.Fatal(err)
+			}
+
+			// The existing items are never filtered, only the generated ones.
+			var names []string
+			for _, item := range list.Items {
+				var resource common
+				if err := item.Decode(&resource); err != nil {
+					t.Fatal(err)
+				}
+				names = append(names, resource.Metadata.Name)
+			}
+			if got, want := strings.Join(names, ","), strings.Join(test.wantItems, ","); got != want {
+				t.Errorf("expected items %s but got %s", want, got)
+			}
+		})
+	}
+}
+
+func TestKRMSort(t *testing.T) {
+	input := strings.Replace(krmInput, "    - secret.enc.yaml\n", "    - secret.enc.yaml\n    - function-only.enc.yaml\n    - annotated.enc.yaml\n", 1)
+
+	tests := []struct {
+		name      string
+		sort      string
+		wantItems []string
+	}{
+		{
+			name:      "unset",
+			wantItems: []string{"existing", "database", "function-only", "annotated"},
+		},
+		{
+			name:      "kubectl",
+			sort:      "kubectl",
+			wantItems: []string{"existing", "annotated", "database", "function-only"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := map[string]string{
+				"KSOPS_DRY_RUN":       "",
+				"KSOPS_DRY_RUN_QUIET": "",
+			}
+			if test.sort != "" {
+				env["KSOPS_DRY_RUN_SORT"] = test.sort
+			}
+
+			output, err := runMain(t, nil, env, input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var list resourceList
+			if err := yaml.Unmarshal([]byte(output), &list); err != nil {
+				t.Fatal(err)
+			}
+
+			// Only the generated items are sorted, after the existing ones.
+			var names []string
+			for _, item := range list.Items {
+				var resource common
+				if err := item.Decode(&resource); err != nil {
+					t.Fatal(err)
+				}
+				names = append(names, resource.Metadata.Name)
+			}
+			if got, want := strings.Join(names, ","), strings.Join(test.wantItems, ","); got != want {
+				t.Errorf("expected items %s but got %s", want, got)
+			}
+		})
+	}
+}
+
+func TestStdinTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout string
+		input   string
+		close   bool
+		wantErr string
+	}{
+		{
+			name:  "no timeout",
+			input: krmInput,
+			close: true,
+		},
+		{
+			name:    "input within the timeout",
+			timeout: "10s",
+			input:   krmInput,
+			close:   true,
+		},
+		{
+			name:    "no input",
+			timeout: "50ms",
+			wantErr: "timed out after 50ms waiting for a resource list on stdin",
+		},
+		{
+			name:    "partial input",
+			timeout: "50ms",
+			input:   krmInput[:20],
+			wantErr: "timed out after 50ms waiting for a resource list on stdin",
+		},
+		{
+			name:    "invalid timeout",
+			timeout: "soon",
+			wantErr: `parsing KSOPS_DRY_RUN_STDIN_TIMEOUT: time: invalid duration "soon"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("KSOPS_DRY_RUN_QUIET", "")
+			t.Setenv("KSOPS_DRY_RUN_STDIN_TIMEOUT", test.timeout)
+
+			reader, writer, err := os.Pipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reader.Close()
+			defer writer.Close()
+
+			// Like kustomize failing to pipe the resource list, the writer is
+			// left open unless the input is complete.
+			go func() {
+				io.WriteString(writer, test.input)
+				if test.close {
+					writer.Close()
+				}
+			}()
+
+			originalStdin := os.Stdin
+			defer func() { os.Stdin = originalStdin }()
+			os.Stdin = reader
+
+			start := time.Now()
+			var output string
+			opts, err := loadOptions()
+			if err == nil {
+				output, err = captureStdout(t, func() error { return krmCmd(opts, nil) })
+			}
+
+			if test.wantErr == "" && err != nil {
+				t.Fatal(err)
+			} else if test.wantErr != "" && (err == nil || err.Error() != test.wantErr) {
+				t.Fatalf("expected error %q but got %v", test.wantErr, err)
+			}
+
+			if wantOutput := test.wantErr == ""; strings.Contains(output, "kind: ResourceList") != wantOutput {
+				t.Errorf("expected a resource list written %t but got:\n%s", wantOutput, output)
+			}
+
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("expected to return promptly but took %s", elapsed)
+			}
+		})
+	}
+}
+
+// captureStdout returns everything written to stdout by the given function,
+// along with its error.
+func captureStdout(t *testing.T, fn func() error) (string, error) {
+	t.Helper()
+
+	stdout, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+
+	originalStdout := os.Stdout
+	defer func() { os.Stdout = originalStdout }()
+	os.Stdout = stdout
+
+	err = fn()
+
+	body, readErr := os.ReadFile(stdout.Name())
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+
+	return string(body), err
+}
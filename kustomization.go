@@ -0,0 +1,96 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// filenamePattern matches resource names that are safe to use as (part of) a
+// filename, which is all valid resource names.
+var filenamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9.]*[a-z0-9])?$`)
+
+// kustomization represents a kustomize.config.k8s.io/v1beta1/Kustomization
+// that lists the generated resources.
+type kustomization struct {
+	APIVersion string   `yaml:"apiVersion"`
+	Kind       string   `yaml:"kind"`
+	Resources  []string `yaml:"resources"`
+}
+
+// writeKustomization writes every document to a separate file in the given
+// directory, along with a kustomization.yaml that references each of them,
+// so that the directory can be applied with kubectl apply -k.
+func writeKustomization(dir string, documents []document, opts *options) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	config := kustomization{
+		APIVersion: "kustomize.config.k8s.io/v1beta1",
+		Kind:       "Kustomization",
+		Resources:  []string{},
+	}
+
+	seen := make(map[string]struct{})
+	for i, document := range documents {
+		name := resourceFilename(document, i)
+
+		// Resources with the same kind and name (e.g. in different
+		// namespaces) are disambiguated by their position.
+		if _, found := seen[name]; found {
+			name = fmt.Sprintf("%s-%d", name, i+1)
+		}
+		seen[name] = struct{}{}
+
+		filename := name + ".yaml"
+		if err := writeGroup(filepath.Join(dir, filename), documents[i:i+1], opts); err != nil {
+			return err
+		}
+		config.Resources = append(config.Resources, filename)
+	}
+
+	body, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, "kustomization.yaml"), body, 0o644)
+}
+
+// resourceFilename returns a filename, without an extension, for the resource
+// at the given position, based on its kind and name.
+func resourceFilename(document document, index int) string {
+	kind, name := "secret", ""
+	if document.secret != nil {
+		name = document.secret.Metadata.Name
+	} else {
+		node := document.other
+		if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+			node = node.Content[0]
+		}
+		if value := mappingValue(node, "kind"); value != nil {
+			kind = strings.ToLower(value.Value)
+		}
+		if value := mappingValue(mappingValue(node, "metadata"), "name"); value != nil {
+			name = value.Value
+		}
+	}
+
+	// A resource without a usable name (e.g. one using generateName) is
+	// named by its position instead.
+	if !filenamePattern.MatchString(kind) || !filenamePattern.MatchString(name) {
+		return fmt.Sprintf("resource-%d", index+1)
+	}
+
+	return kind + "-" + name
+}
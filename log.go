@@ -0,0 +1,200 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Supported formats for diagnostic output written to stderr.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+
+	// logFormatGitHub writes diagnostic output as github actions workflow
+	// commands, so that it is surfaced as annotations.
+	// See https://docs.github.com/en/actions/using-workflows/workflow-commands-for-github-actions.
+	logFormatGitHub = "github"
+)
+
+// logEntry represents a single diagnostic message, as written to stderr when
+// using the json log format.
+type logEntry struct {
+	Level string `json:"level"`
+	File  string `json:"file,omitempty"`
+	Msg   string `json:"msg"`
+}
+
+// fileError is an error about a specific file, where the filename is written
+// separately from the message in structured diagnostic output.
+type fileError struct {
+	file string
+	err  error
+}
+
+func (e *fileError) Error() string {
+	return e.file + ": " + e.err.Error()
+}
+
+func (e *fileError) Unwrap() error {
+	return e.err
+}
+
+// flattenErrors returns every individual error from the given (potentially
+// nested) joined errors.
+func flattenErrors(err error) []error {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+
+	var errs []error
+	for _, err := range joined.Unwrap() {
+		errs = append(errs, flattenErrors(err)...)
+	}
+
+	return errs
+}
+
+// errorEntry returns the log entry for the given error.
+func errorEntry(err error) logEntry {
+	var fileErr *fileError
+	if errors.As(err, &fileErr) {
+		return logEntry{Level: "error", File: fileErr.file, Msg: fileErr.err.Error()}
+	}
+
+	return logEntry{Level: "error", Msg: err.Error()}
+}
+
+// loadLogFormat reads the log format from the environment. It is loaded
+// separately from the other options, so that errors in loading those options
+// can still be written in the chosen format. If the
+// KSOPS_DRY_RUN_GITHUB_ANNOTATIONS environment variable exists, then it takes
+// precedence over KSOPS_DRY_RUN_LOG_FORMAT.
+func loadLogFormat() (string, error) {
+	if _, found := os.LookupEnv("KSOPS_DRY_RUN_GITHUB_ANNOTATIONS"); found {
+		return logFormatGitHub, nil
+	}
+
+	switch format := os.Getenv("KSOPS_DRY_RUN_LOG_FORMAT"); format {
+	case "", logFormatText:
+		return logFormatText, nil
+	case logFormatJSON:
+		return logFormatJSON, nil
+	default:
+		return "", fmt.Errorf("unsupported KSOPS_DRY_RUN_LOG_FORMAT %q", format)
+	}
+}
+
+// writeLog writes a single diagnostic message to stderr in the given format.
+// Stdout is reserved for the generated manifests.
+func writeLog(format string, entry logEntry) {
+	switch format {
+	case logFormatJSON:
+		body, _ := json.Marshal(entry)
+		fmt.Fprintln(os.Stderr, string(body))
+	case logFormatGitHub:
+		command := entry.Level
+		if command == "info" {
+			command = "notice"
+		}
+		if entry.File != "" {
+			command += " file=" + githubEscape(entry.File, true)
+		}
+		fmt.Fprintf(os.Stderr, "::%s::%s\n", command, githubEscape(entry.Msg, false))
+	default:
+		prefix := "ksops-dry-run: "
+		if entry.Level != "error" {
+			prefix += entry.Level + ": "
+		}
+		if entry.File != "" {
+			prefix += entry.File + ": "
+		}
+		fmt.Fprintln(os.Stderr, prefix+entry.Msg)
+	}
+}
+
+// githubEscape escapes the given github actions workflow command message, or
+// property value.
+func githubEscape(value string, property bool) string {
+	value = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(value)
+	if property {
+		value = strings.NewReplacer(":", "%3A", ",", "%2C").Replace(value)
+	}
+
+	return value
+}
+
+// reporter is used for all non-fatal diagnostic output, which is either
+// written to stderr, or collected to be reported as fatal errors.
+type reporter struct {
+	// format is the format of diagnostic output written to stderr.
+	format string
+
+	// quiet suppresses all non-fatal diagnostic output to stderr.
+	quiet bool
+
+	// debug writes debug diagnostic output to stderr, which is otherwise
+	// suppressed.
+	debug bool
+
+	// warningsAsErrors collects every warning, instead of writing it, so that
+	// they can all be reported as fatal errors.
+	warningsAsErrors bool
+
+	// warnings are the warnings collected so far.
+	warnings []error
+}
+
+// warnf writes a non-fatal diagnostic message, optionally about the given
+// file, unless quiet mode is enabled. If warnings are treated as errors, then
+// the message is collected instead.
+func (r *reporter) warnf(file, format string, args ...any) {
+	if r.warningsAsErrors {
+		err := fmt.Errorf(format, args...)
+		if file != "" {
+			err = &fileError{file: file, err: err}
+		}
+		r.warnings = append(r.warnings, err)
+
+		return
+	}
+
+	if r.quiet {
+		return
+	}
+
+	writeLog(r.format, logEntry{Level: "warning", File: file, Msg: fmt.Sprintf(format, args...)})
+}
+
+// infof writes an informational diagnostic message, optionally about the
+// given file, unless quiet mode is enabled.
+func (r *reporter) infof(file, format string, args ...any) {
+	if r.quiet {
+		return
+	}
+
+	writeLog(r.format, logEntry{Level: "info", File: file, Msg: fmt.Sprintf(format, args...)})
+}
+
+// debugf writes a debug diagnostic message, optionally about the given file,
+// only if debug mode is enabled and quiet mode is not.
+func (r *reporter) debugf(file, format string, args ...any) {
+	if !r.debug || r.quiet {
+		return
+	}
+
+	writeLog(r.format, logEntry{Level: "debug", File: file, Msg: fmt.Sprintf(format, args...)})
+}
+
+// errs returns every collected warning, joined as a single error.
+func (r *reporter) errs() error {
+	return errors.Join(r.warnings...)
+}
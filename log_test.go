@@ -0,0 +1,232 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestWarningsAsErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		wantErrs []string
+	}{
+		{
+			name: "warnings are written",
+		},
+		{
+			name: "warnings are escalated",
+			env:  map[string]string{"KSOPS_DRY_RUN_WARNINGS_AS_ERRORS": ""},
+			wantErrs: []string{
+				`no-namespace.enc.yaml: secret "app" has no namespace`,
+				`no-namespace.enc.yaml: secret "app" has no namespace`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// The same file is listed twice, so that there is more than one
+			// warning to report.
+			env := pluginEnv("testdata/warnings", "no-namespace.enc.yaml", "no-namespace.enc.yaml")
+			for name, value := range test.env {
+				env[name] = value
+			}
+
+			output, err := runMain(t, []string{"generator.yaml"}, env)
+			if len(test.wantErrs) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.Contains(output, "name: app\n") {
+					t.Errorf("expected output to contain the secret but got:\n%s", output)
+				}
+
+				return
+			}
+
+			errs := flattenErrors(err)
+			if len(errs) != len(test.wantErrs) {
+				t.Fatalf("expected %d errors but got %v", len(test.wantErrs), err)
+			}
+			for i, want := range test.wantErrs {
+				if !strings.Contains(errs[i].Error(), want) {
+					t.Errorf("expected error %q but got %q", want, errs[i])
+				}
+			}
+			if output != "" {
+				t.Errorf("expected no output but got:\n%s", output)
+			}
+		})
+	}
+}
+
+// captureStderr runs the given function, and returns everything that it wrote
+// to stderr.
+func captureStderr(t *testing.T, run func()) string {
+	t.Helper()
+
+	file, err := os.CreateTemp(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	original := os.Stderr
+	defer func() {
+		os.Stderr = original
+	}()
+	os.Stderr = file
+
+	run()
+
+	body, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(body)
+}
+
+func TestLogFormat(t *testing.T) {
+	entries := []logEntry{
+		{Level: "warning", File: "secret.enc.yaml", Msg: `secret "app" has no namespace`},
+		{Level: "error", Msg: "something failed"},
+	}
+
+	tests := []struct {
+		name   string
+		format string
+		want   string
+	}{
+		{
+			name:   "text",
+			format: logFormatText,
+			want: "ksops-dry-run: warning: secret.enc.yaml: secret \"app\" has no namespace\n" +
+				"ksops-dry-run: something failed\n",
+		},
+		{
+			name:   "json",
+			format: logFormatJSON,
+			want: `{"level":"warning","file":"secret.enc.yaml","msg":"secret \"app\" has no namespace"}` + "\n" +
+				`{"level":"error","msg":"something failed"}` + "\n",
+		},
+		{
+			name:   "github",
+			format: logFormatGitHub,
+			want: "::warning file=secret.enc.yaml::secret \"app\" has no namespace\n" +
+				"::error::something failed\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output := captureStderr(t, func() {
+				for _, entry := range entries {
+					writeLog(test.format, entry)
+				}
+			})
+
+			if output != test.want {
+				t.Errorf("expected:\n%s\nbut got:\n%s", test.want, output)
+			}
+		})
+	}
+}
+
+func TestLogFormatStdout(t *testing.T) {
+	env := pluginEnv("testdata/warnings", "no-namespace.enc.yaml")
+	delete(env, "KSOPS_DRY_RUN_QUIET")
+	env["KSOPS_DRY_RUN_LOG_FORMAT"] = "json"
+
+	var output string
+	var err error
+	stderr := captureStderr(t, func() {
+		output, err = runMain(t, []string{"generator.yaml"}, env)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every diagnostic is a json object on stderr.
+	lines := strings.Split(strings.TrimSpace(stderr), "\n")
+	for _, line := range lines {
+		var entry logEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Errorf("expected a json log entry but got %q", line)
+		}
+	}
+	if !strings.Contains(stderr, `"msg":"secret \"app\" has no namespace"`) {
+		t.Errorf("expected a namespace warning but got:\n%s", stderr)
+	}
+
+	// Stdout holds nothing but the manifest.
+	var secret secret
+	if err := yaml.Unmarshal([]byte(output), &secret); err != nil || secret.Metadata.Name != "app" {
+		t.Errorf("expected only the secret on stdout but got:\n%s", output)
+	}
+	if strings.Contains(output, "level") {
+		t.Errorf("expected no diagnostics on stdout but got:\n%s", output)
+	}
+}
+
+func TestGitHubAnnotations(t *testing.T) {
+	env := pluginEnv("testdata/invalid", "syntax-error.enc.yaml")
+	env["KSOPS_DRY_RUN_GITHUB_ANNOTATIONS"] = ""
+
+	_, err := runMain(t, []string{"generator.yaml"}, env)
+	if err == nil {
+		t.Fatal("expected a parse error")
+	}
+
+	// Errors are written in the same way as by main.
+	stderr := captureStderr(t, func() {
+		format, _ := loadLogFormat()
+		for _, err := range flattenErrors(err) {
+			writeLog(format, errorEntry(err))
+		}
+	})
+
+	prefix := "::error file=testdata/invalid/syntax-error.enc.yaml::"
+	if !strings.HasPrefix(stderr, prefix) || strings.Count(stderr, "\n") != 1 {
+		t.Errorf("expected a single line starting with %q but got:\n%s", prefix, stderr)
+	}
+}
+
+func TestGitHubEscape(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		property bool
+		want     string
+	}{
+		{
+			name:  "message",
+			value: "100% failed: a, b\nand c",
+			want:  "100%25 failed: a, b%0Aand c",
+		},
+		{
+			name:     "property",
+			value:    "C:/secrets/a,b.enc.yaml",
+			property: true,
+			want:     "C%3A/secrets/a%2Cb.enc.yaml",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := githubEscape(test.value, test.property); got != test.want {
+				t.Errorf("expected %q but got %q", test.want, got)
+			}
+		})
+	}
+}
@@ -6,22 +6,49 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 	"syscall"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
-// metadata represents the standard kubernetes resource metadata.
+// metadata represents the standard kubernetes resource metadata. Fields that
+// are populated by the api server (e.g. uid, resourceVersion, selfLink,
+// creationTimestamp, managedFields) are deliberately not modeled, so that they
+// are dropped should they be copied into an encrypted secret from a live
+// resource. Annotations and labels, like every other map, are always
+// written sorted by key, so the output is stable across runs.
 type metadata struct {
-	Annotations map[string]string `yaml:"annotations,omitempty"`
-	Labels      map[string]string `yaml:"labels,omitempty"`
-	Name        string            `yaml:"name"`
-	Namespace   string            `yaml:"namespace,omitempty"`
+	Annotations     map[string]string `yaml:"annotations,omitempty"`
+	Labels          map[string]string `yaml:"labels,omitempty"`
+	Name            string            `yaml:"name,omitempty"`
+	GenerateName    string            `yaml:"generateName,omitempty"`
+	Namespace       string            `yaml:"namespace,omitempty"`
+	OwnerReferences []ownerReference  `yaml:"ownerReferences,omitempty"`
+}
+
+// ownerReference represents a reference to the owner of a kubernetes
+// resource, which is used for garbage collection.
+type ownerReference struct {
+	APIVersion         string `yaml:"apiVersion"`
+	Kind               string `yaml:"kind"`
+	Name               string `yaml:"name"`
+	UID                string `yaml:"uid"`
+	Controller         *bool  `yaml:"controller,omitempty"`
+	BlockOwnerDeletion *bool  `yaml:"blockOwnerDeletion,omitempty"`
 }
 
 // common represents properties that are shared by all kubernetes resources.
@@ -31,13 +58,162 @@ type common struct {
 	Metadata   metadata `yaml:"metadata"`
 }
 
-// secret represents a v1/Secret resource.
+// secret represents a v1/Secret resource. The keys of data and stringData
+// are modeled as flat string maps, so that keys containing dots or slashes
+// (e.g. tls.crt) are always written literally, and never as nested structures.
 type secret struct {
 	common     `yaml:",inline"`
 	Type       string            `yaml:"type,omitempty"`
 	StringData map[string]string `yaml:"stringData,omitempty"`
 	Data       map[string]string `yaml:"data,omitempty"`
 	Immutable  bool              `yaml:"immutable,omitempty"`
+
+	// sops holds the sops metadata from the original encrypted secret. It is
+	// never included in the generated output.
+	sops *sopsMetadata
+
+	// source is the name of the file that the original encrypted secret was
+	// read from.
+	source string
+
+	// nameHash is the hash suffix that kustomize would give the secret, if it
+	// was computed.
+	nameHash string
+
+	// keyOrder is the original order of the keys of the secret, which is
+	// kept if set.
+	keyOrder []string
+
+	// parsed holds the secret as it was originally parsed, before any keys
+	// were dropped or its namespace was overridden, which is what the policy
+	// is checked against. A merged secret holds one for every secret that it
+	// was merged from, along with their sops metadata.
+	parsed []*secret
+}
+
+// encryptedSecret represents a v1/Secret resource that has been encrypted by
+// sops, and carries its sops metadata alongside. Encrypted json files are also
+// decoded as yaml, where the sops object is likewise kept apart from the
+// secret fields and never written.
+type encryptedSecret struct {
+	secret `yaml:",inline"`
+	Sops   *sopsMetadata `yaml:"sops"`
+
+	// binary holds the keys of every value that was tagged as binary.
+	binary map[string]struct{}
+
+	// keyOrder holds the keys of stringData and then data, in their original
+	// order.
+	keyOrder []string
+
+	// duplicates holds the keys of stringData and data that were defined more
+	// than once, of which only the last value was kept.
+	duplicates []string
+}
+
+// document represents a single resource read from an encrypted file, which
+// is either a stubbed secret, or another resource that is passed through
+// unmodified.
+type document struct {
+	secret *secret
+	other  *yaml.Node
+
+	// comment is an optional comment written after the resource.
+	comment string
+}
+
+// value returns the resource that should be written for the document.
+func (d document) value() (any, error) {
+	if d.secret == nil {
+		return d.other, nil
+	}
+
+	if d.secret.keyOrder == nil {
+		return d.secret, nil
+	}
+
+	// Maps are always written sorted by key, so the secret is written as a
+	// node in order to keep the keys in their original order.
+	var node yaml.Node
+	if err := node.Encode(d.secret); err != nil {
+		return nil, err
+	}
+	for _, field := range []string{"stringData", "data"} {
+		if values := mappingValue(&node, field); values != nil {
+			orderKeys(values, d.secret.keyOrder)
+		}
+	}
+
+	return &node, nil
+}
+
+// namespace returns the namespace of the resource, if any.
+func (d document) namespace() string {
+	if d.secret != nil {
+		return d.secret.Metadata.Namespace
+	}
+
+	node := d.other
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	if namespace := mappingValue(mappingValue(node, "metadata"), "namespace"); namespace != nil {
+		return namespace.Value
+	}
+
+	return ""
+}
+
+// onlyDocuments returns the stubbed secrets from the given documents that
+// match the given name, which is either namespace/name, or just name to match
+// a secret in any namespace. Any other resources are excluded.
+func onlyDocuments(documents []document, only string) []document {
+	namespace, name, qualified := strings.Cut(only, "/")
+	if !qualified {
+		name = namespace
+	}
+
+	var matched []document
+	for _, document := range documents {
+		if document.secret == nil || document.secret.Metadata.Name != name {
+			continue
+		}
+		if qualified && document.secret.Metadata.Namespace != namespace {
+			continue
+		}
+
+		matched = append(matched, document)
+	}
+
+	return matched
+}
+
+// secretsOf returns the stubbed secrets from the given documents, excluding
+// any resources that are passed through.
+func secretsOf(documents []document) []secret {
+	var secrets []secret
+	for _, document := range documents {
+		if document.secret != nil {
+			secrets = append(secrets, *document.secret)
+		}
+	}
+
+	return secrets
+}
+
+// displayName returns a human-readable name for the secret, for use in
+// diagnostic messages.
+func (s secret) displayName() string {
+	name := s.Metadata.Name
+	if name == "" {
+		name = s.Metadata.GenerateName
+	}
+
+	if s.Metadata.Namespace != "" {
+		return s.Metadata.Namespace + "/" + name
+	}
+
+	return name
 }
 
 // ksopsGeneratorConfig represents a generator config for ksops.
@@ -50,9 +226,37 @@ type ksopsGeneratorConfig struct {
 // with -ldflags.
 var version = "development"
 
+// envPrefix is prepended to the names of the KSOPS_DRY_RUN and KSOPS_PATH
+// environment variables, so that they can be namespaced. Can be replaced at go
+// build time with -ldflags, and is overridden by KSOPS_DRY_RUN_ENV_PREFIX.
+var envPrefix = ""
+
+// envName returns the name of the given environment variable, namespaced
+// with the configured prefix.
+func envName(name string) string {
+	if prefix, found := os.LookupEnv("KSOPS_DRY_RUN_ENV_PREFIX"); found {
+		return prefix + name
+	}
+
+	return envPrefix + name
+}
+
 func main() {
 	if err := mainCmd(); err != nil {
-		fmt.Fprintln(os.Stderr, "ksops-dry-run:", err)
+		// Fall back to the text format if the log format itself is invalid.
+		format, _ := loadLogFormat()
+
+		// Joined errors, such as policy violations, are written individually.
+		for _, err := range flattenErrors(err) {
+			writeLog(format, errorEntry(err))
+		}
+
+		// If a post-processing command failed, then exit with the same code.
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.ExitCode())
+		}
+
 		os.Exit(1)
 	}
 }
@@ -65,23 +269,59 @@ func mainCmd() error {
 		return nil
 	}
 
-	// If the KSOPS_DRY_RUN environment variable exists, regardless of if it
-	// even has an associated value, then exec the original ksops plugin.
-	//
-	// The original ksops plugin is located in the following ways
-	// - Using ${KSOPS_PATH} verbatim.
-	// - Using ${XDG_CONFIG_HOME}/kustomize/plugin/viaduct.ai/v1/ksops/_ksops.
-	// - Using ${HOME}/.config/kustomize/plugin/viaduct.ai/v1/ksops/_ksops.
-	if _, found := os.LookupEnv("KSOPS_DRY_RUN"); !found {
-		var ksopsPath string
-		if path := os.Getenv("KSOPS_PATH"); path != "" {
-			ksopsPath = path
-		} else if path := os.Getenv("XDG_CONFIG_HOME"); path != "" {
-			ksopsPath = filepath.Join(path, "kustomize/plugin/viaduct.ai/v1/ksops/_ksops")
-		} else if path := os.Getenv("HOME"); path != "" {
-			ksopsPath = filepath.Join(path, ".config", "kustomize/plugin/viaduct.ai/v1/ksops/_ksops")
-		} else {
-			return fmt.Errorf("unable to resolve location of original ksops plugin")
+	// Run a series of diagnostic checks against the plugin installation and
+	// exit.
+	if len(os.Args) >= 2 && os.Args[1] == "doctor" {
+		return doctorCmd()
+	}
+
+	// Install the plugin in place of the original ksops plugin and exit.
+	if len(os.Args) >= 2 && os.Args[1] == "init" {
+		return initCmd(os.Args[2:])
+	}
+
+	// Continuously write the stubbed secrets for the given generator config
+	// whenever its files change.
+	if len(os.Args) >= 2 && os.Args[1] == "watch" {
+		return watchCmd(os.Args[2:])
+	}
+
+	// List the key names of every secret in the given generator configs and
+	// exit.
+	if len(os.Args) >= 2 && os.Args[1] == "inventory" {
+		return inventoryCmd(os.Args[2:])
+	}
+
+	// Write the stubbed secrets for the given encrypted files and exit.
+	if len(os.Args) >= 2 && os.Args[1] == "stub" {
+		return stubCmd(os.Args[2:])
+	}
+
+	// Summarize the number of files, secrets, and keys in the given generator
+	// configs and exit.
+	if len(os.Args) >= 2 && os.Args[1] == "digest" {
+		return digestCmd(os.Args[2:])
+	}
+
+	// Validate a ksops generator config, without reading any of the files
+	// that it references, and exit.
+	if len(os.Args) >= 2 && os.Args[1] == "validate-config" {
+		return validateConfigCmd(os.Args[2:])
+	}
+
+	// If the KSOPS_DRY_RUN environment variable does not exist, then exec the
+	// original ksops plugin. Its value, if any, is irrelevant.
+	if _, found := os.LookupEnv(envName("KSOPS_DRY_RUN")); !found {
+		ksopsPath, err := resolveKsopsPath()
+		if err != nil {
+			return err
+		}
+
+		// If the KSOPS_DRY_RUN_TRACE_EXEC environment variable exists, then
+		// describe the exec before performing it, to help debug what the
+		// original ksops plugin receives.
+		if _, found := os.LookupEnv("KSOPS_DRY_RUN_TRACE_EXEC"); found {
+			traceExec(os.Stderr, ksopsPath, os.Args, os.Environ())
 		}
 
 		// Exec the original ksops plugin. If successful, this function call
@@ -92,6 +332,34 @@ func mainCmd() error {
 	// We now know that the user wanted to use ksops-dry-run, so act like a
 	// normal kustomize plugin.
 
+	// Load any additional options that customize the generated output.
+	opts, err := loadOptions()
+	if err != nil {
+		return err
+	}
+
+	// Apply any command-line flags, which take precedence over the
+	// environment. Any remaining arguments are those passed by kustomize.
+	args, err := opts.parseFlags(os.Args[1:])
+	if err != nil {
+		return err
+	}
+
+	// If the KSOPS_DRY_RUN_POLICY environment variable is set, then it names a
+	// policy file that every encrypted secret is checked against.
+	var compliance *policy
+	if filename := os.Getenv("KSOPS_DRY_RUN_POLICY"); filename != "" {
+		if compliance, err = loadPolicy(filename); err != nil {
+			return err
+		}
+	}
+
+	// When invoked as a KRM function, kustomize passes no arguments and
+	// instead pipes a ResourceList containing the generator config to stdin.
+	if len(args) == 0 && isPipe(os.Stdin) {
+		return krmCmd(opts, compliance)
+	}
+
 	// The KUSTOMIZE_PLUGIN_CONFIG_STRING environment variable contains the
 	// literal yaml of a generator config.
 	// See https://github.com/viaduct-ai/kustomize-sops#6-define-ksops-kustomize-generator.
@@ -108,39 +376,547 @@ func mainCmd() error {
 		return fmt.Errorf("required environment variable KUSTOMIZE_PLUGIN_CONFIG_ROOT was not found")
 	}
 
+	// Check the config root up front, as every relative file would otherwise
+	// fail with a less obvious error.
+	if info, err := os.Stat(kustomizePluginConfigRoot); err != nil || !info.IsDir() {
+		return fmt.Errorf("config root %q does not exist or is not a directory", kustomizePluginConfigRoot)
+	}
+
 	// Parse the ksops generator config.
 	config, err := parseKsopsGenerator([]byte(kustomizePluginConfigString))
 	if err != nil {
 		return err
 	}
 
+	// Process each encrypted secret file in the config and generate
+	// equivalent secret resources with placeholder values. Every file is
+	// processed before anything is written, so that an error in a later file
+	// never leaves a partial manifest on stdout.
+	documents, err := generateSecrets(config, kustomizePluginConfigRoot, opts)
+	if err != nil {
+		return err
+	}
+
+	// Check, filter, and sort the documents, before anything is written.
+	documents, err = finishDocuments(documents, kustomizePluginConfigRoot, compliance, opts)
+	if err != nil {
+		return err
+	}
+	// Replace each secret with a patch targeting it, if configured to do so.
+	if opts.kustomizationPatch {
+		documents = patchDocuments(documents)
+	}
+
+	// Write the documents into a kustomization directory instead of to
+	// stdout, if configured to do so.
+	if opts.kustomizationDir != "" {
+		if err := writeKustomization(opts.kustomizationDir, documents, opts); err != nil {
+			return err
+		}
+
+		return opts.errs()
+	}
+
+	// Write the secrets into a directory tree by namespace instead of to
+	// stdout, if configured to do so.
+	if opts.treeDir != "" {
+		if err := writeTree(opts.treeDir, documents, opts); err != nil {
+			return err
+		}
+
+		return opts.errs()
+	}
+
+	// Write the documents into a separate file per namespace instead of to
+	// stdout, if configured to do so.
+	if opts.groupDir != "" {
+		if err := writeGrouped(opts.groupDir, documents, opts); err != nil {
+			return err
+		}
+
+		return opts.errs()
+	}
+
+	err = writeOutput(opts, func(output io.Writer) error {
+		// Write the secrets as helm values instead of as resources, if
+		// configured to do so.
+		if opts.helmValues != nil {
+			return writeHelmValues(output, documents, opts.helmValues)
+		}
+
+		return writeDocuments(output, documents, opts)
+	})
+	if err != nil {
+		return err
+	}
+
+	return opts.errs()
+}
+
+// finishDocuments runs the steps that follow generating the documents from
+// every file, and that precede writing them, in the same way however they are
+// written. The documents are checked for inconsistent namespaces, limited to
+// a single secret, recorded in the audit log, sorted, and checked against the
+// policy, as configured.
+func finishDocuments(documents []document, root string, compliance *policy, opts *options) ([]document, error) {
+	// Check every generated secret for inconsistent namespaces, before any
+	// are filtered out.
+	checkNamespaces(secretsOf(documents), opts)
+
+	// Limit the output to a single secret, if configured to do so.
+	if opts.only != "" {
+		documents = onlyDocuments(documents, opts.only)
+		if len(documents) == 0 {
+			opts.warnf("", "no secret matches %q", opts.only)
+		}
+	}
+
+	// Record the processed files in the audit log, if configured to do so.
+	if err := opts.audit.write(root); err != nil {
+		return nil, err
+	}
+
+	// Sort the documents in the same order as kubectl diff, if configured to
+	// do so.
+	if opts.sort == sortKubectl {
+		sortDocuments(documents)
+	}
+
+	// Check every secret against the policy before anything is written, so
+	// that a non-compliant secret never reaches kustomize or a
+	// post-processing command. Secrets are checked in full, even if they are
+	// later replaced with patches.
+	if err := errors.Join(compliance.checkAll(secretsOf(documents)), opts.errs()); err != nil {
+		return nil, err
+	}
+
+	return documents, nil
+}
+
+// writeDocuments writes every document, in order, to the given output as a
+// yaml stream.
+func writeDocuments(output io.Writer, documents []document, opts *options) error {
+	// Sequences can only be made indentless once every document has been
+	// written.
+	if opts.indentlessSequences {
+		var buffer bytes.Buffer
+		if err := encodeDocuments(&buffer, documents, opts); err != nil {
+			return err
+		}
+
+		_, err := output.Write(indentlessSequences(buffer.Bytes()))
+
+		return err
+	}
+
+	return encodeDocuments(output, documents, opts)
+}
+
+// encodeDocuments encodes every document, in order, to the given output as a
+// yaml stream.
+func encodeDocuments(output io.Writer, documents []document, opts *options) error {
 	// Set up a yaml stream encoder so that every (stubbed) secret resource can
-	// be marshalled back to standard out with --- stream separators.
-	encoder := yaml.NewEncoder(os.Stdout)
+	// be marshalled back to standard out with --- stream separators. Unlike
+	// yaml.v2, the yaml.v3 encoder never wraps long scalar values, so values
+	// such as long references or base64 data always stay on a single line.
+	encoder := newEncoder(output, opts)
 
-	// Process each encrypted secret file in the config and output equivalent
-	// secret resources with placeholder values.
-	for _, filename := range config.Files {
-		// Resolve the filename relative to the directory from which it was
-		// configured.
-		filename = filepath.Join(kustomizePluginConfigRoot, filename)
+	// The leading separator is written at most once.
+	leadingSeparator := opts.leadingSeparator
 
-		// Parse the (potentially multiple) secrets in this file, and generate
-		// as many stubbed secrets.
-		secrets, err := parseKsopsEncryptedSecrets(filename)
+	// Encode each stubbed secret or passed through resource to the output
+	// stream.
+	for i, document := range documents {
+		// The separator is written manually, between a fresh encoder for
+		// every document, as the encoder would otherwise write its own.
+		if opts.blankLineSeparator && i > 0 {
+			if err := encoder.Close(); err != nil {
+				return err
+			}
+			if _, err := io.WriteString(output, "\n---\n"); err != nil {
+				return err
+			}
+			encoder = newEncoder(output, opts)
+		}
+
+		// Write the leading separator exactly once, and only if there is a
+		// document to follow it.
+		if leadingSeparator {
+			if _, err := io.WriteString(output, "---\n"); err != nil {
+				return err
+			}
+			leadingSeparator = false
+		}
+
+		value, err := document.value()
 		if err != nil {
 			return err
 		}
 
-		// Encode each stubbed secret to the output stream.
-		for _, secret := range secrets {
-			if err := encoder.Encode(secret); err != nil {
+		// Stubbed secrets are always written in block style, but passed
+		// through resources keep their original style unless normalized.
+		if opts.normalizeStyle && document.other != nil {
+			blockStyle(document.other)
+		}
+
+		// In canonical mode, every field is sorted by key, as kubectl does,
+		// rather than being in the order that fields are declared.
+		if opts.canonical {
+			var generic map[string]any
+			if err := roundTrip(value, &generic); err != nil {
 				return err
 			}
+			value = generic
+		}
+
+		// A comment can only be attached to the resource as a node.
+		if document.comment != "" {
+			var node yaml.Node
+			if err := node.Encode(value); err != nil {
+				return err
+			}
+			node.FootComment = document.comment
+			value = &node
+		}
+
+		if err := encoder.Encode(value); err != nil {
+			return err
+		}
+	}
+
+	// The encoder can only be closed if at least one document was encoded,
+	// which may not be the case if every file was skipped.
+	if len(documents) > 0 {
+		if err := encoder.Close(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newEncoder returns a yaml encoder for the given output. In canonical mode,
+// the output is indented in the same way as kubectl. Empty data or stringData
+// are always omitted.
+func newEncoder(output io.Writer, opts *options) *yaml.Encoder {
+	encoder := yaml.NewEncoder(output)
+	if opts.canonical {
+		encoder.SetIndent(2)
+	}
+
+	return encoder
+}
+
+// blockStyle recursively rewrites every flow style mapping and sequence in
+// the given node as block style.
+func blockStyle(node *yaml.Node) {
+	if node.Kind == yaml.MappingNode || node.Kind == yaml.SequenceNode {
+		node.Style &^= yaml.FlowStyle
+	}
+
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+// roundTrip encodes the given value as yaml, and decodes it back into the
+// given target.
+func roundTrip(value, target any) error {
+	var node yaml.Node
+	if err := node.Encode(value); err != nil {
+		return err
+	}
+
+	return node.Decode(target)
+}
+
+// maxGeneratorDepth is the maximum depth to which ksops generator configs may
+// reference other generator configs, which guards against cycles.
+const maxGeneratorDepth = 10
+
+// generateSecrets parses every encrypted secret file in the given generator
+// config, and returns the equivalent stubbed secrets, along with any other
+// resources that are passed through, in their original order. Local files are
+// resolved relative to the given root directory.
+func generateSecrets(config *ksopsGeneratorConfig, root string, opts *options) ([]document, error) {
+	// If only changed files are to be processed, then determine which files
+	// have changed. Outside of a git repository every file is processed.
+	var changed map[string]struct{}
+	if opts.changedSince != "" {
+		var err error
+		if changed, err = changedFiles(root, opts.changedSince); err != nil {
+			opts.warnf("", "processing all files, as changed files could not be determined: %v", err)
+		}
+	}
+
+	// Skip any files listed in an ignore file in the config root.
+	ignore, err := loadIgnoreFile(root)
+	if err != nil {
+		return nil, err
+	}
+	opts.ignore = ignore
+
+	documents, err := generateNestedSecrets(config, root, opts, changed, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Merge secrets with the same name across files, if configured to do so.
+	if opts.mergeByName {
+		if documents, err = mergeSecrets(documents, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	return documents, nil
+}
+
+// generateNestedSecrets is the same as generateSecrets, but also recurses
+// into any file that is itself a ksops generator config, up to a maximum
+// depth.
+func generateNestedSecrets(config *ksopsGeneratorConfig, root string, opts *options, changed map[string]struct{}, depth int) ([]document, error) {
+	// Check that every file exists before any are processed, so that every
+	// missing file is reported at once.
+	if err := checkFiles(config, root, opts, depth); err != nil {
+		return nil, err
+	}
+
+	var documents []document
+	var keyFiles []keyFile
+	for _, filename := range config.Files {
+		// Skip any file that is configured to be skipped, as it appears in
+		// the generator config.
+		if opts.isSkipped(filename) {
+			opts.debugf(filename, "skipped")
+
+			continue
+		}
+
+		// Collect every key=path entry, which together make up a single
+		// secret. Their files are resolved and skipped in the same way as any
+		// other local file.
+		if keyFile, ok := parseKeyFile(filename); ok {
+			keyFile.path = resolveEntry(root, keyFile.path, opts, depth)
+			if opts.isSkipped(keyFile.path) || opts.ignore.matches(keyFile.path) {
+				opts.debugf(keyFile.path, "skipped")
+
+				continue
+			}
+
+			keyFiles = append(keyFiles, keyFile)
+
+			continue
+		}
+
+		// Use the placeholder strategy configured for this file, if any.
+		opts := opts.forFile(filename)
+
+		var parsed []document
+		var err error
+
+		if isRemote(filename) {
+			// Urls are used as-is, and are always processed.
+			parsed, err = parseKsopsEncryptedSecrets(filename, opts)
+		} else {
+			filename = resolveEntry(root, filename, opts, depth)
+
+			// Skip any file that is configured to be skipped, or that is
+			// ignored, as it was resolved.
+			if opts.isSkipped(filename) || opts.ignore.matches(filename) {
+				opts.debugf(filename, "skipped")
+
+				continue
+			}
+
+			var body []byte
+			if body, err = readFile(filename, opts); err != nil {
+				return nil, err
+			}
+
+			// If the file is itself a ksops generator config, then recurse
+			// into its files, which are relative to its own directory.
+			if nested, ok := parseNestedKsopsGenerator(body); ok {
+				if depth+1 > maxGeneratorDepth {
+					return nil, &fileError{file: filename, err: fmt.Errorf("nested ksops generators exceed the maximum depth of %d", maxGeneratorDepth)}
+				}
+
+				parsed, err = generateNestedSecrets(nested, filepath.Dir(filename), opts, changed, depth+1)
+				if err != nil {
+					return nil, err
+				}
+
+				documents = append(documents, parsed...)
+
+				continue
+			}
+
+			// Skip any file that has not changed.
+			if changed != nil && !isChanged(changed, filename) {
+				continue
+			}
+
+			parsed, err = stubKsopsEncryptedSecrets(bytes.NewReader(body), filename, opts)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// An empty file is not an error, but is likely a misconfiguration, or
+		// even a failed decryption that produced nothing.
+		secrets := secretsOf(parsed)
+		if len(secrets) == 0 {
+			if opts.strictEmpty {
+				return nil, &fileError{file: filename, err: errors.New("contains no secrets")}
+			}
+			opts.warnf(filename, "contains no secrets")
+		}
+
+		// A secret without a namespace, from a generator without a namespace,
+		// will be applied to whichever namespace is the default, which is
+		// usually unintended.
+		if config.Metadata.Namespace == "" {
+			for _, secret := range secrets {
+				if secret.Metadata.Namespace != "" {
+					continue
+				}
+
+				if opts.strict {
+					return nil, &fileError{file: filename, err: fmt.Errorf("secret %q has no namespace", secret.displayName())}
+				}
+				opts.warnf(filename, "secret %q has no namespace", secret.displayName())
+			}
+		}
+
+		documents = append(documents, parsed...)
+	}
+
+	if len(keyFiles) > 0 {
+		parsed, err := stubKeyFiles(config, keyFiles, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		documents = append(documents, parsed...)
+	}
+
+	return documents, nil
+}
+
+// resolveFile returns the location of the given file relative to the given
+// root directory. If the file does not exist there, then up to the given
+// number of parent directories are searched for it, which handles a root that
+// is a kustomize component directory rather than the overlay directory. The
+// root relative location is returned if the file is not found anywhere.
+func resolveFile(root, filename string, parents int) string {
+	resolved := filepath.Join(root, filename)
+	if parents == 0 || exists(resolved) {
+		return resolved
+	}
+
+	dir := root
+	for i := 0; i < parents; i++ {
+		dir = filepath.Join(dir, "..")
+		if candidate := filepath.Join(dir, filename); exists(candidate) {
+			return candidate
 		}
 	}
 
-	return encoder.Close()
+	return resolved
+}
+
+// resolvePluginDir returns the directory in which kustomize expects to find
+// the ksops plugin.
+//
+// The plugin directory is located in the following ways
+// - Using ${XDG_CONFIG_HOME}/kustomize/plugin/viaduct.ai/v1/ksops.
+// - Using ${HOME}/.config/kustomize/plugin/viaduct.ai/v1/ksops.
+func resolvePluginDir() (string, error) {
+	if path := os.Getenv("XDG_CONFIG_HOME"); path != "" {
+		return filepath.Join(path, "kustomize/plugin/viaduct.ai/v1/ksops"), nil
+	} else if path := os.Getenv("HOME"); path != "" {
+		return filepath.Join(path, ".config", "kustomize/plugin/viaduct.ai/v1/ksops"), nil
+	}
+
+	return "", fmt.Errorf("unable to resolve location of ksops plugin directory")
+}
+
+// resolveKsopsPath returns the location of the original ksops plugin.
+//
+// The original ksops plugin is located in the following ways
+// - Using ${KSOPS_PATH} (or its prefixed equivalent) verbatim.
+// - Using an executable _ksops or ksops next to this executable.
+// - Using _ksops inside of the plugin directory.
+func resolveKsopsPath() (string, error) {
+	if path := os.Getenv(envName("KSOPS_PATH")); path != "" {
+		return path, nil
+	}
+
+	if path, found := findSiblingKsops(os.Args[0]); found {
+		return path, nil
+	}
+
+	pluginDir, err := resolvePluginDir()
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve location of original ksops plugin")
+	}
+
+	return filepath.Join(pluginDir, "_ksops"), nil
+}
+
+// findSiblingKsops returns the location of an executable _ksops or ksops in
+// the same directory as the given executable, which is never returned itself
+// (e.g. if it was installed as ksops).
+func findSiblingKsops(self string) (string, bool) {
+	// Without a directory, the executable was found on the path, and so its
+	// location is unknown.
+	if !strings.ContainsRune(self, filepath.Separator) {
+		return "", false
+	}
+
+	selfInfo, err := os.Stat(self)
+	if err != nil {
+		return "", false
+	}
+
+	for _, name := range []string{"_ksops", "ksops"} {
+		path := filepath.Join(filepath.Dir(self), name)
+
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() || info.Mode()&0o111 == 0 || os.SameFile(info, selfInfo) {
+			continue
+		}
+
+		return path, true
+	}
+
+	return "", false
+}
+
+// parseNestedKsopsGenerator parses the given file content as a ksops
+// generator config, but only if its first document is one. Any other content
+// is left to be parsed as encrypted secrets.
+func parseNestedKsopsGenerator(body []byte) (*ksopsGeneratorConfig, bool) {
+	// A file that never mentions the apiVersion cannot be a generator config,
+	// which avoids parsing every encrypted file twice.
+	if !bytes.Contains(body, []byte("viaduct.ai/v1")) {
+		return nil, false
+	}
+
+	var resource common
+	if err := yaml.Unmarshal(body, &resource); err != nil {
+		return nil, false
+	}
+
+	if resource.APIVersion != "viaduct.ai/v1" || resource.Kind != "ksops" {
+		return nil, false
+	}
+
+	config, err := parseKsopsGenerator(body)
+	if err != nil {
+		return nil, false
+	}
+
+	return config, true
 }
 
 func parseKsopsGenerator(body []byte) (*ksopsGeneratorConfig, error) {
@@ -152,44 +928,178 @@ func parseKsopsGenerator(body []byte) (*ksopsGeneratorConfig, error) {
 	// Sanity check the apiVersion and kind. This should never happen, as it
 	// would be the result of a ksops generator misconfiguration.
 	if config.APIVersion != "viaduct.ai/v1" {
-		return nil, fmt.Errorf("expected ksops generator config apiVersion %q but got %q", "viaduct.ai/v1", config.APIVersion)
+		return nil, mismatchError("ksops generator config apiVersion", "viaduct.ai/v1", config.APIVersion)
 	} else if config.Kind != "ksops" {
-		return nil, fmt.Errorf("expected ksops generator config kind %q but got %q", "ksops", config.APIVersion)
+		return nil, mismatchError("ksops generator config kind", "ksops", config.Kind)
 	}
 
 	return &config, nil
 }
 
-func parseKsopsEncryptedSecrets(filename string) ([]secret, error) {
-	file, err := os.Open(filename)
+// parseKsopsEncryptedSecrets parses every secret in the given encrypted file,
+// and returns the equivalent stubbed secrets. If passthrough of other
+// resources is enabled, then any non-secret resources are also returned
+// verbatim, in their original order.
+func parseKsopsEncryptedSecrets(filename string, opts *options) ([]document, error) {
+	file, err := openEncryptedFile(filename, opts)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
+	return stubKsopsEncryptedSecrets(file, filename, opts)
+}
+
+// stubFiles parses every secret in the given encrypted files, keyed by their
+// filenames, and returns the equivalent stubbed secrets in the same way as
+// parseKsopsEncryptedSecrets. Nothing is read from disk, so that integrations
+// can be tested entirely in memory. The files are processed in order of their
+// filenames, so that the output is stable.
+func stubFiles(files map[string][]byte, opts *options) ([]document, error) {
+	filenames := make([]string, 0, len(files))
+	for filename := range files {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	var documents []document
+	for _, filename := range filenames {
+		// Use the placeholder strategy configured for this file, if any.
+		parsed, err := stubKsopsEncryptedSecrets(bytes.NewReader(files[filename]), filename, opts.forFile(filename))
+		if err != nil {
+			return nil, err
+		}
+
+		documents = append(documents, parsed...)
+	}
+
+	return documents, nil
+}
+
+// stubKsopsEncryptedSecrets parses every secret in the given encrypted
+// content, and returns the equivalent stubbed secrets. The content need not
+// come from disk, and the filename is only used in diagnostic messages.
+func stubKsopsEncryptedSecrets(reader io.Reader, filename string, opts *options) ([]document, error) {
+	// Reject tab indentation before decoding, if configured to do so, which
+	// requires reading the whole content up front.
+	if opts.strictYAML {
+		body, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, &fileError{file: filename, err: err}
+		}
+		if err := checkTabs(body); err != nil {
+			return nil, &fileError{file: filename, err: err}
+		}
+		reader = bytes.NewReader(body)
+	}
+
+	// Hash the encrypted file contents as they are read, for the audit log.
+	hash := sha256.New()
+	reader = io.TeeReader(reader, hash)
+
 	// The decoder is used to read each yaml document from the stream one at a
 	// time until no more are left.
-	decoder := yaml.NewDecoder(file)
+	decoder := yaml.NewDecoder(newDocumentEndReader(reader))
 
-	var secrets []secret
-	for {
+	var documents []document
+	for count := 1; ; count++ {
 		// Decode the next yaml document in the stream.
-		var secret secret
-		if err := decoder.Decode(&secret); err != nil {
+		encrypted, other, err := decodeNext(decoder, opts)
+		if err != nil {
 			// No more yaml documents are left in the stream.
 			if errors.Is(err, io.EOF) {
 				break
 			}
 
-			return nil, err
+			return nil, &fileError{file: filename, err: redactError(err)}
+		}
+
+		// Guard against a file containing an excessive number of (possibly
+		// empty) documents.
+		if count > opts.maxDocs {
+			return nil, &fileError{file: filename, err: fmt.Errorf("contains more than the maximum of %d documents", opts.maxDocs)}
+		}
+
+		// Skip blank documents, such as those left by trailing separators. A
+		// leading byte order mark is already skipped by the decoder.
+		if encrypted == nil && other == nil {
+			continue
+		}
+
+		// Pass through any resource that is not a secret unmodified.
+		if other != nil {
+			documents = append(documents, document{other: other})
+
+			continue
 		}
 
+		secret := encrypted.secret
+		secret.sops = encrypted.Sops
+		secret.source = filename
+
 		// Sanity check the apiVersion and kind.
 		if secret.APIVersion != "v1" {
-			return nil, fmt.Errorf("expected ksops encrypted secret apiVersion %q but got %q", "v1", secret.APIVersion)
+			return nil, &fileError{file: filename, err: mismatchError("ksops encrypted secret apiVersion", "v1", secret.APIVersion)}
 		} else if secret.Kind != "Secret" {
-			return nil, fmt.Errorf("expected ksops encrypted secret kind %q but got %q", "Secret", secret.Kind)
+			return nil, &fileError{file: filename, err: mismatchError("ksops encrypted secret kind", "Secret", secret.Kind)}
+		}
+
+		// Sanity check that the secret can be named. A secret may use
+		// generateName in place of name, in which case the api server will
+		// choose the final name.
+		if secret.Metadata.Name == "" && secret.Metadata.GenerateName == "" {
+			return nil, &fileError{file: filename, err: fmt.Errorf("expected ksops encrypted secret to have either a name or generateName")}
+		}
+
+		// Keep the secret as parsed, before it is stubbed. Its keys are left
+		// intact, as the stubbed secret is given new maps of values, but its
+		// metadata maps are shared.
+		parsed := secret
+		secret.parsed = append(secret.parsed, &parsed)
+
+		// Report any secret whose original values would be too large for
+		// kubernetes to accept. The sizes of the encrypted values stand in
+		// for those of the original values.
+		if size := originalSize(secret); size > maxSecretSize {
+			if opts.strict {
+				return nil, &fileError{file: filename, err: fmt.Errorf("secret %q is about %d bytes, which exceeds the maximum of %d bytes", secret.displayName(), size, maxSecretSize)}
+			}
+			opts.warnf(filename, "secret %q is about %d bytes, which exceeds the maximum of %d bytes", secret.displayName(), size, maxSecretSize)
+		}
+
+		// Warn about any secret that is overdue for rotation. A secret without
+		// a valid timestamp is warned about only if the timestamp is present.
+		if opts.maxAge > 0 && secret.sops != nil && secret.sops.LastModified != "" {
+			if modified, ok := secret.sops.lastModified(); !ok {
+				opts.warnf(filename, "secret %q has an invalid sops lastmodified %q", secret.displayName(), secret.sops.LastModified)
+			} else if age := time.Since(modified); age > opts.maxAge {
+				opts.warnf(filename, "secret %q was last modified %d days ago, which is over the maximum age", secret.displayName(), int(age.Hours()/24))
+			}
+		}
+
+		// Report any keys that were defined more than once, as the earlier
+		// values are silently lost when the secret is decrypted.
+		for _, key := range encrypted.duplicates {
+			if opts.strict {
+				return nil, &fileError{file: filename, err: fmt.Errorf("secret %q has duplicate key %q", secret.displayName(), key)}
+			}
+			opts.warnf(filename, "secret %q has duplicate key %q", secret.displayName(), key)
+		}
+
+		// Print a preview of the name that kustomize would give the secret,
+		// were it hashed. The real values are unavailable, so the encrypted
+		// values are hashed instead, which still change whenever the real
+		// values do.
+		var nameHash string
+		if (opts.hashPreview || opts.hashComment || opts.annotateHash) && secret.Metadata.Name != "" {
+			if nameHash, err = secretHash(secret); err != nil {
+				return nil, &fileError{file: filename, err: err}
+			}
 		}
+		if opts.hashPreview && nameHash != "" {
+			opts.infof(filename, "secret %q would be named %s-%s", secret.displayName(), secret.Metadata.Name, nameHash)
+		}
+		secret.nameHash = nameHash
 
 		// Take the combined set of keys from both data and stringData, and
 		// merge them into stringData with a placeholder value. The keys are
@@ -203,37 +1113,333 @@ func parseKsopsEncryptedSecrets(filename string) ([]secret, error) {
 		// In the event that the original secret value was an empty string,
 		// then preserve that empty string instead of using the placeholder
 		// value. This is already viewable in the encrypted secret and assists
-		// in understanding the overall configuration.
-		if secret.StringData == nil {
-			secret.StringData = make(map[string]string)
-		}
-		for key, value := range secret.StringData {
-			switch value {
-			case "": // Preserve the value if it is an empty string.
-				secret.StringData[key] = ""
-			default:
-				secret.StringData[key] = "KSOPS_DRY_RUN_PLACEHOLDER"
+		// in understanding the overall configuration. The exact placeholder
+		// depends on the strategy configured for the file.
+		// Binary values are the exception, and are kept in data with a base64
+		// encoded placeholder, so that they remain binary values.
+		// A placeholder template is rendered separately for every key.
+		stringData := make(map[string]string, len(secret.StringData)+len(secret.Data))
+		var data map[string]string
+		types := make(map[string]string)
+		fields := [2]string{"stringData", "data"}
+		for i, values := range [2]map[string]string{secret.StringData, secret.Data} {
+			for key, value := range values {
+				placeholderKey := placeholderKey{
+					Secret:    secret.Metadata.Name,
+					Namespace: secret.Metadata.Namespace,
+					Key:       key,
+					Field:     fields[i],
+				}
+				if placeholderKey.Secret == "" {
+					placeholderKey.Secret = secret.Metadata.GenerateName
+				}
+
+				// Describe the shape of the original value, which must
+				// happen before it is replaced.
+				if opts.annotateTypes && !opts.isDropped(key) {
+					types[key] = sniffType(value)
+				}
+
+				// Warn about a value that appears to be encrypted more than
+				// once, which is still stubbed as normal.
+				if isStillEncrypted(value) && !opts.isDropped(key) {
+					opts.warnf(filename, "secret %q key %q appears to contain sops encrypted content, which would need to be decrypted again", secret.displayName(), key)
+				}
+
+				switch _, binary := encrypted.binary[key]; {
+				case opts.isDropped(key): // Omit the key entirely.
+					continue
+				case opts.strategy == strategyPassthrough: // Reveal the original value.
+					if binary || fields[i] == "data" {
+						if data == nil {
+							data = make(map[string]string)
+						}
+						data[key] = value
+					} else {
+						stringData[key] = value
+					}
+				case binary: // Keep binary values as binary.
+					if data == nil {
+						data = make(map[string]string)
+					}
+					data[key] = base64.StdEncoding.EncodeToString([]byte(opts.placeholderFor(value, placeholderKey)))
+				case opts.isReference(value): // Preserve external references.
+					stringData[key] = value
+				default:
+					stringData[key] = opts.placeholderFor(value, placeholderKey)
+				}
 			}
 		}
-		for key, value := range secret.Data {
-			switch value {
-			case "": // Preserve the value if it is an empty string.
-				secret.StringData[key] = ""
-			default:
-				secret.StringData[key] = "KSOPS_DRY_RUN_PLACEHOLDER"
+
+		// Every type is kept in a single annotation, as a json object keyed by
+		// secret key, since a secret key is not always a valid annotation name.
+		if len(types) > 0 {
+			body, err := json.Marshal(types)
+			if err != nil {
+				return nil, err
+			}
+			if secret.Metadata.Annotations == nil {
+				secret.Metadata.Annotations = make(map[string]string)
 			}
+			secret.Metadata.Annotations["ksops-dry-run.joshdk.github.com/types"] = string(body)
+		}
+
+		// Move every value into the field conventionally used by the type of
+		// secret, if configured to do so. A secret without a type is Opaque.
+		secretType := secret.Type
+		if secretType == "" {
+			secretType = "Opaque"
+		}
+		if field, found := opts.fieldPolicy[secretType]; found {
+			stringData, data = moveFields(stringData, data, field)
+		}
+
+		// Server-side apply normalizes stringData into data, which confuses
+		// field ownership, so every value is moved into data if configured to
+		// do so.
+		if opts.serverSideSafe {
+			stringData, data = moveFields(stringData, data, "data")
+		}
+
+		secret.StringData = stringData
+		secret.Data = data
+		if opts.preserveKeyOrder {
+			secret.keyOrder = encrypted.keyOrder
+		}
+
+		// The secret is still written if every key was dropped, but that is
+		// likely unintended.
+		if len(stringData)+len(data) == 0 && len(opts.dropKeys) > 0 {
+			opts.warnf(filename, "secret %q has no keys after dropping keys", secret.displayName())
+		}
+
+		// Guard against an anomalous secret with an excessive number of keys,
+		// which would otherwise produce an enormous manifest.
+		if len(secret.StringData)+len(secret.Data) > opts.maxKeys {
+			return nil, &fileError{file: filename, err: fmt.Errorf("secret %q has more than the maximum of %d keys", secret.displayName(), opts.maxKeys)}
+		}
+
+		// Override the namespace of the secret, if configured to do so.
+		if opts.namespace != "" {
+			secret.Metadata.Namespace = opts.namespace
+		}
+
+		// Override the apiVersion of the secret, if configured to do so. The
+		// original secret is still expected to be a v1 secret.
+		if opts.secretAPIVersion != "" {
+			secret.APIVersion = opts.secretAPIVersion
 		}
-		secret.Data = nil
 
 		// Add a custom label so that the user can use a label selector against the
 		// generated resources to e.g. ignore them during a kubectl apply.
-		if secret.Metadata.Labels == nil {
-			secret.Metadata.Labels = make(map[string]string)
+		if opts.labelKey != "" {
+			if secret.Metadata.Labels == nil {
+				secret.Metadata.Labels = make(map[string]string)
+			}
+			secret.Metadata.Labels[opts.labelKey] = opts.labelValue
+		}
+
+		// Add a label with the cluster that the secret was generated for, so
+		// that output from different cluster previews can be told apart.
+		if opts.cluster != "" {
+			if secret.Metadata.Labels == nil {
+				secret.Metadata.Labels = make(map[string]string)
+			}
+			secret.Metadata.Labels["ksops-dry-run.joshdk.github.com/cluster"] = opts.cluster
+		}
+
+		// Add an annotation listing every key that the secret was encrypted
+		// to, so that the encryption audience can be reviewed.
+		if recipients := secret.sops.recipients(); opts.annotateRecipients && len(recipients) > 0 {
+			if secret.Metadata.Annotations == nil {
+				secret.Metadata.Annotations = make(map[string]string)
+			}
+			secret.Metadata.Annotations["ksops-dry-run.joshdk.github.com/recipients"] = strings.Join(recipients, ",")
+		}
+
+		// Add an annotation summarizing the sops metadata, so that the
+		// provenance of the secret can be reviewed.
+		if summary := secret.sops.summary(); opts.annotateSops && summary != "" {
+			if secret.Metadata.Annotations == nil {
+				secret.Metadata.Annotations = make(map[string]string)
+			}
+			secret.Metadata.Annotations["ksops-dry-run.joshdk.github.com/sops"] = summary
+		}
+
+		// Add an annotation with the version of ksops-dry-run that generated
+		// the secret, so that cached output can be invalidated after an
+		// upgrade.
+		if opts.annotateVersion {
+			if secret.Metadata.Annotations == nil {
+				secret.Metadata.Annotations = make(map[string]string)
+			}
+			secret.Metadata.Annotations["ksops-dry-run.joshdk.github.com/version"] = version
+		}
+
+		// Add an annotation with an identifier that is unique to this run,
+		// so that every build is detected as a change.
+		if opts.nonce != "" {
+			if secret.Metadata.Annotations == nil {
+				secret.Metadata.Annotations = make(map[string]string)
+			}
+			secret.Metadata.Annotations["ksops-dry-run.joshdk.github.com/run-id"] = opts.nonce
+		}
+
+		// Add an annotation with the hash suffix, so that changes to the
+		// secret can be detected, but never overwrite an existing one.
+		if opts.annotateHash && nameHash != "" {
+			if secret.Metadata.Annotations == nil {
+				secret.Metadata.Annotations = make(map[string]string)
+			}
+			if _, found := secret.Metadata.Annotations["ksops-dry-run.joshdk.github.com/hash"]; !found {
+				secret.Metadata.Annotations["ksops-dry-run.joshdk.github.com/hash"] = nameHash
+			}
+		}
+
+		// Mark a secret whose original values were passed through, so that it
+		// is never mistaken for one that was stubbed. A file that appears to
+		// be encrypted is still passed through, but warned about.
+		if opts.strategy == strategyPassthrough {
+			if secret.Metadata.Annotations == nil {
+				secret.Metadata.Annotations = make(map[string]string)
+			}
+			secret.Metadata.Annotations["ksops-dry-run.joshdk.github.com/passthrough"] = "true"
+
+			if secret.sops != nil {
+				opts.warnf(filename, "secret %q is passed through, but appears to be sops encrypted", secret.displayName())
+			}
+		}
+
+		// Add any configured annotations, but never overwrite an annotation
+		// that was already present on the original secret.
+		for key, value := range opts.annotations {
+			if secret.Metadata.Annotations == nil {
+				secret.Metadata.Annotations = make(map[string]string)
+			}
+			if _, found := secret.Metadata.Annotations[key]; !found {
+				secret.Metadata.Annotations[key] = value
+			}
+		}
+
+		// Attach the hash as a comment, so that it is visible alongside the
+		// secret in the output.
+		var comment string
+		if opts.hashComment && nameHash != "" {
+			comment = "name-suffix: " + nameHash
 		}
-		secret.Metadata.Labels["ksops-dry-run.joshdk.github.com"] = "true"
 
-		secrets = append(secrets, secret)
+		documents = append(documents, document{secret: &secret, comment: comment})
+	}
+
+	opts.audit.record(filename, hash.Sum(nil))
+
+	return documents, nil
+}
+
+// decodeNext decodes the next yaml document in the stream. Documents are only
+// decoded as an intermediate node when they need to be inspected or rewritten,
+// as decoding twice is comparatively expensive. If passthrough of other
+// resources is enabled, then a non-secret resource is returned as a node. If
+// the document is blank (e.g. only whitespace or comments), or is a skipped
+// non-secret resource, then neither is returned.
+func decodeNext(decoder *yaml.Decoder, opts *options) (*encryptedSecret, *yaml.Node, error) {
+	var encrypted encryptedSecret
+
+	if !opts.tolerateTags && !opts.passthroughOthers && !opts.skipNonSecrets {
+		document := presence{value: &encrypted}
+		if err := decoder.Decode(&document); err != nil {
+			return nil, nil, err
+		}
+
+		if !document.found {
+			return nil, nil, nil
+		}
+
+		return &encrypted, nil, nil
+	}
+
+	var document yaml.Node
+	if err := decoder.Decode(&document); err != nil {
+		return nil, nil, err
+	}
+
+	if len(document.Content) == 0 {
+		return nil, nil, nil
 	}
 
-	return secrets, nil
+	// Custom tags, such as those used by a preprocessor, would otherwise fail
+	// to decode.
+	if opts.tolerateTags {
+		stripCustomTags(&document)
+	}
+
+	// Peek at the kind of the document, as any resource that is not a secret
+	// is passed through unmodified, or skipped as if it were blank. A kind
+	// that only differs in casing is assumed to be a mistake, and is left to
+	// fail validation.
+	if opts.passthroughOthers || opts.skipNonSecrets {
+		var resource common
+		if err := document.Decode(&resource); err != nil {
+			return nil, nil, err
+		}
+
+		if !strings.EqualFold(resource.Kind, "Secret") {
+			if opts.skipNonSecrets {
+				return nil, nil, nil
+			}
+
+			return nil, document.Content[0], nil
+		}
+	}
+
+	if err := document.Decode(&encrypted); err != nil {
+		return nil, nil, err
+	}
+
+	return &encrypted, nil, nil
+}
+
+// mismatchError returns an error for the given field, which was expected to
+// have a different value. A value that only differs in casing is pointed out,
+// as it is an easy mistake to miss.
+func mismatchError(field, expected, actual string) error {
+	if strings.EqualFold(expected, actual) {
+		return fmt.Errorf("%s %q should be %q (check casing)", field, actual, expected)
+	}
+
+	return fmt.Errorf("expected %s %q but got %q", field, expected, actual)
+}
+
+// redactedPattern matches the snippets of document content that the yaml
+// decoder quotes in its errors.
+var redactedPattern = regexp.MustCompile("`[^`]*`")
+
+// redactError returns the given decoding error with any quoted document
+// content removed, as it may be a secret value. Type errors are the only known
+// errors to quote content, but every error is redacted regardless, since the
+// decoder may call into arbitrary unmarshalers. An error without any quoted
+// content is returned unchanged, so that it can still be inspected.
+func redactError(err error) error {
+	message := err.Error()
+
+	redacted := redactedPattern.ReplaceAllString(message, "`[redacted]`")
+	if redacted == message {
+		return err
+	}
+
+	return errors.New(redacted)
+}
+
+// presence decodes a yaml document into the given value, while recording if
+// the document had any content. A blank document is otherwise decoded without
+// any error or effect, and is indistinguishable from an empty resource.
+type presence struct {
+	value any
+	found bool
+}
+
+func (p *presence) UnmarshalYAML(node *yaml.Node) error {
+	p.found = true
+
+	return node.Decode(p.value)
 }
@@ -0,0 +1,2266 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// testOptions returns the options loaded from the given environment. Warnings
+// are collected rather than written, so that they can be checked.
+func testOptions(t *testing.T, env map[string]string) *options {
+	t.Helper()
+
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+
+	opts, err := loadOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.warningsAsErrors = true
+
+	return opts
+}
+
+// writeFiles writes every given file, relative to the given directory.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		filename := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// stubString stubs the given encrypted content, and returns the written
+// documents.
+func stubString(t *testing.T, content string, opts *options) (string, error) {
+	t.Helper()
+
+	documents, err := stubKsopsEncryptedSecrets(strings.NewReader(content), "secret.enc.yaml", opts)
+	if err != nil {
+		return "", err
+	}
+
+	var buffer bytes.Buffer
+	if err := writeDocuments(&buffer, documents, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.String(), nil
+}
+
+// runMain runs the plugin with the given arguments and environment, as
+// kustomize would, and returns everything written to stdout. Any given stdin
+// is piped in, as it would be to a KRM function.
+func runMain(t *testing.T, args []string, env map[string]string, stdin ...string) (string, error) {
+	t.Helper()
+
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+
+	input, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stdin) > 0 {
+		var writer *os.File
+		if input, writer, err = os.Pipe(); err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			defer writer.Close()
+			io.WriteString(writer, strings.Join(stdin, ""))
+		}()
+	}
+	defer input.Close()
+
+	stdout, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+
+	originalArgs, originalStdin, originalStdout := os.Args, os.Stdin, os.Stdout
+	defer func() {
+		os.Args, os.Stdin, os.Stdout = originalArgs, originalStdin, originalStdout
+	}()
+	os.Args = append([]string{"ksops-dry-run"}, args...)
+	os.Stdin, os.Stdout = input, stdout
+
+	err = mainCmd()
+
+	body, readErr := os.ReadFile(stdout.Name())
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+
+	return string(body), err
+}
+
+// pluginEnv returns the environment that kustomize sets for the plugin, for
+// a generator config with the given files, relative to the given root.
+func pluginEnv(root string, files ...string) map[string]string {
+	config := "apiVersion: viaduct.ai/v1\nkind: ksops\nmetadata:\n  name: generator\nfiles:\n"
+	for _, file := range files {
+		config += "  - " + file + "\n"
+	}
+
+	return map[string]string{
+		"KSOPS_DRY_RUN":                  "",
+		"KSOPS_DRY_RUN_QUIET":            "",
+		"KUSTOMIZE_PLUGIN_CONFIG_STRING": config,
+		"KUSTOMIZE_PLUGIN_CONFIG_ROOT":   root,
+	}
+}
+
+func TestGenerateName(t *testing.T) {
+	body, err := os.ReadFile("testdata/generate-name.enc.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+		wantErr string
+	}{
+		{
+			name:    "generateName only",
+			content: string(body),
+			want:    []string{"generateName: app-credentials-\n", "password: KSOPS_DRY_RUN_PLACEHOLDER\n"},
+		},
+		{
+			name:    "name and generateName",
+			content: "apiVersion: v1\nkind: Secret\nmetadata:\n  name: app\n  generateName: app-\nstringData:\n  password: secret\n",
+			want:    []string{"name: app\n", "generateName: app-\n"},
+		},
+		{
+			name:    "neither name nor generateName",
+			content: "apiVersion: v1\nkind: Secret\nmetadata:\n  namespace: prod\nstringData:\n  password: secret\n",
+			wantErr: "expected ksops encrypted secret to have either a name or generateName",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := testOptions(t, nil)
+
+			documents, err := stubKsopsEncryptedSecrets(strings.NewReader(test.content), "secret.enc.yaml", opts)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected error %q but got %v", test.wantErr, err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var buffer bytes.Buffer
+			if err := writeDocuments(&buffer, documents, opts); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, want := range test.want {
+				if !strings.Contains(buffer.String(), want) {
+					t.Errorf("expected output to contain %q but got:\n%s", want, buffer.String())
+				}
+			}
+		})
+	}
+}
+
+func TestPassthroughOthers(t *testing.T) {
+	body, err := os.ReadFile("testdata/passthrough/mixed.enc.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    string
+		wantErr string
+	}{
+		{
+			name:    "rejected by default",
+			wantErr: `expected ksops encrypted secret kind "Secret" but got "ConfigMap"`,
+		},
+		{
+			name: "passed through",
+			env:  map[string]string{"KSOPS_DRY_RUN_PASSTHROUGH_OTHERS": ""},
+			want: `apiVersion: v1
+kind: Secret
+metadata:
+    labels:
+        ksops-dry-run.joshdk.github.com: "true"
+    name: app
+stringData:
+    password: KSOPS_DRY_RUN_PLACEHOLDER
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+    name: app-settings
+data:
+    log-level: debug
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := stubString(t, string(body), testOptions(t, test.env))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected error %q but got %v", test.wantErr, err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if output != test.want {
+				t.Errorf("expected output:\n%s\nbut got:\n%s", test.want, output)
+			}
+		})
+	}
+}
+
+func TestSkipNonSecrets(t *testing.T) {
+	body, err := os.ReadFile("testdata/passthrough/interleaved.enc.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		env     map[string]string
+		want    []string
+		wantErr string
+	}{
+		{
+			name:    "rejected by default",
+			content: string(body),
+			wantErr: `expected ksops encrypted secret kind "Secret" but got "ConfigMap"`,
+		},
+		{
+			name:    "skipped",
+			content: string(body),
+			env:     map[string]string{"KSOPS_DRY_RUN_SKIP_NON_SECRETS": ""},
+			want:    []string{"first", "second"},
+		},
+		{
+			name:    "only non-secrets",
+			content: "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\ndata:\n  log-level: debug\n",
+			env:     map[string]string{"KSOPS_DRY_RUN_SKIP_NON_SECRETS": ""},
+		},
+		{
+			name:    "lowercase kind is not skipped",
+			content: "apiVersion: v1\nkind: secret\nmetadata:\n  name: app\nstringData:\n  password: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]\n",
+			env:     map[string]string{"KSOPS_DRY_RUN_SKIP_NON_SECRETS": ""},
+			wantErr: `ksops encrypted secret kind "secret" should be "Secret" (check casing)`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := testOptions(t, test.env)
+
+			var (
+				output string
+				err    error
+			)
+			stderr := captureStderr(t, func() {
+				output, err = stubString(t, test.content, opts)
+			})
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected error %q but got %v", test.wantErr, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			// Skipped resources are neither written nor reported.
+			if stderr != "" {
+				t.Errorf("expected nothing to be reported but got:\n%s", stderr)
+			}
+			if strings.Contains(output, "ConfigMap") {
+				t.Errorf("expected no config map in the output but got:\n%s", output)
+			}
+
+			var names []string
+			decoder := yaml.NewDecoder(strings.NewReader(output))
+			for {
+				var document secret
+				if err := decoder.Decode(&document); errors.Is(err, io.EOF) {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				names = append(names, document.Metadata.Name)
+			}
+
+			if got, want := strings.Join(names, ","), strings.Join(test.want, ","); got != want {
+				t.Errorf("expected secrets %q but got %q", want, got)
+			}
+		})
+	}
+
+	t.Run("with passthrough", func(t *testing.T) {
+		t.Setenv("KSOPS_DRY_RUN_PASSTHROUGH_OTHERS", "")
+		t.Setenv("KSOPS_DRY_RUN_SKIP_NON_SECRETS", "")
+
+		want := "KSOPS_DRY_RUN_PASSTHROUGH_OTHERS and KSOPS_DRY_RUN_SKIP_NON_SECRETS cannot both be set"
+		if _, err := loadOptions(); err == nil || err.Error() != want {
+			t.Fatalf("expected error %q but got %v", want, err)
+		}
+	})
+}
+
+func TestMismatchCasing(t *testing.T) {
+	secret := func(apiVersion, kind string) string {
+		return "apiVersion: " + apiVersion + "\nkind: " + kind + "\nmetadata:\n  name: app\nstringData:\n  password: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]\n"
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		env     map[string]string
+		wantErr string
+	}{
+		{
+			name:    "lowercase kind",
+			content: secret("v1", "secret"),
+			wantErr: `ksops encrypted secret kind "secret" should be "Secret" (check casing)`,
+		},
+		{
+			name:    "uppercase kind",
+			content: secret("v1", "SECRET"),
+			wantErr: `ksops encrypted secret kind "SECRET" should be "Secret" (check casing)`,
+		},
+		{
+			name:    "mixed case kind",
+			content: secret("v1", "sEcReT"),
+			wantErr: `ksops encrypted secret kind "sEcReT" should be "Secret" (check casing)`,
+		},
+		{
+			name:    "different kind",
+			content: secret("v1", "Secrets"),
+			wantErr: `expected ksops encrypted secret kind "Secret" but got "Secrets"`,
+		},
+		{
+			name:    "uppercase apiVersion",
+			content: secret("V1", "Secret"),
+			wantErr: `ksops encrypted secret apiVersion "V1" should be "v1" (check casing)`,
+		},
+		{
+			name:    "lowercase kind is not passed through",
+			content: secret("v1", "secret"),
+			env:     map[string]string{"KSOPS_DRY_RUN_PASSTHROUGH_OTHERS": ""},
+			wantErr: `ksops encrypted secret kind "secret" should be "Secret" (check casing)`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := stubString(t, test.content, testOptions(t, test.env))
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("expected error %q but got %v", test.wantErr, err)
+			}
+		})
+	}
+
+	t.Run("generator config kind", func(t *testing.T) {
+		want := `ksops generator config kind "Ksops" should be "ksops" (check casing)`
+
+		_, err := parseKsopsGenerator([]byte("apiVersion: viaduct.ai/v1\nkind: Ksops\nmetadata:\n  name: generator\n"))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected error %q but got %v", want, err)
+		}
+	})
+}
+
+func TestEnvPrefix(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "prefixed trigger",
+			env: map[string]string{
+				"KSOPS_DRY_RUN_ENV_PREFIX": "TEAMX_",
+				"TEAMX_KSOPS_DRY_RUN":      "",
+			},
+			want: "name: database\n",
+		},
+		{
+			name: "unprefixed trigger is ignored",
+			env: map[string]string{
+				"KSOPS_DRY_RUN_ENV_PREFIX": "TEAMX_",
+				"KSOPS_DRY_RUN":            "",
+				"TEAMX_KSOPS_PATH":         "/nonexistent/ksops",
+			},
+			wantErr: "no such file or directory",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := pluginEnv("testdata/policy", "compliant.enc.yaml")
+			delete(env, "KSOPS_DRY_RUN")
+			for name, value := range test.env {
+				env[name] = value
+			}
+
+			output, err := runMain(t, []string{"generator.yaml"}, env)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected error %q but got %v", test.wantErr, err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.Contains(output, test.want) {
+				t.Errorf("expected output to contain %q but got:\n%s", test.want, output)
+			}
+		})
+	}
+}
+
+// benchmarkSecret returns an encrypted secret with the given name and number
+// of keys, split evenly between stringData and data.
+func benchmarkSecret(name string, keys int) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "apiVersion: v1\nkind: Secret\nmetadata:\n    name: %s\n    namespace: prod\n", name)
+
+	for i, field := range []string{"stringData", "data"} {
+		fmt.Fprintf(&builder, "%s:\n", field)
+		for key := i; key < keys; key += 2 {
+			fmt.Fprintf(&builder, "    key-%04d: ENC[AES256_GCM,data:dmFsdWUtJTA0ZA==,iv:Yx3Xr1Y0wq1xkJ2Y9p8b3nV9cQ0o2KXc8V6T2b1m3Fk=,tag:0bQ3xkJ2Y9p8b3nV9cQ0oA==,type:str]\n", key)
+		}
+	}
+
+	builder.WriteString("sops:\n    age:\n        - recipient: age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p\n    lastmodified: \"2026-01-01T00:00:00Z\"\n    version: 3.8.1\n")
+
+	return builder.String()
+}
+
+// BenchmarkStubManyFiles stubs a generator config of 100 small secrets, each
+// in its own file.
+func BenchmarkStubManyFiles(b *testing.B) {
+	dir := b.TempDir()
+
+	var config ksopsGeneratorConfig
+	for i := 0; i < 100; i++ {
+		filename := fmt.Sprintf("secret-%03d.enc.yaml", i)
+		if err := os.WriteFile(filepath.Join(dir, filename), []byte(benchmarkSecret(fmt.Sprintf("secret-%03d", i), 4)), 0o644); err != nil {
+			b.Fatal(err)
+		}
+		config.Files = append(config.Files, filename)
+	}
+
+	opts, err := loadOptions()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := generateSecrets(&config, dir, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkStubLargeSecret stubs a single file containing a secret with 1000
+// keys.
+func BenchmarkStubLargeSecret(b *testing.B) {
+	filename := filepath.Join(b.TempDir(), "large.enc.yaml")
+	if err := os.WriteFile(filename, []byte(benchmarkSecret("large", 1000)), 0o644); err != nil {
+		b.Fatal(err)
+	}
+
+	opts, err := loadOptions()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := parseKsopsEncryptedSecrets(filename, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestNestedGenerators(t *testing.T) {
+	tests := []struct {
+		name    string
+		root    string
+		files   []string
+		want    []string
+		wantErr string
+	}{
+		{
+			name:  "one level of nesting",
+			root:  "testdata/nested",
+			files: []string{"child/generator.yaml"},
+			want:  []string{"prod/database"},
+		},
+		{
+			name:    "cycle",
+			root:    "testdata/cycle",
+			files:   []string{"generator.yaml"},
+			wantErr: "nested ksops generators exceed the maximum depth of 10",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := ksopsGeneratorConfig{Files: test.files}
+
+			documents, err := generateSecrets(&config, test.root, testOptions(t, nil))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected error %q but got %v", test.wantErr, err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var names []string
+			for _, secret := range secretsOf(documents) {
+				names = append(names, secret.displayName())
+			}
+			if strings.Join(names, ",") != strings.Join(test.want, ",") {
+				t.Errorf("expected secrets %v but got %v", test.want, names)
+			}
+		})
+	}
+}
+
+func TestPassthroughOthersOrder(t *testing.T) {
+	body, err := os.ReadFile("testdata/passthrough/interleaved.enc.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := testOptions(t, map[string]string{"KSOPS_DRY_RUN_PASSTHROUGH_OTHERS": ""})
+
+	documents, err := stubKsopsEncryptedSecrets(bytes.NewReader(body), "interleaved.enc.yaml", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var order []string
+	for _, document := range documents {
+		value, err := document.value()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var resource common
+		if err := roundTrip(value, &resource); err != nil {
+			t.Fatal(err)
+		}
+		order = append(order, resource.Kind+"/"+resource.Metadata.Name)
+	}
+
+	want := []string{"Secret/first", "ConfigMap/settings", "Secret/second"}
+	if strings.Join(order, ",") != strings.Join(want, ",") {
+		t.Errorf("expected resources in the order %v but got %v", want, order)
+	}
+}
+
+func TestFilePrefix(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{
+			name: "relative to the config root",
+			want: "prod/from-root",
+		},
+		{
+			name: "relative to the file prefix",
+			env:  map[string]string{"KSOPS_DRY_RUN_FILE_PREFIX": "testdata/prefix/mount"},
+			want: "prod/from-mount",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := ksopsGeneratorConfig{Files: []string{"secret.enc.yaml"}}
+
+			documents, err := generateSecrets(&config, "testdata/prefix/root", testOptions(t, test.env))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			secrets := secretsOf(documents)
+			if len(secrets) != 1 || secrets[0].displayName() != test.want {
+				t.Errorf("expected secret %s but got %v", test.want, secrets)
+			}
+		})
+	}
+}
+
+func TestInvalidFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		wantErr string
+	}{
+		{
+			name:    "type error",
+			file:    "type-error.enc.yaml",
+			wantErr: "cannot unmarshal !!str `[redacted]`",
+		},
+		{
+			name:    "syntax error",
+			file:    "syntax-error.enc.yaml",
+			wantErr: "found unexpected end of stream",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// The invalid file is listed after a valid file, whose secret
+			// must not be written either.
+			output, err := runMain(t, []string{"generator.yaml"}, pluginEnv("testdata/invalid", "valid.enc.yaml", test.file))
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("expected error %q but got %v", test.wantErr, err)
+			}
+			if strings.Contains(err.Error(), "hunter2") {
+				t.Errorf("expected error to not contain the secret value but got %q", err)
+			}
+			if output != "" {
+				t.Errorf("expected no output but got:\n%s", output)
+			}
+		})
+	}
+}
+
+func TestLeadingSeparator(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		leading bool
+	}{
+		{
+			name: "between documents",
+		},
+		{
+			name:    "before every document",
+			env:     map[string]string{"KSOPS_DRY_RUN_LEADING_SEPARATOR": ""},
+			leading: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := pluginEnv("testdata/policy", "compliant.enc.yaml", "wrong-recipient.enc.yaml")
+			for name, value := range test.env {
+				env[name] = value
+			}
+
+			output, err := runMain(t, []string{"generator.yaml"}, env)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if leading := strings.HasPrefix(output, "---\n"); leading != test.leading {
+				t.Errorf("expected a leading separator to be %t but got:\n%s", test.leading, output)
+			}
+
+			// The output parses as exactly the two secrets either way, with
+			// no empty document before the first.
+			var names []string
+			decoder := yaml.NewDecoder(strings.NewReader(output))
+			for {
+				var secret secret
+				if err := decoder.Decode(&secret); errors.Is(err, io.EOF) {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				names = append(names, secret.Metadata.Name)
+			}
+			if strings.Join(names, ",") != "database,cache" {
+				t.Errorf("expected secrets database and cache but got %v", names)
+			}
+		})
+	}
+}
+
+func TestBlankLineSeparator(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		blank bool
+	}{
+		{
+			name: "default",
+		},
+		{
+			name:  "blank line before separators",
+			env:   map[string]string{"KSOPS_DRY_RUN_BLANK_LINE_SEPARATOR": ""},
+			blank: true,
+		},
+		{
+			name:  "with a leading separator",
+			env:   map[string]string{"KSOPS_DRY_RUN_BLANK_LINE_SEPARATOR": "", "KSOPS_DRY_RUN_LEADING_SEPARATOR": ""},
+			blank: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := pluginEnv("testdata/policy", "compliant.enc.yaml", "wrong-recipient.enc.yaml", "pgp-only.enc.yaml")
+			for name, value := range test.env {
+				env[name] = value
+			}
+
+			output, err := runMain(t, []string{"generator.yaml"}, env)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Only the separators between documents are preceded by a blank
+			// line, and never a leading separator.
+			if got, want := strings.Count(output, "\n\n---\n"), map[bool]int{true: 2}[test.blank]; got != want {
+				t.Errorf("expected %d blank lines before separators but got:\n%s", want, output)
+			}
+			if strings.HasPrefix(output, "\n") {
+				t.Errorf("expected no leading blank line but got:\n%s", output)
+			}
+
+			// The output parses as exactly the three secrets either way.
+			var names []string
+			decoder := yaml.NewDecoder(strings.NewReader(output))
+			for {
+				var secret secret
+				if err := decoder.Decode(&secret); errors.Is(err, io.EOF) {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				names = append(names, secret.Metadata.Name)
+			}
+			if strings.Join(names, ",") != "database,cache,queue" {
+				t.Errorf("expected secrets database, cache, and queue but got %v", names)
+			}
+		})
+	}
+}
+
+func TestOwnerReferences(t *testing.T) {
+	body, err := os.ReadFile("testdata/owner-references.enc.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := stubString(t, string(body), testOptions(t, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The owner references are written exactly as they were read.
+	var original, stubbed struct {
+		Metadata struct {
+			OwnerReferences []map[string]any `yaml:"ownerReferences"`
+		} `yaml:"metadata"`
+		StringData map[string]string `yaml:"stringData"`
+	}
+	if err := yaml.Unmarshal(body, &original); err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal([]byte(output), &stubbed); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := fmt.Sprint(stubbed.Metadata.OwnerReferences), fmt.Sprint(original.Metadata.OwnerReferences); got != want {
+		t.Errorf("expected owner references %s but got %s", want, got)
+	}
+	if value := stubbed.StringData["token"]; value != placeholder {
+		t.Errorf("expected a placeholder value but got %q", value)
+	}
+}
+
+func TestNoNamespace(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      string
+		namespace string
+		env       map[string]string
+		wantWarn  bool
+		wantErr   bool
+	}{
+		{
+			name: "namespaced secret",
+			file: "policy/compliant.enc.yaml",
+		},
+		{
+			name:      "namespaced generator",
+			file:      "warnings/no-namespace.enc.yaml",
+			namespace: "prod",
+		},
+		{
+			name:     "neither namespaced",
+			file:     "warnings/no-namespace.enc.yaml",
+			wantWarn: true,
+		},
+		{
+			name:    "neither namespaced when strict",
+			file:    "warnings/no-namespace.enc.yaml",
+			env:     map[string]string{"KSOPS_DRY_RUN_STRICT": ""},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := ksopsGeneratorConfig{Files: []string{test.file}}
+			config.Metadata.Namespace = test.namespace
+
+			// Warnings are collected as errors by the test options.
+			opts := testOptions(t, test.env)
+			_, err := generateSecrets(&config, "testdata", opts)
+			if test.wantErr {
+				if err == nil || !strings.Contains(err.Error(), `secret "app" has no namespace`) {
+					t.Fatalf("expected a namespace error but got %v", err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			warnings := opts.errs()
+			if test.wantWarn && (warnings == nil || !strings.Contains(warnings.Error(), `secret "app" has no namespace`)) {
+				t.Errorf("expected a namespace warning but got %v", warnings)
+			} else if !test.wantWarn && warnings != nil {
+				t.Errorf("expected no warnings but got %v", warnings)
+			}
+		})
+	}
+}
+
+func TestDottedKeys(t *testing.T) {
+	keys := []string{"a.b/c.d", "path/to/key", "tls.crt"}
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		path []string
+	}{
+		{
+			name: "yaml",
+			path: []string{"stringData"},
+		},
+		{
+			name: "canonical",
+			env:  map[string]string{"KSOPS_DRY_RUN_CANONICAL": ""},
+			path: []string{"stringData"},
+		},
+		{
+			name: "server-side safe",
+			env:  map[string]string{"KSOPS_DRY_RUN_SERVER_SIDE_SAFE": ""},
+			path: []string{"data"},
+		},
+		{
+			name: "helm values",
+			env:  map[string]string{"KSOPS_DRY_RUN_HELM_VALUES": "global.secrets"},
+			path: []string{"global", "secrets", "dotted"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := pluginEnv("testdata", "dotted-keys.enc.yaml")
+			for name, value := range test.env {
+				env[name] = value
+			}
+
+			output, err := runMain(t, []string{"generator.yaml"}, env)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var values any
+			if err := yaml.Unmarshal([]byte(output), &values); err != nil {
+				t.Fatal(err)
+			}
+			for _, key := range test.path {
+				mapping, ok := values.(map[string]any)
+				if !ok {
+					t.Fatalf("expected a mapping at %q but got:\n%s", key, output)
+				}
+				values = mapping[key]
+			}
+
+			// Every key is kept flat, with a scalar value, and never split
+			// into a nested structure.
+			mapping, ok := values.(map[string]any)
+			if !ok || len(mapping) != len(keys) {
+				t.Fatalf("expected keys %v but got:\n%s", keys, output)
+			}
+			for _, key := range keys {
+				if _, ok := mapping[key].(string); !ok {
+					t.Errorf("expected key %q to have a scalar value but got:\n%s", key, output)
+				}
+			}
+		})
+	}
+}
+
+func TestMaxDocs(t *testing.T) {
+	tests := []struct {
+		name      string
+		documents int
+		wantErr   bool
+	}{
+		{
+			name:      "at the limit",
+			documents: 3,
+		},
+		{
+			name:      "just over the limit",
+			documents: 4,
+			wantErr:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Blank documents count towards the limit too.
+			content := "apiVersion: v1\nkind: Secret\nmetadata:\n  name: app\n  namespace: prod\n" + strings.Repeat("---\n", test.documents-1)
+
+			_, err := stubString(t, content, testOptions(t, map[string]string{"KSOPS_DRY_RUN_MAX_DOCS": "3"}))
+			if test.wantErr {
+				if err == nil || err.Error() != "secret.enc.yaml: contains more than the maximum of 3 documents" {
+					t.Fatalf("expected a maximum documents error but got %v", err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestPreserveRefs(t *testing.T) {
+	content := `apiVersion: v1
+kind: Secret
+metadata:
+  name: database
+stringData:
+  password: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]
+  username: vault:secret/data/database#username
+  region: ssm:/prod/database/region
+sops:
+  version: 3.7.3
+`
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		want map[string]string
+	}{
+		{
+			name: "unset",
+			want: map[string]string{
+				"password": placeholder,
+				"username": placeholder,
+				"region":   placeholder,
+			},
+		},
+		{
+			name: "default prefixes",
+			env:  map[string]string{"KSOPS_DRY_RUN_PRESERVE_REFS": ""},
+			want: map[string]string{
+				"password": placeholder,
+				"username": "vault:secret/data/database#username",
+				"region":   "ssm:/prod/database/region",
+			},
+		},
+		{
+			name: "custom prefixes",
+			env:  map[string]string{"KSOPS_DRY_RUN_PRESERVE_REFS": "vault:"},
+			want: map[string]string{
+				"password": placeholder,
+				"username": "vault:secret/data/database#username",
+				"region":   placeholder,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := stubString(t, content, testOptions(t, test.env))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var stubbed secret
+			if err := yaml.Unmarshal([]byte(output), &stubbed); err != nil {
+				t.Fatal(err)
+			}
+
+			for key, want := range test.want {
+				if value := stubbed.StringData[key]; value != want {
+					t.Errorf("expected key %q to be %q but got %q", key, want, value)
+				}
+			}
+		})
+	}
+}
+
+func TestByteOrderMark(t *testing.T) {
+	body, err := os.ReadFile("testdata/bom.enc.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(body, []byte("\xef\xbb\xbf")) {
+		t.Fatal("expected the fixture to start with a byte order mark")
+	}
+
+	output, err := stubString(t, string(body), testOptions(t, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The trailing blank document is skipped, so exactly one secret is written.
+	var stubbed []secret
+	decoder := yaml.NewDecoder(strings.NewReader(output))
+	for {
+		var document secret
+		if err := decoder.Decode(&document); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		stubbed = append(stubbed, document)
+	}
+
+	if len(stubbed) != 1 {
+		t.Fatalf("expected 1 secret but got:\n%s", output)
+	}
+	if stubbed[0].Metadata.Name != "windows" {
+		t.Errorf("expected secret %q but got %q", "windows", stubbed[0].Metadata.Name)
+	}
+	if value := stubbed[0].StringData["password"]; value != placeholder {
+		t.Errorf("expected a placeholder value but got %q", value)
+	}
+}
+
+func TestMaxKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    int
+		wantErr bool
+	}{
+		{
+			name: "just under the limit",
+			keys: 2,
+		},
+		{
+			name: "at the limit",
+			keys: 3,
+		},
+		{
+			name:    "just over the limit",
+			keys:    4,
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Keys in both data and stringData count towards the limit.
+			content := "apiVersion: v1\nkind: Secret\nmetadata:\n  name: app\n  namespace: prod\ndata:\n  key-0: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]\nstringData:\n"
+			for i := 1; i < test.keys; i++ {
+				content += fmt.Sprintf("  key-%d: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]\n", i)
+			}
+
+			_, err := stubString(t, content, testOptions(t, map[string]string{"KSOPS_DRY_RUN_MAX_KEYS": "3"}))
+			if test.wantErr {
+				if err == nil || err.Error() != `secret.enc.yaml: secret "prod/app" has more than the maximum of 3 keys` {
+					t.Fatalf("expected a maximum keys error but got %v", err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestJSONSops(t *testing.T) {
+	body, err := os.ReadFile("testdata/json.enc.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := stubString(t, string(body), testOptions(t, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stubbed map[string]any
+	if err := yaml.Unmarshal([]byte(output), &stubbed); err != nil {
+		t.Fatal(err)
+	}
+
+	// The sops sibling object is never mistaken for a secret field.
+	if got := sortedKeys(stubbed); got != "apiVersion,kind,metadata,stringData" {
+		t.Errorf("expected fields apiVersion,kind,metadata,stringData but got %s", got)
+	}
+	for _, field := range []string{"recipient", "lastmodified", "ENC["} {
+		if strings.Contains(output, field) {
+			t.Errorf("expected no %q in the output but got:\n%s", field, output)
+		}
+	}
+
+	var secret secret
+	if err := yaml.Unmarshal([]byte(output), &secret); err != nil {
+		t.Fatal(err)
+	}
+	if secret.Metadata.Name != "json" || secret.Metadata.Namespace != "prod" {
+		t.Errorf("expected secret prod/json but got %s/%s", secret.Metadata.Namespace, secret.Metadata.Name)
+	}
+	if got := fmt.Sprint(secret.StringData); got != "map[password:KSOPS_DRY_RUN_PLACEHOLDER username:KSOPS_DRY_RUN_PLACEHOLDER]" {
+		t.Errorf("expected placeholder values but got %s", got)
+	}
+}
+
+func TestServerFields(t *testing.T) {
+	body, err := os.ReadFile("testdata/server-fields.enc.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := stubString(t, string(body), testOptions(t, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stubbed struct {
+		Metadata map[string]any `yaml:"metadata"`
+	}
+	if err := yaml.Unmarshal([]byte(output), &stubbed); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, field := range []string{"uid", "resourceVersion", "selfLink", "creationTimestamp", "generation", "managedFields"} {
+		if _, found := stubbed.Metadata[field]; found {
+			t.Errorf("expected metadata field %q to be dropped but got:\n%s", field, output)
+		}
+	}
+
+	// Declarative metadata fields are kept.
+	for _, field := range []string{"name", "namespace", "labels"} {
+		if _, found := stubbed.Metadata[field]; !found {
+			t.Errorf("expected metadata field %q to be kept but got:\n%s", field, output)
+		}
+	}
+}
+
+func TestAnnotateVersion(t *testing.T) {
+	body, err := os.ReadFile("testdata/server-fields.enc.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{
+			name: "unset",
+		},
+		{
+			name: "set",
+			env:  map[string]string{"KSOPS_DRY_RUN_ANNOTATE_VERSION": ""},
+			want: version,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := stubString(t, string(body), testOptions(t, test.env))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var stubbed secret
+			if err := yaml.Unmarshal([]byte(output), &stubbed); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := stubbed.Metadata.Annotations["ksops-dry-run.joshdk.github.com/version"]; got != test.want {
+				t.Errorf("expected version annotation %q but got %q", test.want, got)
+			}
+
+			// Server fields are stripped whether or not the version is added.
+			if strings.Contains(output, "managedFields") || strings.Contains(output, "manager:") {
+				t.Errorf("expected managedFields to be dropped but got:\n%s", output)
+			}
+		})
+	}
+}
+
+func TestDropKeys(t *testing.T) {
+	content := `apiVersion: v1
+kind: Secret
+metadata:
+  name: app
+  namespace: prod
+data:
+  tls.key: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]
+stringData:
+  password: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]
+  root-password: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]
+  username: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]
+`
+
+	tests := []struct {
+		name     string
+		drop     string
+		wantKeys []string
+		wantWarn bool
+	}{
+		{
+			name:     "unset",
+			wantKeys: []string{"password", "root-password", "tls.key", "username"},
+		},
+		{
+			name:     "exact name",
+			drop:     "password",
+			wantKeys: []string{"root-password", "tls.key", "username"},
+		},
+		{
+			name:     "patterns",
+			drop:     `.*password,tls\.key`,
+			wantKeys: []string{"username"},
+		},
+		{
+			name:     "every key",
+			drop:     ".*",
+			wantWarn: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := testOptions(t, map[string]string{"KSOPS_DRY_RUN_DROP_KEYS": test.drop})
+
+			output, err := stubString(t, content, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var stubbed secret
+			if err := yaml.Unmarshal([]byte(output), &stubbed); err != nil {
+				t.Fatal(err)
+			}
+
+			// The secret itself is always written, even without keys.
+			if stubbed.Metadata.Name != "app" {
+				t.Fatalf("expected secret %q but got:\n%s", "app", output)
+			}
+
+			var keys []string
+			for key := range stubbed.StringData {
+				keys = append(keys, key)
+			}
+			for key := range stubbed.Data {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			if got, want := strings.Join(keys, ","), strings.Join(test.wantKeys, ","); got != want {
+				t.Errorf("expected keys %q but got %q", want, got)
+			}
+
+			// Dropped key names never appear anywhere in the output, such as
+			// in a key order or type annotation.
+			if test.wantWarn {
+				for _, key := range []string{"password", "tls.key", "username"} {
+					if strings.Contains(output, key) {
+						t.Errorf("expected no dropped key %q in the output but got:\n%s", key, output)
+					}
+				}
+			}
+
+			err = opts.errs()
+			if test.wantWarn {
+				if err == nil || !strings.Contains(err.Error(), `secret "prod/app" has no keys after dropping keys`) {
+					t.Errorf("expected a no keys warning but got %v", err)
+				}
+			} else if err != nil {
+				t.Errorf("expected no warnings but got %v", err)
+			}
+		})
+	}
+}
+
+func TestLongValues(t *testing.T) {
+	body, err := os.ReadFile("testdata/long-values.enc.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var original secret
+	if err := yaml.Unmarshal(body, &original); err != nil {
+		t.Fatal(err)
+	}
+
+	// The values are passed through unchanged, so that they are written at
+	// their full length.
+	opts := testOptions(t, nil)
+	opts.strategy = strategyPassthrough
+
+	output, err := stubString(t, string(body), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, value := range map[string]string{
+		"annotation":       original.Metadata.Annotations["description"],
+		"data value":       original.Data["certificate"],
+		"stringData value": original.StringData["passphrase"],
+	} {
+		if len(value) < 200 {
+			t.Fatalf("expected a long %s in the fixture but got %q", name, value)
+		}
+
+		found := false
+		for _, line := range strings.Split(output, "\n") {
+			if strings.HasSuffix(line, ": "+value) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("expected the %s on a single line but got:\n%s", name, output)
+		}
+	}
+}
+
+func TestEndMarkers(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+	}{
+		{
+			name: "ends with an end marker",
+			file: "end-markers/trailing-end.enc.yaml",
+		},
+		{
+			name: "ends with a separator",
+			file: "end-markers/trailing-separator.enc.yaml",
+		},
+		{
+			name: "ends with neither",
+			file: "end-markers/no-trailing-newline.enc.yaml",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, err := os.ReadFile("testdata/" + test.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			output, err := stubString(t, string(body), testOptions(t, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var names []string
+			decoder := yaml.NewDecoder(strings.NewReader(output))
+			for {
+				var document secret
+				if err := decoder.Decode(&document); errors.Is(err, io.EOF) {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				if document.StringData["password"] != placeholder {
+					t.Errorf("expected a placeholder value but got %q", document.StringData["password"])
+				}
+				names = append(names, document.Metadata.Name)
+			}
+
+			if got := strings.Join(names, ","); got != "first,second" {
+				t.Errorf("expected secrets first,second but got %s", got)
+			}
+		})
+	}
+}
+
+func TestStrictEmpty(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		env      map[string]string
+		wantWarn bool
+		wantErr  bool
+	}{
+		{
+			name:    "not empty",
+			content: "apiVersion: v1\nkind: Secret\nmetadata:\n  name: app\n  namespace: prod\n",
+		},
+		{
+			name:     "empty",
+			content:  "",
+			wantWarn: true,
+		},
+		{
+			name:     "only comments",
+			content:  "# intentionally empty\n---\n",
+			wantWarn: true,
+		},
+		{
+			name:    "empty when strict",
+			content: "",
+			env:     map[string]string{"KSOPS_DRY_RUN_STRICT_EMPTY": ""},
+			wantErr: true,
+		},
+		{
+			name:    "not empty when strict",
+			content: "apiVersion: v1\nkind: Secret\nmetadata:\n  name: app\n  namespace: prod\n",
+			env:     map[string]string{"KSOPS_DRY_RUN_STRICT_EMPTY": ""},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeFiles(t, root, map[string]string{"secret.enc.yaml": test.content})
+
+			opts := testOptions(t, test.env)
+			config := &ksopsGeneratorConfig{Files: []string{"secret.enc.yaml"}}
+
+			_, err := generateSecrets(config, root, opts)
+			if test.wantErr {
+				want := filepath.Join(root, "secret.enc.yaml") + ": contains no secrets"
+				if err == nil || err.Error() != want {
+					t.Fatalf("expected error %q but got %v", want, err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = opts.errs()
+			if test.wantWarn {
+				if err == nil || !strings.Contains(err.Error(), "contains no secrets") {
+					t.Errorf("expected a no secrets warning but got %v", err)
+				}
+			} else if err != nil {
+				t.Errorf("expected no warnings but got %v", err)
+			}
+		})
+	}
+}
+
+func TestSecretAPIVersion(t *testing.T) {
+	encrypted := func(apiVersion string) string {
+		return "apiVersion: " + apiVersion + "\nkind: Secret\nmetadata:\n  name: app\n  namespace: prod\nstringData:\n  password: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]\n"
+	}
+
+	tests := []struct {
+		name           string
+		apiVersion     string
+		override       string
+		wantAPIVersion string
+		wantErr        string
+	}{
+		{
+			name:           "default",
+			apiVersion:     "v1",
+			wantAPIVersion: "v1",
+		},
+		{
+			name:           "overridden",
+			apiVersion:     "v1",
+			override:       "v2",
+			wantAPIVersion: "v2",
+		},
+		{
+			name:       "input is still validated",
+			apiVersion: "v2",
+			override:   "v2",
+			wantErr:    `secret.enc.yaml: expected ksops encrypted secret apiVersion "v1" but got "v2"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := testOptions(t, map[string]string{"KSOPS_DRY_RUN_SECRET_API_VERSION": test.override})
+
+			output, err := stubString(t, encrypted(test.apiVersion), opts)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("expected error %q but got %v", test.wantErr, err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var stubbed secret
+			if err := yaml.Unmarshal([]byte(output), &stubbed); err != nil {
+				t.Fatal(err)
+			}
+
+			// Only the apiVersion is changed.
+			if stubbed.APIVersion != test.wantAPIVersion {
+				t.Errorf("expected apiVersion %q but got %q", test.wantAPIVersion, stubbed.APIVersion)
+			}
+			if stubbed.Kind != "Secret" || stubbed.Metadata.Name != "app" || stubbed.StringData["password"] != placeholder {
+				t.Errorf("expected an otherwise unchanged secret but got:\n%s", output)
+			}
+		})
+	}
+}
+
+func TestConfigRoot(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"file": ""})
+
+	tests := []struct {
+		name    string
+		root    string
+		wantErr string
+	}{
+		{
+			name: "directory",
+			root: "testdata/policy",
+		},
+		{
+			name:    "missing",
+			root:    filepath.Join(dir, "missing"),
+			wantErr: fmt.Sprintf("config root %q does not exist or is not a directory", filepath.Join(dir, "missing")),
+		},
+		{
+			name:    "not a directory",
+			root:    filepath.Join(dir, "file"),
+			wantErr: fmt.Sprintf("config root %q does not exist or is not a directory", filepath.Join(dir, "file")),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := runMain(t, []string{"generator.yaml"}, pluginEnv(test.root, "compliant.enc.yaml"))
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("expected error %q but got %v", test.wantErr, err)
+				}
+				if output != "" {
+					t.Errorf("expected no output but got:\n%s", output)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	content := `apiVersion: v1
+kind: Secret
+metadata:
+  name: app
+  namespace: prod
+  labels:
+    team: platform
+type: Opaque
+data: {}
+stringData:
+  zebra: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]
+  alpha: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]
+  empty: ""
+`
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{
+			name: "default",
+			want: `apiVersion: v1
+kind: Secret
+metadata:
+    labels:
+        ksops-dry-run.joshdk.github.com: "true"
+        team: platform
+    name: app
+    namespace: prod
+type: Opaque
+stringData:
+    alpha: KSOPS_DRY_RUN_PLACEHOLDER
+    empty: ""
+    zebra: KSOPS_DRY_RUN_PLACEHOLDER
+`,
+		},
+		{
+			name: "canonical",
+			env:  map[string]string{"KSOPS_DRY_RUN_CANONICAL": ""},
+			want: `apiVersion: v1
+kind: Secret
+metadata:
+  labels:
+    ksops-dry-run.joshdk.github.com: "true"
+    team: platform
+  name: app
+  namespace: prod
+stringData:
+  alpha: KSOPS_DRY_RUN_PLACEHOLDER
+  empty: ""
+  zebra: KSOPS_DRY_RUN_PLACEHOLDER
+type: Opaque
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := stubString(t, content, testOptions(t, test.env))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The empty data is never written, and keys are always sorted.
+			if output != test.want {
+				t.Errorf("expected:\n%s\nbut got:\n%s", test.want, output)
+			}
+		})
+	}
+}
+
+func TestSearchParents(t *testing.T) {
+	tests := []struct {
+		name    string
+		parents string
+		files   map[string]string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "in the config root",
+			files: map[string]string{"overlay/components/secrets/secret.enc.yaml": "near"},
+			want:  "near",
+		},
+		{
+			name:    "two levels up",
+			parents: "2",
+			files:   map[string]string{"overlay/secret.enc.yaml": "far"},
+			want:    "far",
+		},
+		{
+			name:    "nearest first",
+			parents: "2",
+			files: map[string]string{
+				"overlay/components/secret.enc.yaml": "near",
+				"overlay/secret.enc.yaml":            "far",
+			},
+			want: "near",
+		},
+		{
+			name:    "beyond the limit",
+			parents: "1",
+			files:   map[string]string{"overlay/secret.enc.yaml": "far"},
+			wantErr: true,
+		},
+		{
+			name:    "without searching",
+			files:   map[string]string{"overlay/secret.enc.yaml": "far"},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			root := filepath.Join(dir, "overlay/components/secrets")
+			if err := os.MkdirAll(root, 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			// The secret name records which file was found.
+			files := make(map[string]string, len(test.files))
+			for name, secret := range test.files {
+				files[name] = "apiVersion: v1\nkind: Secret\nmetadata:\n  name: " + secret + "\n  namespace: prod\n"
+			}
+			writeFiles(t, dir, files)
+
+			opts := testOptions(t, map[string]string{"KSOPS_DRY_RUN_SEARCH_PARENTS": test.parents})
+			config := &ksopsGeneratorConfig{Files: []string{"secret.enc.yaml"}}
+
+			documents, err := generateSecrets(config, root, opts)
+			if test.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "no such file or directory") {
+					t.Fatalf("expected a missing file error but got %v", err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			secrets := secretsOf(documents)
+			if len(secrets) != 1 || secrets[0].Metadata.Name != test.want {
+				t.Errorf("expected secret %q but got %v", test.want, secrets)
+			}
+		})
+	}
+}
+
+func TestServerSideSafe(t *testing.T) {
+	content := `apiVersion: v1
+kind: Secret
+metadata:
+  name: app
+  namespace: prod
+data:
+  tls.key: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]
+stringData:
+  password: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]
+  empty: ""
+`
+
+	tests := []struct {
+		name           string
+		env            map[string]string
+		wantStringData bool
+	}{
+		{
+			name:           "default",
+			wantStringData: true,
+		},
+		{
+			name: "server side safe",
+			env:  map[string]string{"KSOPS_DRY_RUN_SERVER_SIDE_SAFE": ""},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := stubString(t, content, testOptions(t, test.env))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var stubbed secret
+			if err := yaml.Unmarshal([]byte(output), &stubbed); err != nil {
+				t.Fatal(err)
+			}
+
+			if hasStringData := strings.Contains(output, "stringData:"); hasStringData != test.wantStringData {
+				t.Fatalf("expected stringData to be written to be %t but got:\n%s", test.wantStringData, output)
+			}
+			if test.wantStringData {
+				return
+			}
+
+			// Every value is valid base64, and decodes to what would otherwise
+			// have been written as stringData.
+			want := map[string]string{"tls.key": placeholder, "password": placeholder, "empty": ""}
+			if len(stubbed.Data) != len(want) {
+				t.Fatalf("expected %d data keys but got:\n%s", len(want), output)
+			}
+			for key, value := range want {
+				decoded, err := base64.StdEncoding.DecodeString(stubbed.Data[key])
+				if err != nil {
+					t.Errorf("expected key %q to be valid base64 but got %q: %v", key, stubbed.Data[key], err)
+				} else if string(decoded) != value {
+					t.Errorf("expected key %q to decode to %q but got %q", key, value, decoded)
+				}
+			}
+		})
+	}
+}
+
+func TestNormalizeStyle(t *testing.T) {
+	body, err := os.ReadFile("testdata/passthrough/flow-style.enc.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{
+			name: "original style",
+			env:  map[string]string{"KSOPS_DRY_RUN_PASSTHROUGH_OTHERS": ""},
+			want: `apiVersion: v1
+kind: Secret
+metadata:
+    labels:
+        ksops-dry-run.joshdk.github.com: "true"
+    name: app
+    namespace: prod
+stringData:
+    password: KSOPS_DRY_RUN_PLACEHOLDER
+    username: KSOPS_DRY_RUN_PLACEHOLDER
+---
+apiVersion: v1
+kind: ConfigMap
+metadata: {name: app-settings, namespace: prod}
+data: {log-level: debug, replicas: "3"}
+`,
+		},
+		{
+			name: "block style",
+			env:  map[string]string{"KSOPS_DRY_RUN_PASSTHROUGH_OTHERS": "", "KSOPS_DRY_RUN_NORMALIZE_STYLE": ""},
+			want: `apiVersion: v1
+kind: Secret
+metadata:
+    labels:
+        ksops-dry-run.joshdk.github.com: "true"
+    name: app
+    namespace: prod
+stringData:
+    password: KSOPS_DRY_RUN_PLACEHOLDER
+    username: KSOPS_DRY_RUN_PLACEHOLDER
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+    name: app-settings
+    namespace: prod
+data:
+    log-level: debug
+    replicas: "3"
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Stubbed secrets are always written in block style, even when
+			// the encrypted secret was not.
+			output, err := stubString(t, string(body), testOptions(t, test.env))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if output != test.want {
+				t.Errorf("expected:\n%s\nbut got:\n%s", test.want, output)
+			}
+		})
+	}
+}
+
+func TestSkipFiles(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       map[string]string
+		want      []string
+		wantDebug []string
+	}{
+		{
+			name: "unset",
+			want: []string{"app", "cache", "database"},
+		},
+		{
+			name: "exact path",
+			env:  map[string]string{"KSOPS_DRY_RUN_SKIP_FILES": "nested/cache.enc.yaml"},
+			want: []string{"app", "database"},
+		},
+		{
+			name: "glob",
+			env:  map[string]string{"KSOPS_DRY_RUN_SKIP_FILES": "*/*.enc.yaml,app.*"},
+			want: []string{"database"},
+		},
+		{
+			name:      "glob with debug",
+			env:       map[string]string{"KSOPS_DRY_RUN_SKIP_FILES": "*/*.enc.yaml,app.*", "KSOPS_DRY_RUN_DEBUG": ""},
+			want:      []string{"database"},
+			wantDebug: []string{"app.enc.yaml", "nested/cache.enc.yaml"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeFiles(t, root, map[string]string{
+				"app.enc.yaml":          "apiVersion: v1\nkind: Secret\nmetadata:\n  name: app\n  namespace: prod\n",
+				"database.enc.yaml":     "apiVersion: v1\nkind: Secret\nmetadata:\n  name: database\n  namespace: prod\n",
+				"nested/cache.enc.yaml": "apiVersion: v1\nkind: Secret\nmetadata:\n  name: cache\n  namespace: prod\n",
+			})
+
+			opts := testOptions(t, test.env)
+			config := &ksopsGeneratorConfig{Files: []string{"app.enc.yaml", "nested/cache.enc.yaml", "database.enc.yaml"}}
+
+			var documents []document
+			var err error
+			stderr := captureStderr(t, func() {
+				documents, err = generateSecrets(config, root, opts)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var names []string
+			for _, secret := range secretsOf(documents) {
+				names = append(names, secret.Metadata.Name)
+			}
+			sort.Strings(names)
+			if got, want := strings.Join(names, ","), strings.Join(test.want, ","); got != want {
+				t.Errorf("expected secrets %s but got %s", want, got)
+			}
+
+			// Skipped files are only logged at debug level.
+			var want string
+			for _, file := range test.wantDebug {
+				want += "ksops-dry-run: debug: " + file + ": skipped\n"
+			}
+			if stderr != want {
+				t.Errorf("expected stderr %q but got %q", want, stderr)
+			}
+		})
+	}
+}
+
+func TestSortedMetadata(t *testing.T) {
+	content := `apiVersion: v1
+kind: Secret
+metadata:
+  name: app
+  namespace: prod
+  annotations:
+    zeta: "1"
+    alpha: "2"
+    mu: "3"
+    beta: "4"
+    omega: "5"
+  labels:
+    tier: backend
+    app: web
+    env: prod
+    component: api
+stringData:
+  password: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]
+`
+
+	want, err := stubString(t, content, testOptions(t, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		field    string
+		wantKeys []string
+	}{
+		{
+			name:     "annotations",
+			field:    "annotations",
+			wantKeys: []string{"alpha", "beta", "mu", "omega", "zeta"},
+		},
+		{
+			name:     "labels",
+			field:    "labels",
+			wantKeys: []string{"app", "component", "env", "ksops-dry-run.joshdk.github.com", "tier"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var stubbed struct {
+				Metadata map[string]yaml.Node `yaml:"metadata"`
+			}
+			if err := yaml.Unmarshal([]byte(want), &stubbed); err != nil {
+				t.Fatal(err)
+			}
+
+			node := stubbed.Metadata[test.field]
+			var keys []string
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				keys = append(keys, node.Content[i].Value)
+			}
+			if got, want := strings.Join(keys, ","), strings.Join(test.wantKeys, ","); got != want {
+				t.Errorf("expected %s in order %s but got %s", test.field, want, got)
+			}
+		})
+	}
+
+	// Map iteration order is randomized, so a handful of runs would catch
+	// any ordering that depended on it.
+	for i := 0; i < 10; i++ {
+		output, err := stubString(t, content, testOptions(t, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output != want {
+			t.Fatalf("expected identical output across runs but got:\n%s\nand:\n%s", want, output)
+		}
+	}
+}
+
+func TestNonce(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"app.enc.yaml":      "apiVersion: v1\nkind: Secret\nmetadata:\n  name: app\n  namespace: prod\n",
+		"database.enc.yaml": "apiVersion: v1\nkind: Secret\nmetadata:\n  name: database\n  namespace: prod\n",
+	})
+
+	uuidPattern := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	// runIDs runs the plugin and returns the run-id annotation of each secret.
+	runIDs := func(t *testing.T, env map[string]string) []string {
+		t.Helper()
+
+		output, err := runMain(t, []string{"generator.yaml"}, env)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ids []string
+		decoder := yaml.NewDecoder(strings.NewReader(output))
+		for {
+			var stubbed secret
+			if err := decoder.Decode(&stubbed); errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, stubbed.Metadata.Annotations["ksops-dry-run.joshdk.github.com/run-id"])
+		}
+		if len(ids) != 2 {
+			t.Fatalf("expected 2 secrets but got:\n%s", output)
+		}
+
+		return ids
+	}
+
+	t.Run("unset", func(t *testing.T) {
+		for _, id := range runIDs(t, pluginEnv(root, "app.enc.yaml", "database.enc.yaml")) {
+			if id != "" {
+				t.Errorf("expected no run-id annotation but got %q", id)
+			}
+		}
+	})
+
+	t.Run("set", func(t *testing.T) {
+		env := pluginEnv(root, "app.enc.yaml", "database.enc.yaml")
+		env["KSOPS_DRY_RUN_NONCE"] = ""
+
+		first := runIDs(t, env)
+		if !uuidPattern.MatchString(first[0]) {
+			t.Errorf("expected a uuid but got %q", first[0])
+		}
+
+		// Every secret in a single run has the same nonce.
+		if first[0] != first[1] {
+			t.Errorf("expected the same run-id for every secret but got %q and %q", first[0], first[1])
+		}
+
+		// Every run has a different nonce.
+		if second := runIDs(t, env); second[0] == first[0] {
+			t.Errorf("expected a different run-id for every run but got %q twice", first[0])
+		}
+	})
+}
+
+func TestPreserveKeyOrder(t *testing.T) {
+	content := `apiVersion: v1
+kind: Secret
+metadata:
+  name: app
+  namespace: prod
+data:
+  tls.key: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]
+  ca.crt: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]
+stringData:
+  DATABASE_URL: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]
+  DATABASE_PASSWORD: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]
+  API_TOKEN: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]
+  CACHE_URL: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]
+`
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		want []string
+	}{
+		{
+			name: "unset",
+			want: []string{"API_TOKEN", "CACHE_URL", "DATABASE_PASSWORD", "DATABASE_URL", "ca.crt", "tls.key"},
+		},
+		{
+			name: "set",
+			env:  map[string]string{"KSOPS_DRY_RUN_PRESERVE_KEY_ORDER": ""},
+			want: []string{"DATABASE_URL", "DATABASE_PASSWORD", "API_TOKEN", "CACHE_URL", "tls.key", "ca.crt"},
+		},
+		{
+			name: "canonical",
+			env:  map[string]string{"KSOPS_DRY_RUN_PRESERVE_KEY_ORDER": "", "KSOPS_DRY_RUN_CANONICAL": ""},
+			want: []string{"API_TOKEN", "CACHE_URL", "DATABASE_PASSWORD", "DATABASE_URL", "ca.crt", "tls.key"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := stubString(t, content, testOptions(t, test.env))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Values from data are written to stringData, after the values
+			// that were already there.
+			var stubbed struct {
+				StringData yaml.Node `yaml:"stringData"`
+			}
+			if err := yaml.Unmarshal([]byte(output), &stubbed); err != nil {
+				t.Fatal(err)
+			}
+
+			var keys []string
+			for i := 0; i+1 < len(stubbed.StringData.Content); i += 2 {
+				keys = append(keys, stubbed.StringData.Content[i].Value)
+			}
+			if got, want := strings.Join(keys, ","), strings.Join(test.want, ","); got != want {
+				t.Errorf("expected keys in order %s but got %s", want, got)
+			}
+		})
+	}
+}
+
+func TestDuplicateKeys(t *testing.T) {
+	duplicated, err := os.ReadFile("testdata/duplicate-keys.enc.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unique := strings.Replace(string(duplicated), "    password: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]\n", "", 1)
+
+	tests := []struct {
+		name     string
+		content  string
+		env      map[string]string
+		wantErr  string
+		wantKeys string
+	}{
+		{
+			name:     "unique keys",
+			content:  unique,
+			wantKeys: "password,tls.key,username",
+		},
+		{
+			name:     "duplicate key warns",
+			content:  string(duplicated),
+			wantErr:  `secret.enc.yaml: secret "prod/database" has duplicate key "stringData.password"`,
+			wantKeys: "password,tls.key,username",
+		},
+		{
+			name:    "duplicate key errors when strict",
+			content: string(duplicated),
+			env:     map[string]string{"KSOPS_DRY_RUN_STRICT": ""},
+			wantErr: `secret.enc.yaml: secret "prod/database" has duplicate key "stringData.password"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := testOptions(t, test.env)
+
+			output, err := stubString(t, test.content, opts)
+			if err == nil {
+				err = opts.errs()
+			}
+			if test.wantErr == "" && err != nil {
+				t.Fatal(err)
+			} else if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Fatalf("expected error %q but got %v", test.wantErr, err)
+			}
+
+			// A duplicate key is only warned about, and the secret is still
+			// written with the key once, unless strict.
+			if test.wantKeys == "" {
+				if output != "" {
+					t.Errorf("expected no output but got:\n%s", output)
+				}
+				return
+			}
+
+			var stubbed secret
+			if err := yaml.Unmarshal([]byte(output), &stubbed); err != nil {
+				t.Fatal(err)
+			}
+			keys := make(map[string]any)
+			for key := range stubbed.StringData {
+				keys[key] = nil
+			}
+			if got := sortedKeys(keys); got != test.wantKeys {
+				t.Errorf("expected keys %s but got %s", test.wantKeys, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// documentEndReader rewrites a yaml stream so that every document following a
+// ... document end marker is explicitly started with a --- separator. The yaml
+// spec permits a bare document after a document end marker, but the decoder
+// rejects one, and would otherwise fail on every document after the first.
+type documentEndReader struct {
+	reader  *bufio.Reader
+	pending []byte
+	ended   bool
+}
+
+// newDocumentEndReader returns a reader of the given yaml stream, where every
+// document after a ... marker starts with a --- separator.
+func newDocumentEndReader(reader io.Reader) *documentEndReader {
+	return &documentEndReader{reader: bufio.NewReader(reader)}
+}
+
+// Read implements io.Reader, rewriting the stream one line at a time.
+func (r *documentEndReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		line, err := r.reader.ReadBytes('\n')
+		if len(line) > 0 {
+			r.pending = r.rewrite(line)
+		}
+		if err != nil {
+			if len(r.pending) > 0 {
+				break
+			}
+
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+
+	return n, nil
+}
+
+// rewrite returns the given line, preceded by a --- separator if it is the
+// first content of a bare document following a ... marker.
+func (r *documentEndReader) rewrite(line []byte) []byte {
+	trimmed := bytes.TrimRight(line, " \t\r\n")
+
+	switch {
+	case isMarker(trimmed, "..."):
+		r.ended = true
+	case !r.ended:
+	case len(bytes.TrimSpace(trimmed)) == 0, bytes.TrimSpace(trimmed)[0] == '#', trimmed[0] == '%':
+		// Blank lines, comments, and directives may all appear between a
+		// document end marker and the start of the next document.
+	case isMarker(trimmed, "---"):
+		r.ended = false
+	default:
+		r.ended = false
+
+		return append([]byte("---\n"), line...)
+	}
+
+	return line
+}
+
+// isMarker returns true if the given line is the given document marker,
+// optionally followed by content (e.g. --- !tag or ... # comment).
+func isMarker(line []byte, marker string) bool {
+	if !bytes.HasPrefix(line, []byte(marker)) {
+		return false
+	}
+
+	return len(line) == len(marker) || line[len(marker)] == ' ' || line[len(marker)] == '\t'
+}
@@ -0,0 +1,190 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// mergeSecrets merges every stubbed secret with the same namespace and name
+// into the first of them, so that a secret split across several files is
+// written only once. Keys are merged from both data and stringData, and a key
+// defined by more than one file is warned about, with the last value kept.
+// Labels and annotations are merged in the same way, but silently. Secrets
+// named by generateName are never merged, as they are always distinct.
+func mergeSecrets(documents []document, opts *options) ([]document, error) {
+	type merged struct {
+		secret  *secret
+		sources map[string]string
+		index   int
+	}
+
+	// mergedInto holds every secret that another was merged into, in order.
+	var mergedInto []*merged
+
+	var result []document
+	seen := make(map[string]*merged)
+	for _, document := range documents {
+		if document.secret == nil || document.secret.Metadata.Name == "" {
+			result = append(result, document)
+
+			continue
+		}
+
+		id := document.secret.Metadata.Namespace + "/" + document.secret.Metadata.Name
+		first, found := seen[id]
+		if !found {
+			first = &merged{secret: document.secret, sources: make(map[string]string), index: len(result)}
+			for _, key := range keysOfSecret(document.secret) {
+				first.sources[key] = document.secret.source
+			}
+			seen[id] = first
+			result = append(result, document)
+
+			continue
+		}
+
+		if len(first.secret.parsed) == 1 {
+			mergedInto = append(mergedInto, first)
+		}
+
+		other := document.secret
+		for _, key := range keysOfSecret(other) {
+			if source, found := first.sources[key]; found {
+				opts.warnf(other.source, "secret %q key %q is also defined in %s", other.displayName(), key, source)
+
+				// Remove the earlier value, which may be in either field.
+				delete(first.secret.StringData, key)
+				delete(first.secret.Data, key)
+			}
+			first.sources[key] = other.source
+		}
+
+		first.secret.StringData = mergeValues(first.secret.StringData, other.StringData)
+		first.secret.Data = mergeValues(first.secret.Data, other.Data)
+		first.secret.Metadata.Labels = mergeMissing(first.secret.Metadata.Labels, other.Metadata.Labels)
+		first.secret.Metadata.Annotations = mergeMissing(first.secret.Metadata.Annotations, other.Metadata.Annotations)
+		if first.secret.keyOrder != nil {
+			first.secret.keyOrder = append(first.secret.keyOrder, other.keyOrder...)
+		}
+
+		// Keep every original secret, so that each is still checked against
+		// the policy.
+		first.secret.parsed = append(first.secret.parsed, other.parsed...)
+	}
+
+	// The hash of each secret was computed before it was merged, so compute
+	// it again from every secret that it was merged from.
+	for _, first := range mergedInto {
+		if err := rehashMerged(&result[first.index], opts); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// rehashMerged computes the hash of the given merged secret again, from the
+// original values of every secret that it was merged from, and replaces the
+// hash annotation and comment with it. The comment also lists every file that
+// the secret was merged from.
+func rehashMerged(document *document, opts *options) error {
+	merged := document.secret
+	if merged.nameHash == "" {
+		return nil
+	}
+
+	// Merge the original values in the same way as the stubbed ones, where
+	// a later key replaces an earlier one in either field.
+	original := *merged.parsed[0]
+	original.StringData, original.Data = nil, nil
+	var sources []string
+	for _, parsed := range merged.parsed {
+		for key := range parsed.StringData {
+			delete(original.Data, key)
+		}
+		for key := range parsed.Data {
+			delete(original.StringData, key)
+		}
+		original.StringData = mergeValues(original.StringData, parsed.StringData)
+		original.Data = mergeValues(original.Data, parsed.Data)
+
+		if len(sources) == 0 || sources[len(sources)-1] != parsed.source {
+			sources = append(sources, parsed.source)
+		}
+	}
+
+	nameHash, err := secretHash(original)
+	if err != nil {
+		return &fileError{file: merged.source, err: err}
+	}
+
+	if opts.hashPreview {
+		opts.infof(merged.source, "secret %q merged from %s would be named %s-%s", merged.displayName(), strings.Join(sources, ", "), merged.Metadata.Name, nameHash)
+	}
+
+	// An annotation that was present on the original secret is never
+	// replaced, only the one that was added.
+	if value, found := merged.Metadata.Annotations["ksops-dry-run.joshdk.github.com/hash"]; found && value == merged.nameHash {
+		merged.Metadata.Annotations["ksops-dry-run.joshdk.github.com/hash"] = nameHash
+	}
+
+	if opts.hashComment {
+		document.comment = "name-suffix: " + nameHash + "\nmerged-from: " + strings.Join(sources, ", ")
+	}
+
+	merged.nameHash = nameHash
+
+	return nil
+}
+
+// keysOfSecret returns the keys of both the stringData and data of the given
+// secret, sorted so that any warnings are stable.
+func keysOfSecret(secret *secret) []string {
+	keys := make([]string, 0, len(secret.StringData)+len(secret.Data))
+	for key := range secret.StringData {
+		keys = append(keys, key)
+	}
+	for key := range secret.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// mergeValues returns the given values with the other values added, which
+// replace any existing values.
+func mergeValues(values, other map[string]string) map[string]string {
+	if len(other) == 0 {
+		return values
+	}
+	if values == nil {
+		values = make(map[string]string, len(other))
+	}
+	for key, value := range other {
+		values[key] = value
+	}
+
+	return values
+}
+
+// mergeMissing returns the given values with the other values added, except
+// for those that already exist.
+func mergeMissing(values, other map[string]string) map[string]string {
+	for key, value := range other {
+		if _, found := values[key]; found {
+			continue
+		}
+		if values == nil {
+			values = make(map[string]string, len(other))
+		}
+		values[key] = value
+	}
+
+	return values
+}
@@ -0,0 +1,139 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestMergeByName(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"username.enc.yaml": `apiVersion: v1
+kind: Secret
+metadata:
+  name: database
+  namespace: prod
+  labels:
+    team: data
+stringData:
+  username: ENC[AES256_GCM,data:dXNlcg==,iv:aXY=,tag:dGFn,type:str]
+`,
+		"password.enc.yaml": `apiVersion: v1
+kind: Secret
+metadata:
+  name: database
+  namespace: prod
+  annotations:
+    rotated: "2026-01-01"
+stringData:
+  password: ENC[AES256_GCM,data:cGFzcw==,iv:aXY=,tag:dGFn,type:str]
+`,
+		"other.enc.yaml": `apiVersion: v1
+kind: Secret
+metadata:
+  name: cache
+  namespace: prod
+stringData:
+  password: ENC[AES256_GCM,data:cGFzcw==,iv:aXY=,tag:dGFn,type:str]
+`,
+		"combined.enc.yaml": `apiVersion: v1
+kind: Secret
+metadata:
+  name: database
+  namespace: prod
+stringData:
+  username: ENC[AES256_GCM,data:dXNlcg==,iv:aXY=,tag:dGFn,type:str]
+  password: ENC[AES256_GCM,data:cGFzcw==,iv:aXY=,tag:dGFn,type:str]
+`,
+	})
+
+	// stub runs the plugin for the given files and returns every secret
+	// written, along with the hash suffix comment after each.
+	stub := func(t *testing.T, env map[string]string, files ...string) ([]secret, []string) {
+		t.Helper()
+
+		pluginEnv := pluginEnv(root, files...)
+		for name, value := range env {
+			pluginEnv[name] = value
+		}
+
+		output, err := runMain(t, []string{"generator.yaml"}, pluginEnv)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var secrets []secret
+		for _, document := range strings.Split(output, "---\n") {
+			var secret secret
+			if err := yaml.Unmarshal([]byte(document), &secret); err != nil {
+				t.Fatal(err)
+			}
+			secrets = append(secrets, secret)
+		}
+
+		var comments []string
+		for _, match := range regexp.MustCompile(`(?m)^# (.+)$`).FindAllStringSubmatch(output, -1) {
+			comments = append(comments, match[1])
+		}
+
+		return secrets, comments
+	}
+
+	// The Flux annotations include the hash annotation.
+	env := map[string]string{
+		"KSOPS_DRY_RUN_MERGE_BY_NAME":      "",
+		"KSOPS_DRY_RUN_FLUX":               "",
+		"KSOPS_DRY_RUN_HASH_COMMENT":       "",
+		"KSOPS_DRY_RUN_WARNINGS_AS_ERRORS": "",
+	}
+
+	secrets, comments := stub(t, env, "username.enc.yaml", "other.enc.yaml", "password.enc.yaml")
+	if len(secrets) != 2 {
+		t.Fatalf("expected 2 secrets but got %d", len(secrets))
+	}
+
+	// The two files are merged into a single secret, in the position of the
+	// first, with the keys and metadata of both.
+	merged := secrets[0]
+	if merged.Metadata.Name != "database" || secrets[1].Metadata.Name != "cache" {
+		t.Fatalf("expected secrets database and cache but got %s and %s", merged.Metadata.Name, secrets[1].Metadata.Name)
+	}
+	if got := fmt.Sprint(merged.StringData); got != "map[password:KSOPS_DRY_RUN_PLACEHOLDER username:KSOPS_DRY_RUN_PLACEHOLDER]" {
+		t.Errorf("expected the keys of both files but got %s", got)
+	}
+	if merged.Metadata.Labels["team"] != "data" || merged.Metadata.Annotations["rotated"] != "2026-01-01" {
+		t.Errorf("expected the metadata of both files but got %v and %v", merged.Metadata.Labels, merged.Metadata.Annotations)
+	}
+
+	// The hash is of the merged values, so it is the same as that of a
+	// single file with every key, and differs from that of either file.
+	single, _ := stub(t, env, "combined.enc.yaml")
+	first, _ := stub(t, env, "username.enc.yaml")
+	hash := merged.Metadata.Annotations["ksops-dry-run.joshdk.github.com/hash"]
+	if want := single[0].Metadata.Annotations["ksops-dry-run.joshdk.github.com/hash"]; hash != want {
+		t.Errorf("expected hash %q of the merged values but got %q", want, hash)
+	}
+	if other := first[0].Metadata.Annotations["ksops-dry-run.joshdk.github.com/hash"]; hash == other {
+		t.Errorf("expected a hash other than that of the first file but got %q", hash)
+	}
+
+	// The comment holds the same hash, and lists every merged file.
+	want := []string{
+		"name-suffix: " + hash,
+		"merged-from: " + filepath.Join(root, "username.enc.yaml") + ", " + filepath.Join(root, "password.enc.yaml"),
+		"name-suffix: " + secrets[1].Metadata.Annotations["ksops-dry-run.joshdk.github.com/hash"],
+	}
+	if strings.Join(comments, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected comments:\n%s\nbut got:\n%s", strings.Join(want, "\n"), strings.Join(comments, "\n"))
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+// checkNamespaces warns about every secret name that is generated both with
+// and without a namespace. The same name in different namespaces is usually
+// intentional, but a secret without a namespace is placed in whichever
+// namespace it is applied to, which may then collide with the other.
+func checkNamespaces(secrets []secret, opts *options) {
+	namespaced := make(map[string]secret)
+	unnamespaced := make(map[string]secret)
+	var names []string
+
+	for _, secret := range secrets {
+		name := secret.Metadata.Name
+		if name == "" {
+			continue
+		}
+
+		_, seenNamespaced := namespaced[name]
+		_, seenUnnamespaced := unnamespaced[name]
+		if !seenNamespaced && !seenUnnamespaced {
+			names = append(names, name)
+		}
+
+		if secret.Metadata.Namespace != "" && !seenNamespaced {
+			namespaced[name] = secret
+		} else if secret.Metadata.Namespace == "" && !seenUnnamespaced {
+			unnamespaced[name] = secret
+		}
+	}
+
+	// Names are reported in the order that they were first generated, so
+	// that the warnings are stable.
+	for _, name := range names {
+		with, hasNamespace := namespaced[name]
+		without, hasNoNamespace := unnamespaced[name]
+		if hasNamespace && hasNoNamespace {
+			opts.warnf(without.source, "secret %q has no namespace, but is also generated in namespace %q (from %s)", name, with.Metadata.Namespace, with.source)
+		}
+	}
+}
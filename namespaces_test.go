@@ -0,0 +1,142 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestCheckNamespaces(t *testing.T) {
+	tests := []struct {
+		name       string
+		secrets    []string
+		wantErrors []string
+	}{
+		{
+			name:    "different namespaces",
+			secrets: []string{"prod/database", "staging/database"},
+		},
+		{
+			name:    "without namespaces",
+			secrets: []string{"database", "cache"},
+		},
+		{
+			name:    "with and without a namespace",
+			secrets: []string{"prod/database", "cache", "database", "staging/database", "database"},
+			wantErrors: []string{
+				`database.enc.yaml: secret "database" has no namespace, but is also generated in namespace "prod" (from prod/database.enc.yaml)`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			secrets := make([]secret, len(test.secrets))
+			for i, id := range test.secrets {
+				namespace, name, found := strings.Cut(id, "/")
+				if !found {
+					namespace, name = "", id
+				}
+				secrets[i].Metadata.Name = name
+				secrets[i].Metadata.Namespace = namespace
+				secrets[i].source = id + ".enc.yaml"
+			}
+
+			// Warnings are collected as errors by the test options.
+			opts := testOptions(t, nil)
+			checkNamespaces(secrets, opts)
+
+			var errs []error
+			if err := opts.errs(); err != nil {
+				errs = flattenErrors(err)
+			}
+			if len(errs) != len(test.wantErrors) {
+				t.Fatalf("expected %d warnings but got %v", len(test.wantErrors), errs)
+			}
+			for i, want := range test.wantErrors {
+				if !strings.Contains(errs[i].Error(), want) {
+					t.Errorf("expected warning %q but got %q", want, errs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestForceNamespace(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      string
+		namespace string
+		want      string
+		wantErr   string
+	}{
+		{
+			name: "unset keeps the namespace",
+			file: "policy/compliant.enc.yaml",
+			want: "prod",
+		},
+		{
+			name:      "overrides the namespace",
+			file:      "policy/compliant.enc.yaml",
+			namespace: "scratch",
+			want:      "scratch",
+		},
+		{
+			name:      "sets a missing namespace",
+			file:      "warnings/no-namespace.enc.yaml",
+			namespace: "scratch",
+			want:      "scratch",
+		},
+		{
+			name:      "invalid namespace",
+			namespace: "Not_A_Namespace",
+			wantErr:   `expected KSOPS_DRY_RUN_FORCE_NAMESPACE to be a valid namespace name but got "Not_A_Namespace"`,
+		},
+		{
+			name:      "too long namespace",
+			namespace: strings.Repeat("a", 64),
+			wantErr:   "expected KSOPS_DRY_RUN_FORCE_NAMESPACE to be a valid namespace name",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("KSOPS_DRY_RUN_FORCE_NAMESPACE", test.namespace)
+
+			opts, err := loadOptions()
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected error %q but got %v", test.wantErr, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			body, err := os.ReadFile("testdata/" + test.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			output, err := stubString(t, string(body), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var stubbed secret
+			if err := yaml.Unmarshal([]byte(output), &stubbed); err != nil {
+				t.Fatal(err)
+			}
+			if stubbed.Metadata.Namespace != test.want {
+				t.Errorf("expected namespace %q but got %q", test.want, stubbed.Metadata.Namespace)
+			}
+		})
+	}
+}
@@ -0,0 +1,873 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// placeholder is the value used in place of every encrypted secret value.
+const placeholder = "KSOPS_DRY_RUN_PLACEHOLDER"
+
+// defaultEncryptedMarker is prefixed to the placeholder value when
+// KSOPS_DRY_RUN_ENCRYPTED_MARKER is set without a value. This is kept to plain
+// ascii, as the yaml encoder escapes characters such as emoji.
+const defaultEncryptedMarker = "ENCRYPTED_"
+
+// defaultReferencePrefixes are the prefixes of values that are references to
+// an external secret manager, used when KSOPS_DRY_RUN_PRESERVE_REFS is set
+// without a value.
+var defaultReferencePrefixes = []string{"vault:", "ssm:"}
+
+// namespacePattern matches a valid kubernetes namespace name, which is a
+// dns-1123 label.
+var namespacePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// labelValuePattern matches valid label values.
+var labelValuePattern = regexp.MustCompile(`^(([A-Za-z0-9][-A-Za-z0-9_.]*)?[A-Za-z0-9])?$`)
+
+// defaultMaxDocs is the maximum number of yaml documents allowed in a single
+// encrypted file, unless overridden by KSOPS_DRY_RUN_MAX_DOCS.
+const defaultMaxDocs = 10000
+
+// defaultMaxKeys is the maximum number of keys allowed in a single secret,
+// unless overridden by KSOPS_DRY_RUN_MAX_KEYS.
+const defaultMaxKeys = 10000
+
+// defaultLabelKey is the key of the label added to every generated secret, so
+// that the generated resources can be selected, e.g. to ignore them during a
+// kubectl apply.
+const defaultLabelKey = "ksops-dry-run.joshdk.github.com"
+
+// argocdAnnotations are added to every generated secret when
+// KSOPS_DRY_RUN_ARGOCD is set, so that Argo CD neither reports the stubbed
+// secrets as out of sync nor prunes them.
+var argocdAnnotations = map[string]string{
+	"argocd.argoproj.io/compare-options": "IgnoreExtraneous",
+	"argocd.argoproj.io/sync-options":    "Prune=false",
+}
+
+// fluxAnnotations are added to every generated secret when KSOPS_DRY_RUN_FLUX
+// is set, so that the Flux kustomize-controller merges, rather than replaces,
+// the stubbed secrets during server-side apply.
+var fluxAnnotations = map[string]string{
+	"kustomize.toolkit.fluxcd.io/ssa": "merge",
+}
+
+// options represents the user-configurable behavior of dry-run mode.
+type options struct {
+	// encryptedPlaceholder is the value used in place of every encrypted
+	// secret value.
+	encryptedPlaceholder string
+
+	// cluster is an optional cluster name that is added as a label to every
+	// generated secret.
+	cluster string
+
+	// maxAge is the age after which a secret is warned about as being overdue
+	// for rotation, or zero to never warn.
+	maxAge time.Duration
+
+	// template is an optional template that is rendered for every key in
+	// place of the placeholder.
+	template *template.Template
+
+	// marker is prefixed to the placeholder of every encrypted value, but not
+	// to that of a plaintext value.
+	marker string
+
+	// labelKey and labelValue make up the label added to every generated
+	// secret. No label is added if labelKey is empty.
+	labelKey   string
+	labelValue string
+
+	// strategy is the strategy used for replacing encrypted values with
+	// placeholders.
+	strategy string
+
+	// strategies maps encrypted filenames to the strategy used for that file,
+	// overriding the default strategy.
+	strategies map[string]string
+
+	// passthroughFiles is the set of filenames (as they appear in a generator
+	// config) whose original values are written unchanged.
+	passthroughFiles map[string]struct{}
+
+	// stdinTimeout is the maximum time allowed to read a resource list from
+	// stdin in KRM function mode, or zero to wait indefinitely.
+	stdinTimeout time.Duration
+
+	// httpTimeout is the maximum time allowed to fetch an encrypted file
+	// over http.
+	httpTimeout time.Duration
+
+	// httpToken is an optional bearer token used when fetching an encrypted
+	// file over http.
+	httpToken string
+
+	// annotations are added to every generated secret, without overwriting
+	// any annotations that the secret already has.
+	annotations map[string]string
+
+	// annotateHash adds an annotation to every generated secret with the
+	// hash suffix that kustomize would give it.
+	annotateHash bool
+
+	// annotateTypes adds an annotation to every generated secret describing
+	// the shape of the original value of each key.
+	annotateTypes bool
+
+	// annotateVersion adds an annotation to every generated secret with the
+	// version of ksops-dry-run.
+	annotateVersion bool
+
+	// nonce is an optional identifier, unique to this run, that is added as
+	// an annotation to every generated secret.
+	nonce string
+
+	// annotateRecipients adds an annotation to every generated secret listing
+	// the keys that it was encrypted to.
+	annotateRecipients bool
+
+	// annotateSops adds an annotation to every generated secret summarizing
+	// the non-sensitive parts of its sops metadata.
+	annotateSops bool
+
+	// passthroughOthers allows encrypted files to contain resources other
+	// than secrets, which are output unmodified.
+	passthroughOthers bool
+
+	// skipNonSecrets silently skips resources other than secrets in encrypted
+	// files, instead of rejecting them.
+	skipNonSecrets bool
+
+	// filePrefix is an optional directory that encrypted files are resolved
+	// relative to, in place of the config root.
+	filePrefix string
+
+	// searchParents is the number of parent directories of the config root
+	// that are searched for an encrypted file that is not found in the config
+	// root itself.
+	searchParents int
+
+	// only is an optional secret name (or namespace/name) that limits the
+	// output to the matching secrets.
+	only string
+
+	// changedSince is an optional git ref, where only files that have
+	// changed since that ref are processed.
+	changedSince string
+
+	// ignore is the ignore file in the config root, if any, that lists the
+	// encrypted files that are skipped once resolved.
+	ignore *ignoreFile
+
+	// skipFiles are glob patterns matching the encrypted files that are
+	// skipped entirely.
+	skipFiles []string
+
+	// dropKeys match the keys that are omitted entirely from every generated
+	// secret.
+	dropKeys []*regexp.Regexp
+
+	// namespace is an optional namespace that overrides the namespace of
+	// every generated secret.
+	namespace string
+
+	// secretAPIVersion is an optional apiVersion that overrides the apiVersion
+	// of every generated secret.
+	secretAPIVersion string
+
+	// referencePrefixes are the prefixes of values that are references to an
+	// external secret manager, which are preserved instead of being replaced
+	// with a placeholder.
+	referencePrefixes []string
+
+	// maxDocs is the maximum number of yaml documents allowed in a single
+	// encrypted file.
+	maxDocs int
+
+	// readRetries is the number of times that reading an encrypted file is
+	// retried after a transient error.
+	readRetries int
+
+	// maxKeys is the maximum number of keys allowed in a single secret.
+	maxKeys int
+
+	// post is the name of an optional command that the generated manifests
+	// are piped through before being written to stdout.
+	post string
+
+	// output is an optional file that the generated manifests are written to
+	// in place of stdout.
+	output string
+
+	// tee writes the generated manifests to both stdout and the output file.
+	tee bool
+
+	// compress writes the generated manifests gzip compressed.
+	compress bool
+
+	// groupDir is an optional directory that the generated manifests are
+	// written to, as a separate file per namespace, instead of stdout.
+	groupDir string
+
+	// treeDir is an optional directory that the generated secrets are
+	// written to, in a directory for each namespace, rather than to stdout.
+	treeDir string
+
+	// kustomizationDir is an optional directory that the generated manifests
+	// are written to, as a separate file per resource along with a
+	// kustomization.yaml, instead of stdout.
+	kustomizationDir string
+
+	// tolerateTags allows encrypted files to contain custom yaml tags (e.g.
+	// !include) that would otherwise fail to decode.
+	tolerateTags bool
+
+	// hashPreview prints the hash suffixed name that kustomize would give to
+	// every secret.
+	hashPreview bool
+
+	// hashComment adds the hash suffix that kustomize would give to every
+	// secret as a comment in the output.
+	hashComment bool
+
+	// fieldPolicy maps secret types to the field that the values of those
+	// secrets are written to.
+	fieldPolicy map[string]string
+
+	// serverSideSafe writes every value of every generated secret as base64
+	// encoded data, and never as stringData.
+	serverSideSafe bool
+
+	// indentlessSequences writes every sequence that is the value of a
+	// mapping key at the same indentation as that key.
+	indentlessSequences bool
+
+	// normalizeStyle writes every passed through resource in block style,
+	// regardless of its original style.
+	normalizeStyle bool
+
+	// mergeByName merges generated secrets with the same namespace and name
+	// into a single secret.
+	mergeByName bool
+
+	// sort is the order that the generated manifests are written in, or
+	// empty to keep them in the order they were read.
+	sort string
+
+	// helmValues is an optional key path that the generated secrets are
+	// written under as helm values, rather than as resources.
+	helmValues []string
+
+	// kustomizationPatch writes a strategic merge patch for each generated
+	// secret, rather than the secret itself.
+	kustomizationPatch bool
+
+	// preserveKeyOrder writes the keys of every generated secret in their
+	// original order, rather than sorted.
+	preserveKeyOrder bool
+
+	// leadingSeparator writes a --- separator before the first document, as
+	// well as between documents.
+	leadingSeparator bool
+
+	// blankLineSeparator writes a blank line before every --- separator
+	// between documents.
+	blankLineSeparator bool
+
+	// canonical writes the generated manifests in the same form that kubectl
+	// would render them.
+	canonical bool
+
+	// strict escalates certain warnings into fatal errors.
+	strict bool
+
+	// strictEmpty treats a file that contains no secrets as an error.
+	strictEmpty bool
+
+	// strictYAML rejects encrypted files that are indented with tabs.
+	strictYAML bool
+
+	// audit is an optional log of every processed file.
+	audit *auditLog
+
+	// reporter writes, or collects, all non-fatal diagnostic output.
+	*reporter
+}
+
+// loadOptions reads the dry-run options from the environment.
+func loadOptions() (*options, error) {
+	opts := options{
+		reporter:             &reporter{},
+		encryptedPlaceholder: placeholder,
+		strategy:             strategyPlaceholder,
+		labelKey:             defaultLabelKey,
+		labelValue:           "true",
+		httpTimeout:          30 * time.Second,
+		maxDocs:              defaultMaxDocs,
+		maxKeys:              defaultMaxKeys,
+		httpToken:            os.Getenv("KSOPS_DRY_RUN_HTTP_TOKEN"),
+		post:                 os.Getenv("KSOPS_DRY_RUN_POST"),
+		output:               os.Getenv("KSOPS_DRY_RUN_OUTPUT"),
+		groupDir:             os.Getenv("KSOPS_DRY_RUN_GROUP_BY_NAMESPACE"),
+		secretAPIVersion:     os.Getenv("KSOPS_DRY_RUN_SECRET_API_VERSION"),
+		kustomizationDir:     os.Getenv("KSOPS_DRY_RUN_KUSTOMIZATION_DIR"),
+		treeDir:              os.Getenv("KSOPS_DRY_RUN_TREE_DIR"),
+		changedSince:         os.Getenv("KSOPS_DRY_RUN_CHANGED_SINCE"),
+		only:                 os.Getenv("KSOPS_DRY_RUN_ONLY"),
+		filePrefix:           os.Getenv("KSOPS_DRY_RUN_FILE_PREFIX"),
+	}
+
+	// If the KSOPS_DRY_RUN_AUDIT_LOG environment variable is set, then it names
+	// a file to which a content-blind record of every run is appended.
+	if filename := os.Getenv("KSOPS_DRY_RUN_AUDIT_LOG"); filename != "" {
+		opts.audit = &auditLog{filename: filename}
+	}
+
+	// If the KSOPS_DRY_RUN_COMPRESS environment variable exists, then the
+	// generated manifests are gzip compressed, even when written to stdout.
+	_, opts.compress = os.LookupEnv("KSOPS_DRY_RUN_COMPRESS")
+
+	// If the KSOPS_DRY_RUN_TEE environment variable exists, then the
+	// generated manifests are written to stdout as well as the output file.
+	if _, opts.tee = os.LookupEnv("KSOPS_DRY_RUN_TEE"); opts.tee && opts.output == "" {
+		return nil, fmt.Errorf("KSOPS_DRY_RUN_TEE requires KSOPS_DRY_RUN_OUTPUT to be set")
+	}
+
+	// If the KSOPS_DRY_RUN_ENCRYPTED_MARKER environment variable exists, then
+	// its value (or a default marker if empty) is prefixed to the placeholder of
+	// encrypted values, making them visually distinct in a diff from the
+	// plaintext values of a partially encrypted file.
+	if marker, found := os.LookupEnv("KSOPS_DRY_RUN_ENCRYPTED_MARKER"); found {
+		if marker == "" {
+			marker = defaultEncryptedMarker
+		}
+		opts.marker = marker
+	}
+
+	// If the KSOPS_DRY_RUN_PLACEHOLDER environment variable is set, then it
+	// replaces the default placeholder value.
+	if value := os.Getenv("KSOPS_DRY_RUN_PLACEHOLDER"); value != "" {
+		opts.encryptedPlaceholder = value
+	}
+
+	// If the KSOPS_DRY_RUN_PLACEHOLDER_FILE environment variable is set, then
+	// it names a file whose trimmed contents replace the placeholder value,
+	// even one set by KSOPS_DRY_RUN_PLACEHOLDER, keeping the value out of the
+	// environment.
+	if filename := os.Getenv("KSOPS_DRY_RUN_PLACEHOLDER_FILE"); filename != "" {
+		body, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("reading KSOPS_DRY_RUN_PLACEHOLDER_FILE: %w", err)
+		}
+
+		value := strings.TrimSpace(string(body))
+		if value == "" {
+			return nil, fmt.Errorf("expected KSOPS_DRY_RUN_PLACEHOLDER_FILE %s to not be empty", filename)
+		}
+		opts.encryptedPlaceholder = value
+	}
+
+	// If the KSOPS_DRY_RUN_TEMPLATE environment variable is set, then it is
+	// rendered for every key in place of the placeholder.
+	if text := os.Getenv("KSOPS_DRY_RUN_TEMPLATE"); text != "" {
+		template, err := parseTemplate(text)
+		if err != nil {
+			return nil, err
+		}
+		opts.template = template
+	}
+
+	// If the KSOPS_DRY_RUN_LABEL_KEY or KSOPS_DRY_RUN_LABEL_VALUE environment
+	// variables are set, then they replace the default label key and value.
+	// If the KSOPS_DRY_RUN_NO_LABEL environment variable exists, then no
+	// label is added at all.
+	if key := os.Getenv("KSOPS_DRY_RUN_LABEL_KEY"); key != "" {
+		opts.labelKey = key
+	}
+	if value, found := os.LookupEnv("KSOPS_DRY_RUN_LABEL_VALUE"); found {
+		opts.labelValue = value
+	}
+	if _, found := os.LookupEnv("KSOPS_DRY_RUN_NO_LABEL"); found {
+		opts.labelKey = ""
+	}
+
+	// If the KSOPS_DRY_RUN_DROP_KEYS environment variable is set, then its
+	// comma separated value names the keys (as regular expressions matching
+	// the entire key) that are omitted from every generated secret.
+	if patterns := os.Getenv("KSOPS_DRY_RUN_DROP_KEYS"); patterns != "" {
+		for _, pattern := range strings.Split(patterns, ",") {
+			dropKey, err := regexp.Compile("^(?:" + pattern + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("parsing KSOPS_DRY_RUN_DROP_KEYS: %w", err)
+			}
+			opts.dropKeys = append(opts.dropKeys, dropKey)
+		}
+	}
+
+	// If the KSOPS_DRY_RUN_FIELD_POLICY environment variable is set, then its
+	// comma separated type=field pairs decide whether the values of each type
+	// of secret are written to data or stringData.
+	if value := os.Getenv("KSOPS_DRY_RUN_FIELD_POLICY"); value != "" {
+		fieldPolicy, err := parseFieldPolicy(value)
+		if err != nil {
+			return nil, err
+		}
+		opts.fieldPolicy = fieldPolicy
+	}
+
+	// If the KSOPS_DRY_RUN_SKIP_FILES environment variable is set, then its
+	// comma separated value names the files (as glob patterns) that are
+	// skipped entirely.
+	if patterns := os.Getenv("KSOPS_DRY_RUN_SKIP_FILES"); patterns != "" {
+		for _, pattern := range strings.Split(patterns, ",") {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("parsing KSOPS_DRY_RUN_SKIP_FILES: %w", err)
+			}
+			opts.skipFiles = append(opts.skipFiles, pattern)
+		}
+	}
+
+	// If the KSOPS_DRY_RUN_FORCE_NAMESPACE environment variable is set, then it
+	// overrides the namespace of every generated secret.
+	if namespace := os.Getenv("KSOPS_DRY_RUN_FORCE_NAMESPACE"); namespace != "" {
+		if len(namespace) > 63 || !namespacePattern.MatchString(namespace) {
+			return nil, fmt.Errorf("expected KSOPS_DRY_RUN_FORCE_NAMESPACE to be a valid namespace name but got %q", namespace)
+		}
+		opts.namespace = namespace
+	}
+
+	// If the KSOPS_DRY_RUN_CLUSTER environment variable is set, then it is
+	// added as a label to every generated secret.
+	if cluster := os.Getenv("KSOPS_DRY_RUN_CLUSTER"); cluster != "" {
+		if len(cluster) > 63 || !labelValuePattern.MatchString(cluster) {
+			return nil, fmt.Errorf("expected KSOPS_DRY_RUN_CLUSTER to be a valid label value but got %q", cluster)
+		}
+		opts.cluster = cluster
+	}
+
+	// If the KSOPS_DRY_RUN_PRESERVE_REFS environment variable exists, then its
+	// comma separated value (or the default prefixes if empty) names the
+	// prefixes of values that are preserved verbatim.
+	if prefixes, found := os.LookupEnv("KSOPS_DRY_RUN_PRESERVE_REFS"); found {
+		opts.referencePrefixes = defaultReferencePrefixes
+		if prefixes != "" {
+			opts.referencePrefixes = strings.Split(prefixes, ",")
+		}
+	}
+
+	// If the KSOPS_DRY_RUN_STRATEGIES environment variable is set, then it
+	// names a file of per-file placeholder strategies.
+	if filename := os.Getenv("KSOPS_DRY_RUN_STRATEGIES"); filename != "" {
+		strategies, err := loadStrategies(filename)
+		if err != nil {
+			return nil, err
+		}
+		opts.strategies = strategies
+	}
+
+	// If both the KSOPS_DRY_RUN_PASSTHROUGH and
+	// KSOPS_DRY_RUN_PASSTHROUGH_FILES environment variables are set, then the
+	// original values of the latter's comma separated files are written
+	// unchanged. Both are required, so that no values are revealed by
+	// accident.
+	_, passthroughFound := os.LookupEnv("KSOPS_DRY_RUN_PASSTHROUGH")
+	passthroughFiles := os.Getenv("KSOPS_DRY_RUN_PASSTHROUGH_FILES")
+	switch {
+	case passthroughFound && passthroughFiles == "":
+		return nil, fmt.Errorf("KSOPS_DRY_RUN_PASSTHROUGH requires KSOPS_DRY_RUN_PASSTHROUGH_FILES to be set")
+	case !passthroughFound && passthroughFiles != "":
+		return nil, fmt.Errorf("KSOPS_DRY_RUN_PASSTHROUGH_FILES requires KSOPS_DRY_RUN_PASSTHROUGH to be set")
+	case passthroughFound:
+		opts.passthroughFiles = make(map[string]struct{})
+		for _, filename := range strings.Split(passthroughFiles, ",") {
+			if filename = strings.TrimSpace(filename); filename != "" {
+				opts.passthroughFiles[filename] = struct{}{}
+			}
+		}
+	}
+
+	// If the KSOPS_DRY_RUN_ARGOCD environment variable exists, then the Argo CD
+	// sync annotations are added to every generated secret.
+	if _, found := os.LookupEnv("KSOPS_DRY_RUN_ARGOCD"); found {
+		opts.annotations = mergeAnnotations(opts.annotations, argocdAnnotations)
+	}
+
+	// If the KSOPS_DRY_RUN_FLUX environment variable exists, then the Flux
+	// annotations, along with a content hash annotation, are added to every
+	// generated secret.
+	if _, found := os.LookupEnv("KSOPS_DRY_RUN_FLUX"); found {
+		opts.annotations = mergeAnnotations(opts.annotations, fluxAnnotations)
+		opts.annotateHash = true
+	}
+
+	// If the KSOPS_DRY_RUN_ANNOTATE_RECIPIENTS environment variable exists,
+	// then the recipients from the sops metadata are added as an annotation.
+	_, opts.annotateRecipients = os.LookupEnv("KSOPS_DRY_RUN_ANNOTATE_RECIPIENTS")
+
+	// If the KSOPS_DRY_RUN_ANNOTATE_TYPES environment variable exists, then
+	// the shape of each original value is added as an annotation.
+	_, opts.annotateTypes = os.LookupEnv("KSOPS_DRY_RUN_ANNOTATE_TYPES")
+
+	// If the KSOPS_DRY_RUN_ANNOTATE_VERSION environment variable exists, then
+	// the version of ksops-dry-run is added as an annotation.
+	_, opts.annotateVersion = os.LookupEnv("KSOPS_DRY_RUN_ANNOTATE_VERSION")
+
+	// If the KSOPS_DRY_RUN_NONCE environment variable exists, then a random
+	// identifier for this run is added as an annotation.
+	if _, found := os.LookupEnv("KSOPS_DRY_RUN_NONCE"); found {
+		nonce, err := newNonce()
+		if err != nil {
+			return nil, err
+		}
+		opts.nonce = nonce
+	}
+
+	// If the KSOPS_DRY_RUN_ANNOTATE_SOPS environment variable exists, then a
+	// sanitized summary of the sops metadata is added as an annotation.
+	_, opts.annotateSops = os.LookupEnv("KSOPS_DRY_RUN_ANNOTATE_SOPS")
+
+	// If the KSOPS_DRY_RUN_PASSTHROUGH_OTHERS environment variable exists, then
+	// non-secret resources are passed through instead of being rejected.
+	_, opts.passthroughOthers = os.LookupEnv("KSOPS_DRY_RUN_PASSTHROUGH_OTHERS")
+
+	// If the KSOPS_DRY_RUN_SKIP_NON_SECRETS environment variable exists, then
+	// non-secret resources are silently skipped instead of being rejected.
+	if _, opts.skipNonSecrets = os.LookupEnv("KSOPS_DRY_RUN_SKIP_NON_SECRETS"); opts.skipNonSecrets && opts.passthroughOthers {
+		return nil, fmt.Errorf("KSOPS_DRY_RUN_PASSTHROUGH_OTHERS and KSOPS_DRY_RUN_SKIP_NON_SECRETS cannot both be set")
+	}
+
+	// If the KSOPS_DRY_RUN_TOLERATE_TAGS environment variable exists, then
+	// custom yaml tags are treated as opaque values instead of being rejected.
+	_, opts.tolerateTags = os.LookupEnv("KSOPS_DRY_RUN_TOLERATE_TAGS")
+
+	// If the KSOPS_DRY_RUN_HASH_PREVIEW environment variable exists, then a
+	// preview of each secret's hash suffixed name is printed to stderr.
+	_, opts.hashPreview = os.LookupEnv("KSOPS_DRY_RUN_HASH_PREVIEW")
+
+	// If the KSOPS_DRY_RUN_HASH_COMMENT environment variable exists, then each
+	// secret's hash suffix is written as a comment after the secret.
+	_, opts.hashComment = os.LookupEnv("KSOPS_DRY_RUN_HASH_COMMENT")
+
+	// If the KSOPS_DRY_RUN_STRICT environment variable exists, then likely
+	// misconfigurations are treated as errors instead of warnings.
+	_, opts.strict = os.LookupEnv("KSOPS_DRY_RUN_STRICT")
+
+	// If the KSOPS_DRY_RUN_SERVER_SIDE_SAFE environment variable exists, then
+	// only data is written, so that the secrets can be applied server-side.
+	_, opts.serverSideSafe = os.LookupEnv("KSOPS_DRY_RUN_SERVER_SIDE_SAFE")
+
+	// If the KSOPS_DRY_RUN_INDENTLESS_SEQUENCES environment variable exists,
+	// then sequences are not indented relative to their key.
+	_, opts.indentlessSequences = os.LookupEnv("KSOPS_DRY_RUN_INDENTLESS_SEQUENCES")
+
+	// If the KSOPS_DRY_RUN_NORMALIZE_STYLE environment variable exists, then
+	// passed through resources are written in block style.
+	_, opts.normalizeStyle = os.LookupEnv("KSOPS_DRY_RUN_NORMALIZE_STYLE")
+
+	// If the KSOPS_DRY_RUN_HELM_VALUES environment variable is set, then the
+	// secrets are written as helm values under its dot separated key path.
+	if value := os.Getenv("KSOPS_DRY_RUN_HELM_VALUES"); value != "" {
+		opts.helmValues = strings.Split(value, ".")
+		for _, key := range opts.helmValues {
+			if key == "" {
+				return nil, fmt.Errorf("expected KSOPS_DRY_RUN_HELM_VALUES to be a dot separated key path but got %q", value)
+			}
+		}
+	}
+
+	// The generated manifests can only be written to one place, and in one
+	// form.
+	var outputs []string
+	for _, output := range []struct {
+		name string
+		set  bool
+	}{
+		{"KSOPS_DRY_RUN_GROUP_BY_NAMESPACE", opts.groupDir != ""},
+		{"KSOPS_DRY_RUN_KUSTOMIZATION_DIR", opts.kustomizationDir != ""},
+		{"KSOPS_DRY_RUN_TREE_DIR", opts.treeDir != ""},
+		{"KSOPS_DRY_RUN_HELM_VALUES", opts.helmValues != nil},
+	} {
+		if output.set {
+			outputs = append(outputs, output.name)
+		}
+	}
+	if len(outputs) > 1 {
+		return nil, fmt.Errorf("only one of %s can be set", strings.Join(outputs, ", "))
+	}
+
+	// If the KSOPS_DRY_RUN_MERGE_BY_NAME environment variable exists, then
+	// secrets with the same namespace and name are merged.
+	_, opts.mergeByName = os.LookupEnv("KSOPS_DRY_RUN_MERGE_BY_NAME")
+
+	// If the KSOPS_DRY_RUN_SORT environment variable is set, then the
+	// generated manifests are sorted in the given order.
+	switch opts.sort = os.Getenv("KSOPS_DRY_RUN_SORT"); opts.sort {
+	case "", sortKubectl:
+	default:
+		return nil, fmt.Errorf("expected KSOPS_DRY_RUN_SORT to be %q but got %q", sortKubectl, opts.sort)
+	}
+
+	// If the KSOPS_DRY_RUN_OUTPUT_KUSTOMIZATION_PATCH environment variable
+	// exists, then patches are written in place of secrets.
+	_, opts.kustomizationPatch = os.LookupEnv("KSOPS_DRY_RUN_OUTPUT_KUSTOMIZATION_PATCH")
+
+	// If the KSOPS_DRY_RUN_PRESERVE_KEY_ORDER environment variable exists,
+	// then keys are written in their original order.
+	_, opts.preserveKeyOrder = os.LookupEnv("KSOPS_DRY_RUN_PRESERVE_KEY_ORDER")
+
+	// If the KSOPS_DRY_RUN_CANONICAL environment variable exists, then the
+	// generated manifests are written in canonical form.
+	_, opts.canonical = os.LookupEnv("KSOPS_DRY_RUN_CANONICAL")
+
+	// If the KSOPS_DRY_RUN_LEADING_SEPARATOR environment variable exists, then
+	// a --- separator is also written before the first document. The encoder
+	// only emits separators between documents, which some downstream parsers
+	// do not accept.
+	_, opts.leadingSeparator = os.LookupEnv("KSOPS_DRY_RUN_LEADING_SEPARATOR")
+
+	// If the KSOPS_DRY_RUN_BLANK_LINE_SEPARATOR environment variable exists,
+	// then a blank line is written before every --- separator between
+	// documents, which some downstream parsers require.
+	_, opts.blankLineSeparator = os.LookupEnv("KSOPS_DRY_RUN_BLANK_LINE_SEPARATOR")
+
+	// If the KSOPS_DRY_RUN_STRICT_EMPTY environment variable exists, then a
+	// file that contains no secrets is treated as an error.
+	_, opts.strictEmpty = os.LookupEnv("KSOPS_DRY_RUN_STRICT_EMPTY")
+
+	// If the KSOPS_DRY_RUN_STRICT_YAML environment variable exists, then an
+	// encrypted file indented with tabs is an error.
+	_, opts.strictYAML = os.LookupEnv("KSOPS_DRY_RUN_STRICT_YAML")
+
+	// If the KSOPS_DRY_RUN_QUIET environment variable exists, then warnings
+	// are no longer written to stderr. Fatal errors are always written.
+	_, opts.quiet = os.LookupEnv("KSOPS_DRY_RUN_QUIET")
+
+	// If the KSOPS_DRY_RUN_DEBUG environment variable exists, then debug
+	// messages, such as which files were skipped, are written to stderr.
+	_, opts.debug = os.LookupEnv("KSOPS_DRY_RUN_DEBUG")
+
+	// If the KSOPS_DRY_RUN_WARNINGS_AS_ERRORS environment variable exists,
+	// then every warning is collected and reported as a fatal error.
+	_, opts.warningsAsErrors = os.LookupEnv("KSOPS_DRY_RUN_WARNINGS_AS_ERRORS")
+
+	// If the KSOPS_DRY_RUN_LOG_FORMAT environment variable is set, then it
+	// selects the format of diagnostic output.
+	format, err := loadLogFormat()
+	if err != nil {
+		return nil, err
+	}
+	opts.format = format
+
+	// If the KSOPS_DRY_RUN_HTTP_TIMEOUT environment variable is set, then it
+	// overrides the default timeout for fetching encrypted files over http.
+	if value := os.Getenv("KSOPS_DRY_RUN_HTTP_TIMEOUT"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("parsing KSOPS_DRY_RUN_HTTP_TIMEOUT: %w", err)
+		}
+		opts.httpTimeout = timeout
+	}
+
+	// If the KSOPS_DRY_RUN_MAX_AGE environment variable is set, then any
+	// secret last modified longer ago than it is warned about. A number of
+	// days (e.g. 90d) is accepted along with any duration.
+	if value := os.Getenv("KSOPS_DRY_RUN_MAX_AGE"); value != "" {
+		maxAge, err := parseAge(value)
+		if err != nil || maxAge <= 0 {
+			return nil, fmt.Errorf("expected KSOPS_DRY_RUN_MAX_AGE to be a positive duration but got %q", value)
+		}
+		opts.maxAge = maxAge
+	}
+
+	// If the KSOPS_DRY_RUN_STDIN_TIMEOUT environment variable is set, then
+	// it bounds the time to read a resource list from stdin.
+	if value := os.Getenv("KSOPS_DRY_RUN_STDIN_TIMEOUT"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("parsing KSOPS_DRY_RUN_STDIN_TIMEOUT: %w", err)
+		}
+		opts.stdinTimeout = timeout
+	}
+
+	// If the KSOPS_DRY_RUN_MAX_DOCS environment variable is set, then it
+	// overrides the default maximum number of documents per file.
+	if value := os.Getenv("KSOPS_DRY_RUN_MAX_DOCS"); value != "" {
+		maxDocs, err := strconv.Atoi(value)
+		if err != nil || maxDocs < 1 {
+			return nil, fmt.Errorf("expected KSOPS_DRY_RUN_MAX_DOCS to be a positive integer but got %q", value)
+		}
+		opts.maxDocs = maxDocs
+	}
+
+	// If the KSOPS_DRY_RUN_READ_RETRIES environment variable is set, then it
+	// is the number of times that a transient read error is retried.
+	if value := os.Getenv("KSOPS_DRY_RUN_READ_RETRIES"); value != "" {
+		readRetries, err := strconv.Atoi(value)
+		if err != nil || readRetries < 0 {
+			return nil, fmt.Errorf("expected KSOPS_DRY_RUN_READ_RETRIES to be a non-negative integer but got %q", value)
+		}
+		opts.readRetries = readRetries
+	}
+
+	// If the KSOPS_DRY_RUN_SEARCH_PARENTS environment variable is set, then it
+	// is the number of parent directories searched for missing files.
+	if value := os.Getenv("KSOPS_DRY_RUN_SEARCH_PARENTS"); value != "" {
+		searchParents, err := strconv.Atoi(value)
+		if err != nil || searchParents < 0 {
+			return nil, fmt.Errorf("expected KSOPS_DRY_RUN_SEARCH_PARENTS to be a non-negative integer but got %q", value)
+		}
+		opts.searchParents = searchParents
+	}
+
+	// If the KSOPS_DRY_RUN_MAX_KEYS environment variable is set, then it
+	// overrides the default maximum number of keys per secret.
+	if value := os.Getenv("KSOPS_DRY_RUN_MAX_KEYS"); value != "" {
+		maxKeys, err := strconv.Atoi(value)
+		if err != nil || maxKeys < 1 {
+			return nil, fmt.Errorf("expected KSOPS_DRY_RUN_MAX_KEYS to be a positive integer but got %q", value)
+		}
+		opts.maxKeys = maxKeys
+	}
+
+	return &opts, nil
+}
+
+// isReference returns true if the given value is a reference to an external
+// secret manager, such as vault:secret/data/foo#bar, rather than a secret.
+func (o *options) isReference(value string) bool {
+	for _, prefix := range o.referencePrefixes {
+		if prefix != "" && strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isSkipped returns true if the given encrypted file should be skipped.
+func (o *options) isSkipped(filename string) bool {
+	for _, pattern := range o.skipFiles {
+		if matched, _ := filepath.Match(pattern, filename); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isDropped returns true if the given key is omitted from generated secrets.
+func (o *options) isDropped(key string) bool {
+	for _, dropKey := range o.dropKeys {
+		if dropKey.MatchString(key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseFlags applies any command-line flags on top of the options loaded from
+// the environment, so that flags take precedence. Flags may come before or
+// after any other arguments, as kustomize passes the path of the generator
+// config first, followed by any configured arguments. The other arguments are
+// returned in order, and every argument after a -- terminator is returned
+// unmodified.
+func (o *options) parseFlags(args []string) ([]string, error) {
+	flags := flag.NewFlagSet("ksops-dry-run", flag.ContinueOnError)
+	value := flags.String("placeholder", "", "value used in place of every encrypted value")
+	flags.StringVar(&o.labelKey, "label-key", o.labelKey, "key of the label added to every generated secret")
+	flags.StringVar(&o.labelValue, "label-value", o.labelValue, "value of the label added to every generated secret")
+	noLabel := flags.Bool("no-label", false, "do not add a label to generated secrets")
+	flags.BoolVar(&o.canonical, "canonical", o.canonical, "write generated manifests in the same form as kubectl")
+	flags.BoolVar(&o.normalizeStyle, "normalize-style", o.normalizeStyle, "write passed through resources in block style")
+	flags.BoolVar(&o.kustomizationPatch, "output-kustomization-patch", o.kustomizationPatch, "write a strategic merge patch for each secret instead of the secret")
+	flags.BoolVar(&o.serverSideSafe, "server-side-safe", o.serverSideSafe, "write every value as base64 encoded data, and never as stringData")
+
+	// The flag package stops parsing at the first non-flag argument, so parse
+	// again from the argument after each one.
+	var remaining []string
+	for len(args) > 0 {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+
+		parsed := args[:len(args)-flags.NArg()]
+		if len(parsed) > 0 && parsed[len(parsed)-1] == "--" {
+			remaining = append(remaining, flags.Args()...)
+
+			break
+		}
+
+		args = flags.Args()
+		if len(args) > 0 {
+			remaining = append(remaining, args[0])
+			args = args[1:]
+		}
+	}
+
+	if *value != "" {
+		o.encryptedPlaceholder = *value
+		o.template = nil
+	}
+	if *noLabel {
+		o.labelKey = ""
+	}
+
+	return remaining, nil
+}
+
+// parseAge parses the given duration, which may also be a whole number of
+// days (e.g. 90d).
+func parseAge(value string) (time.Duration, error) {
+	if days, found := strings.CutSuffix(value, "d"); found {
+		count, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+
+		return time.Duration(count) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+// mergeAnnotations returns a copy of the given annotations with the other
+// annotations added.
+func mergeAnnotations(annotations, other map[string]string) map[string]string {
+	merged := make(map[string]string, len(annotations)+len(other))
+	for key, value := range annotations {
+		merged[key] = value
+	}
+	for key, value := range other {
+		merged[key] = value
+	}
+
+	return merged
+}
+
+// newNonce returns a random (version 4) uuid.
+func newNonce() (string, error) {
+	var uuid [16]byte
+	if _, err := rand.Read(uuid[:]); err != nil {
+		return "", err
+	}
+	uuid[6] = uuid[6]&0x0f | 0x40
+	uuid[8] = uuid[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16]), nil
+}
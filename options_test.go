@@ -0,0 +1,416 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestPlaceholderFile(t *testing.T) {
+	body, err := os.ReadFile("testdata/invalid/valid.enc.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "trimmed",
+			file: "  from-file\n\n",
+			want: "password: from-file\n",
+		},
+		{
+			name: "takes precedence over the environment",
+			file: "from-file\n",
+			env:  map[string]string{"KSOPS_DRY_RUN_PLACEHOLDER": "from-env"},
+			want: "password: from-file\n",
+		},
+		{
+			name:    "empty",
+			file:    " \n",
+			wantErr: "to not be empty",
+		},
+		{
+			name:    "missing",
+			wantErr: "reading KSOPS_DRY_RUN_PLACEHOLDER_FILE: open ",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if test.file != "" {
+				writeFiles(t, dir, map[string]string{"placeholder": test.file})
+			}
+
+			t.Setenv("KSOPS_DRY_RUN_PLACEHOLDER_FILE", filepath.Join(dir, "placeholder"))
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+
+			opts, err := loadOptions()
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected error %q but got %v", test.wantErr, err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			output, err := stubString(t, string(body), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(output, test.want) {
+				t.Errorf("expected output to contain %q but got:\n%s", test.want, output)
+			}
+		})
+	}
+}
+
+func TestArgoCDAnnotations(t *testing.T) {
+	content := `apiVersion: v1
+kind: Secret
+metadata:
+  name: database
+  namespace: prod
+  annotations:
+    argocd.argoproj.io/sync-options: Replace=true
+    team: platform
+stringData:
+  password: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]
+`
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		want map[string]string
+	}{
+		{
+			name: "not applied by default",
+			want: map[string]string{
+				"argocd.argoproj.io/sync-options": "Replace=true",
+				"team":                            "platform",
+			},
+		},
+		{
+			name: "applied without clobbering",
+			env:  map[string]string{"KSOPS_DRY_RUN_ARGOCD": ""},
+			want: map[string]string{
+				"argocd.argoproj.io/compare-options": "IgnoreExtraneous",
+				"argocd.argoproj.io/sync-options":    "Replace=true",
+				"team":                               "platform",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := stubString(t, content, testOptions(t, test.env))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var secret secret
+			if err := yaml.Unmarshal([]byte(output), &secret); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(secret.Metadata.Annotations) != len(test.want) {
+				t.Errorf("expected annotations %v but got %v", test.want, secret.Metadata.Annotations)
+			}
+			for key, want := range test.want {
+				if got := secret.Metadata.Annotations[key]; got != want {
+					t.Errorf("expected annotation %q to be %q but got %q", key, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		wantArgs    []string
+		placeholder string
+		labelKey    string
+		wantErr     bool
+	}{
+		{
+			name:        "no flags",
+			args:        []string{"/tmp/kust-plugin-config-123"},
+			wantArgs:    []string{"/tmp/kust-plugin-config-123"},
+			placeholder: "FROM_ENV",
+			labelKey:    "from-env",
+		},
+		{
+			name:        "flags before the config path",
+			args:        []string{"--placeholder=FLAGGED", "--no-label", "/tmp/kust-plugin-config-123"},
+			wantArgs:    []string{"/tmp/kust-plugin-config-123"},
+			placeholder: "FLAGGED",
+		},
+		{
+			name:        "flags after the config path",
+			args:        []string{"/tmp/kust-plugin-config-123", "--placeholder=FLAGGED", "--no-label"},
+			wantArgs:    []string{"/tmp/kust-plugin-config-123"},
+			placeholder: "FLAGGED",
+		},
+		{
+			name:        "flags between arguments",
+			args:        []string{"/tmp/kust-plugin-config-123", "--label-key", "from-flag", "extra", "--placeholder=FLAGGED"},
+			wantArgs:    []string{"/tmp/kust-plugin-config-123", "extra"},
+			placeholder: "FLAGGED",
+			labelKey:    "from-flag",
+		},
+		{
+			name:        "after a terminator",
+			args:        []string{"/tmp/kust-plugin-config-123", "--", "--placeholder=FLAGGED"},
+			wantArgs:    []string{"/tmp/kust-plugin-config-123", "--placeholder=FLAGGED"},
+			placeholder: "FROM_ENV",
+			labelKey:    "from-env",
+		},
+		{
+			name:    "unknown flag after the config path",
+			args:    []string{"/tmp/kust-plugin-config-123", "--unknown"},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := testOptions(t, map[string]string{
+				"KSOPS_DRY_RUN_PLACEHOLDER": "FROM_ENV",
+				"KSOPS_DRY_RUN_LABEL_KEY":   "from-env",
+			})
+
+			var args []string
+			var err error
+			captureStderr(t, func() {
+				args, err = opts.parseFlags(test.args)
+			})
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := strings.Join(args, " "), strings.Join(test.wantArgs, " "); got != want {
+				t.Errorf("expected arguments %q but got %q", want, got)
+			}
+			if opts.encryptedPlaceholder != test.placeholder {
+				t.Errorf("expected placeholder %q but got %q", test.placeholder, opts.encryptedPlaceholder)
+			}
+			if opts.labelKey != test.labelKey {
+				t.Errorf("expected label key %q but got %q", test.labelKey, opts.labelKey)
+			}
+		})
+	}
+}
+
+func TestFlagsOverEnv(t *testing.T) {
+	env := pluginEnv("testdata/policy", "compliant.enc.yaml")
+	env["KSOPS_DRY_RUN_PLACEHOLDER"] = "FROM_ENV"
+	env["KSOPS_DRY_RUN_LABEL_KEY"] = "from-env"
+
+	// Kustomize passes the path of a temporary copy of the generator config
+	// first, followed by any configured arguments.
+	output, err := runMain(t, []string{filepath.Join(t.TempDir(), "kust-plugin-config"), "--placeholder=FLAGGED", "--no-label"}, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stubbed secret
+	if err := yaml.Unmarshal([]byte(output), &stubbed); err != nil {
+		t.Fatal(err)
+	}
+
+	if value := stubbed.StringData["password"]; value != "FLAGGED" {
+		t.Errorf("expected the flag placeholder but got %q", value)
+	}
+	if len(stubbed.Metadata.Labels) != 0 {
+		t.Errorf("expected no labels but got %v", stubbed.Metadata.Labels)
+	}
+}
+
+func TestFluxAnnotations(t *testing.T) {
+	content := `apiVersion: v1
+kind: Secret
+metadata:
+  name: database
+  namespace: prod
+  annotations:
+    kustomize.toolkit.fluxcd.io/ssa: IfNotPresent
+    team: platform
+stringData:
+  password: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: cache
+  namespace: prod
+stringData:
+  password: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]
+`
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		want map[string]map[string]string
+	}{
+		{
+			name: "not applied by default",
+			want: map[string]map[string]string{
+				"database": {
+					"kustomize.toolkit.fluxcd.io/ssa": "IfNotPresent",
+					"team":                            "platform",
+				},
+				"cache": {},
+			},
+		},
+		{
+			name: "applied without clobbering",
+			env:  map[string]string{"KSOPS_DRY_RUN_FLUX": ""},
+			want: map[string]map[string]string{
+				"database": {
+					"kustomize.toolkit.fluxcd.io/ssa":      "IfNotPresent",
+					"ksops-dry-run.joshdk.github.com/hash": "",
+					"team":                                 "platform",
+				},
+				"cache": {
+					"kustomize.toolkit.fluxcd.io/ssa":      "merge",
+					"ksops-dry-run.joshdk.github.com/hash": "",
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := stubString(t, content, testOptions(t, test.env))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoder := yaml.NewDecoder(strings.NewReader(output))
+			for range test.want {
+				var secret secret
+				if err := decoder.Decode(&secret); err != nil {
+					t.Fatal(err)
+				}
+
+				want := test.want[secret.Metadata.Name]
+				if len(secret.Metadata.Annotations) != len(want) {
+					t.Errorf("expected annotations %v but got %v", want, secret.Metadata.Annotations)
+				}
+
+				// The hash depends on the content, so only its form is checked.
+				for key, value := range want {
+					got, found := secret.Metadata.Annotations[key]
+					switch {
+					case key == "ksops-dry-run.joshdk.github.com/hash":
+						if !hashPattern.MatchString(got) {
+							t.Errorf("expected annotation %q to be a kustomize style hash but got %q", key, got)
+						}
+					case !found || got != value:
+						t.Errorf("expected annotation %q to be %q but got %q", key, value, got)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestClusterLabel(t *testing.T) {
+	content := `apiVersion: v1
+kind: Secret
+metadata:
+  name: database
+  namespace: prod
+stringData:
+  password: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]
+`
+
+	tests := []struct {
+		name    string
+		cluster string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "unset",
+		},
+		{
+			name:    "cluster name",
+			cluster: "us-east-1.prod",
+			want:    "us-east-1.prod",
+		},
+		{
+			name:    "invalid characters",
+			cluster: "us east/1",
+			wantErr: `expected KSOPS_DRY_RUN_CLUSTER to be a valid label value but got "us east/1"`,
+		},
+		{
+			name:    "invalid trailing character",
+			cluster: "prod-",
+			wantErr: `expected KSOPS_DRY_RUN_CLUSTER to be a valid label value but got "prod-"`,
+		},
+		{
+			name:    "too long",
+			cluster: strings.Repeat("a", 64),
+			wantErr: "expected KSOPS_DRY_RUN_CLUSTER to be a valid label value",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("KSOPS_DRY_RUN_CLUSTER", test.cluster)
+
+			opts, err := loadOptions()
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected error %q but got %v", test.wantErr, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			output, err := stubString(t, content, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var stubbed secret
+			if err := yaml.Unmarshal([]byte(output), &stubbed); err != nil {
+				t.Fatal(err)
+			}
+
+			got, found := stubbed.Metadata.Labels["ksops-dry-run.joshdk.github.com/cluster"]
+			if found != (test.want != "") || got != test.want {
+				t.Errorf("expected cluster label %q but got %q (present: %t)", test.want, got, found)
+			}
+		})
+	}
+}
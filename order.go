@@ -0,0 +1,64 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// keysOf returns the keys of the given mapping fields of the given resource,
+// in their original order.
+func keysOf(resource *yaml.Node, fields ...string) []string {
+	var keys []string
+	for _, field := range fields {
+		values := mappingValue(resource, field)
+		if values == nil || values.Kind != yaml.MappingNode {
+			continue
+		}
+
+		for i := 0; i+1 < len(values.Content); i += 2 {
+			keys = append(keys, values.Content[i].Value)
+		}
+	}
+
+	return keys
+}
+
+// orderKeys reorders the key/value pairs of the given mapping node so that
+// keys appear in the given order. Any keys not in the given order are kept
+// after those that are, in their existing order.
+func orderKeys(mapping *yaml.Node, order []string) {
+	position := make(map[string]int, len(order))
+	for i, key := range order {
+		if _, found := position[key]; !found {
+			position[key] = i
+		}
+	}
+
+	rank := func(key string) int {
+		if i, found := position[key]; found {
+			return i
+		}
+
+		return len(order)
+	}
+
+	pairs := make([][2]*yaml.Node, 0, len(mapping.Content)/2)
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		pairs = append(pairs, [2]*yaml.Node{mapping.Content[i], mapping.Content[i+1]})
+	}
+
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return rank(pairs[i][0].Value) < rank(pairs[j][0].Value)
+	})
+
+	mapping.Content = mapping.Content[:0]
+	for _, pair := range pairs {
+		mapping.Content = append(mapping.Content, pair[0], pair[1])
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+// patchDocuments returns a strategic merge patch for each of the stubbed
+// secrets in the given documents, which targets the secret by its kind, name,
+// and namespace, and replaces its values with placeholders. Any other
+// resources are excluded, as there is nothing in them to patch.
+func patchDocuments(documents []document) []document {
+	var patches []document
+	for _, document := range documents {
+		if document.secret == nil {
+			continue
+		}
+
+		patch := &secret{
+			common: common{
+				APIVersion: document.secret.APIVersion,
+				Kind:       document.secret.Kind,
+				Metadata: metadata{
+					Name:      document.secret.Metadata.Name,
+					Namespace: document.secret.Metadata.Namespace,
+				},
+			},
+			StringData: document.secret.StringData,
+			Data:       document.secret.Data,
+			keyOrder:   document.secret.keyOrder,
+		}
+
+		patches = append(patches, document)
+		patches[len(patches)-1].secret = patch
+	}
+
+	return patches
+}
@@ -0,0 +1,141 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// policy represents a set of compliance rules that every encrypted secret
+// must satisfy.
+type policy struct {
+	// RequiredRecipients is a list of keys that every encrypted secret must
+	// have been encrypted to.
+	RequiredRecipients []string `yaml:"requiredRecipients"`
+
+	// RequiredKeys maps the names of secrets, either as namespace/name or
+	// just name to match a secret in any namespace, to a list of keys that
+	// the secret must contain.
+	RequiredKeys map[string][]string `yaml:"requiredKeys"`
+}
+
+// loadPolicy reads and parses the policy file with the given name.
+func loadPolicy(filename string) (*policy, error) {
+	body, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy policy
+	if err := yaml.Unmarshal(body, &policy); err != nil {
+		return nil, fmt.Errorf("parsing policy %s: %w", filename, err)
+	}
+
+	return &policy, nil
+}
+
+// checkAll checks every given secret against the policy, and returns the
+// combined errors for all non-compliant secrets. A nil policy allows every
+// secret.
+func (p *policy) checkAll(secrets []secret) error {
+	if p == nil {
+		return nil
+	}
+
+	// Violations are collected so that every non-compliant secret can be
+	// reported together, rather than only the first.
+	var violations []error
+	for _, secret := range secrets {
+		if err := p.check(secret.source, secret); err != nil {
+			violations = append(violations, err)
+		}
+	}
+
+	return errors.Join(violations...)
+}
+
+// check returns an error if the given secret (read from the given filename)
+// does not satisfy the policy. The secret is checked as it was parsed, as keys
+// may since have been dropped, or its namespace overridden. A merged secret is
+// checked as every secret it was merged from, each of which must have been
+// encrypted to the required recipients, while the required keys may be spread
+// across them.
+func (p *policy) check(filename string, secret secret) error {
+	parsed := secret.parsed
+	if len(parsed) == 0 {
+		parsed = append(parsed, &secret)
+	}
+
+	var violations []error
+	for _, original := range parsed {
+		if missing := p.missingRecipients(*original); len(missing) > 0 {
+			violations = append(violations, &fileError{file: original.source, err: fmt.Errorf("secret %q is missing required recipients %s", original.displayName(), strings.Join(missing, ", "))})
+		}
+	}
+	if missing := p.missingKeys(parsed); len(missing) > 0 {
+		violations = append(violations, &fileError{file: filename, err: fmt.Errorf("secret %q is missing required keys %s", parsed[0].displayName(), strings.Join(missing, ", "))})
+	}
+
+	return errors.Join(violations...)
+}
+
+// missingRecipients returns the required recipients that the given secret was
+// not encrypted to.
+func (p *policy) missingRecipients(secret secret) []string {
+	recipients := make(map[string]struct{})
+	for _, recipient := range secret.sops.recipients() {
+		recipients[recipient] = struct{}{}
+	}
+
+	var missing []string
+	for _, recipient := range p.RequiredRecipients {
+		if _, found := recipients[recipient]; !found {
+			missing = append(missing, recipient)
+		}
+	}
+
+	return missing
+}
+
+// missingKeys returns the required keys for the given secrets, by either
+// their qualified or unqualified names, that none of them contain.
+func (p *policy) missingKeys(secrets []*secret) []string {
+	// The lists are never appended to one another, as that could write into
+	// the backing array of the policy itself.
+	var lists [][]string
+	keys := make(map[string]struct{})
+	for _, secret := range secrets {
+		lists = append(lists, p.RequiredKeys[secret.Metadata.Name])
+		if secret.Metadata.Namespace != "" {
+			lists = append(lists, p.RequiredKeys[secret.Metadata.Namespace+"/"+secret.Metadata.Name])
+		}
+		for _, key := range keysOfSecret(secret) {
+			keys[key] = struct{}{}
+		}
+	}
+
+	var missing []string
+	seen := make(map[string]struct{})
+	for _, required := range lists {
+		for _, key := range required {
+			if _, found := seen[key]; found {
+				continue
+			}
+			seen[key] = struct{}{}
+
+			if _, found := keys[key]; !found {
+				missing = append(missing, key)
+			}
+		}
+	}
+
+	return missing
+}
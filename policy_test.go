@@ -0,0 +1,229 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPolicyRecipients(t *testing.T) {
+	tests := []struct {
+		name       string
+		files      []string
+		wantErrs   []string
+		wantOutput string
+	}{
+		{
+			name:       "compliant",
+			files:      []string{"compliant.enc.yaml"},
+			wantOutput: "name: database\n",
+		},
+		{
+			name:  "every non-compliant file is reported",
+			files: []string{"compliant.enc.yaml", "wrong-recipient.enc.yaml", "pgp-only.enc.yaml"},
+			wantErrs: []string{
+				`wrong-recipient.enc.yaml: secret "prod/cache" is missing required recipients`,
+				`pgp-only.enc.yaml: secret "prod/queue" is missing required recipients`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := pluginEnv("testdata/policy", test.files...)
+			env["KSOPS_DRY_RUN_POLICY"] = "testdata/policy/policy.yaml"
+
+			output, err := runMain(t, []string{"generator.yaml"}, env)
+			if len(test.wantErrs) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.Contains(output, test.wantOutput) {
+					t.Errorf("expected output to contain %q but got:\n%s", test.wantOutput, output)
+				}
+
+				return
+			}
+
+			errs := flattenErrors(err)
+			if len(errs) != len(test.wantErrs) {
+				t.Fatalf("expected %d errors but got %v", len(test.wantErrs), err)
+			}
+			for i, want := range test.wantErrs {
+				if !strings.Contains(errs[i].Error(), want) {
+					t.Errorf("expected error %q but got %q", want, errs[i])
+				}
+			}
+
+			// Nothing is written for a non-compliant secret, not even for the
+			// compliant ones.
+			if output != "" {
+				t.Errorf("expected no output but got:\n%s", output)
+			}
+		})
+	}
+}
+
+func TestPolicyKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   string
+		env      map[string]string
+		wantErrs []string
+	}{
+		{
+			name:   "compliant",
+			policy: "requiredKeys:\n  database: [password]\n  prod/database: [password]\n",
+		},
+		{
+			name:     "missing key",
+			policy:   "requiredKeys:\n  database: [password]\n  prod/database: [password, username]\n",
+			wantErrs: []string{`compliant.enc.yaml: secret "prod/database" is missing required keys username`},
+		},
+		{
+			name:   "dropped keys are not missing",
+			policy: "requiredKeys:\n  prod/database: [password]\n",
+			env:    map[string]string{"KSOPS_DRY_RUN_DROP_KEYS": "password"},
+		},
+		{
+			name:     "original namespace is checked",
+			policy:   "requiredKeys:\n  prod/database: [username]\n  staging/database: [password]\n",
+			env:      map[string]string{"KSOPS_DRY_RUN_FORCE_NAMESPACE": "staging"},
+			wantErrs: []string{`compliant.enc.yaml: secret "prod/database" is missing required keys username`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"policy.yaml": test.policy})
+
+			env := pluginEnv("testdata/policy", "compliant.enc.yaml")
+			env["KSOPS_DRY_RUN_POLICY"] = filepath.Join(dir, "policy.yaml")
+			for name, value := range test.env {
+				env[name] = value
+			}
+
+			output, err := runMain(t, []string{"generator.yaml"}, env)
+			if len(test.wantErrs) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.Contains(output, "name: database\n") {
+					t.Errorf("expected output to contain the secret but got:\n%s", output)
+				}
+
+				return
+			}
+
+			errs := flattenErrors(err)
+			if len(errs) != len(test.wantErrs) {
+				t.Fatalf("expected %d errors but got %v", len(test.wantErrs), err)
+			}
+			for i, want := range test.wantErrs {
+				if !strings.Contains(errs[i].Error(), want) {
+					t.Errorf("expected error %q but got %q", want, errs[i])
+				}
+			}
+			if output != "" {
+				t.Errorf("expected no output but got:\n%s", output)
+			}
+		})
+	}
+}
+
+func TestPolicyKeysUnchanged(t *testing.T) {
+	// The unqualified list has spare capacity, which the qualified list must
+	// never be appended into.
+	unqualified := make([]string, 1, 4)
+	unqualified[0] = "password"
+	policy := &policy{RequiredKeys: map[string][]string{
+		"database":         unqualified,
+		"prod/database":    {"username"},
+		"staging/database": {"token"},
+	}}
+
+	for _, namespace := range []string{"prod", "staging"} {
+		database := &secret{}
+		database.Metadata.Name = "database"
+		database.Metadata.Namespace = namespace
+		policy.missingKeys([]*secret{database})
+	}
+
+	if extra := unqualified[1:cap(unqualified)]; extra[0] != "" || extra[1] != "" || extra[2] != "" {
+		t.Errorf("expected the policy to be unchanged but got %q", extra)
+	}
+}
+
+func TestPolicyMerged(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    []string
+		wantErrs []string
+	}{
+		{
+			name:  "compliant files",
+			files: []string{"compliant.enc.yaml", "database-username.enc.yaml"},
+		},
+		{
+			name:     "compliant and non-compliant files",
+			files:    []string{"compliant.enc.yaml", "database-wrong-recipient.enc.yaml"},
+			wantErrs: []string{`database-wrong-recipient.enc.yaml: secret "prod/database" is missing required recipients`},
+		},
+		{
+			name:     "missing key from every file",
+			files:    []string{"compliant.enc.yaml", "compliant.enc.yaml"},
+			wantErrs: []string{`compliant.enc.yaml: secret "prod/database" is missing required keys username`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"policy.yaml": "requiredRecipients:\n  - age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p\nrequiredKeys:\n  prod/database: [password, username]\n",
+			})
+
+			env := pluginEnv("testdata/policy", test.files...)
+			env["KSOPS_DRY_RUN_MERGE_BY_NAME"] = ""
+			env["KSOPS_DRY_RUN_POLICY"] = filepath.Join(dir, "policy.yaml")
+
+			output, err := runMain(t, []string{"generator.yaml"}, env)
+			if len(test.wantErrs) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// The files are merged into a single secret with every key.
+				if count := strings.Count(output, "name: database\n"); count != 1 {
+					t.Errorf("expected a single secret but got %d:\n%s", count, output)
+				}
+				for _, key := range []string{"password:", "username:"} {
+					if !strings.Contains(output, key) {
+						t.Errorf("expected output to contain %q but got:\n%s", key, output)
+					}
+				}
+
+				return
+			}
+
+			errs := flattenErrors(err)
+			if len(errs) != len(test.wantErrs) {
+				t.Fatalf("expected %d errors but got %v", len(test.wantErrs), err)
+			}
+			for i, want := range test.wantErrs {
+				if !strings.Contains(errs[i].Error(), want) {
+					t.Errorf("expected error %q but got %q", want, errs[i])
+				}
+			}
+			if output != "" {
+				t.Errorf("expected no output but got:\n%s", output)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// writeOutput writes the generated manifests to the output stream using the
+// given function. The stream is always closed, even if writing failed, so that
+// any post-processing command is waited on. An error from that command takes
+// precedence, as a failed write is likely the result of it exiting early.
+func writeOutput(opts *options, write func(io.Writer) error) (err error) {
+	output, err := openOutput(opts)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		closeErr := output.Close()
+
+		var exitErr *exec.ExitError
+		if err == nil || errors.As(closeErr, &exitErr) {
+			err = closeErr
+		}
+	}()
+
+	return write(output)
+}
+
+// openOutput returns the stream to which the generated manifests are written.
+// If a post-processing command is configured, then the stream is piped
+// through that command on its way to stdout, or the output file. The returned
+// stream must always be closed, as that is when any post-processing command is
+// waited on, and any output file is closed.
+func openOutput(opts *options) (io.WriteCloser, error) {
+	sink, err := openSink(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.post == "" {
+		return sink, nil
+	}
+
+	cmd := exec.Command(opts.post)
+	cmd.Stdout = sink
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		sink.Close()
+
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		sink.Close()
+
+		return nil, fmt.Errorf("starting post-processing command: %w", err)
+	}
+
+	return &postWriteCloser{WriteCloser: stdin, cmd: cmd, sink: sink}, nil
+}
+
+// openSink returns the final destination of the generated manifests, which is
+// stdout, the output file, or both when teeing. The output file is compressed
+// if its name ends with .gz, and stdout only if explicitly configured.
+func openSink(opts *options) (io.WriteCloser, error) {
+	var sink sinkWriteCloser
+	var writers []io.Writer
+
+	if opts.output == "" || opts.tee {
+		var stdout io.Writer = os.Stdout
+		if opts.compress {
+			compressed := gzip.NewWriter(os.Stdout)
+			sink.closers = append(sink.closers, compressed)
+			stdout = compressed
+		}
+		writers = append(writers, stdout)
+	}
+
+	if opts.output != "" {
+		file, err := os.Create(opts.output)
+		if err != nil {
+			return nil, err
+		}
+
+		var output io.Writer = file
+		if opts.compress || strings.HasSuffix(opts.output, ".gz") {
+			compressed := gzip.NewWriter(file)
+			sink.closers = append(sink.closers, compressed)
+			output = compressed
+		}
+		sink.closers = append(sink.closers, file)
+		writers = append(writers, output)
+	}
+
+	sink.Writer = io.MultiWriter(writers...)
+
+	return &sink, nil
+}
+
+// sinkWriteCloser is a stream that is written to stdout and/or a file, where
+// every compressed stream is flushed, and then the file is closed.
+type sinkWriteCloser struct {
+	io.Writer
+	closers []io.Closer
+}
+
+func (s *sinkWriteCloser) Close() error {
+	var errs []error
+	for _, closer := range s.closers {
+		errs = append(errs, closer.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+// postWriteCloser is a stream that is piped through a post-processing
+// command, which is waited on when the stream is closed.
+type postWriteCloser struct {
+	io.WriteCloser
+	cmd  *exec.Cmd
+	sink io.Closer
+}
+
+func (p *postWriteCloser) Close() error {
+	// The command is always waited on, even if its stdin could not be closed,
+	// so that it is never left behind.
+	closeErr := p.WriteCloser.Close()
+
+	if err := p.cmd.Wait(); err != nil {
+		p.sink.Close()
+
+		return fmt.Errorf("post-processing command: %w", err)
+	}
+
+	if closeErr != nil {
+		p.sink.Close()
+
+		return closeErr
+	}
+
+	return p.sink.Close()
+}
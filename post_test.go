@@ -0,0 +1,277 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPostCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		want     string
+		wantCode int
+	}{
+		{
+			name:   "output is piped through",
+			script: "#!/bin/sh\nsed 's/KSOPS_DRY_RUN_PLACEHOLDER/processed/'\n",
+			want:   "password: processed\n",
+		},
+		{
+			name:     "exit code is kept when exiting without reading",
+			script:   "#!/bin/sh\nexit 3\n",
+			wantCode: 3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			script := filepath.Join(t.TempDir(), "post")
+			if err := os.WriteFile(script, []byte(test.script), 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			env := pluginEnv("testdata/policy", "compliant.enc.yaml")
+			env["KSOPS_DRY_RUN_POST"] = script
+
+			output, err := runMain(t, []string{"generator.yaml"}, env)
+			if test.wantCode != 0 {
+				var exitErr *exec.ExitError
+				if !errors.As(err, &exitErr) || exitErr.ExitCode() != test.wantCode {
+					t.Fatalf("expected exit code %d but got %v", test.wantCode, err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.Contains(output, test.want) {
+				t.Errorf("expected output to contain %q but got:\n%s", test.want, output)
+			}
+		})
+	}
+}
+
+func TestOutputTee(t *testing.T) {
+	// The manifests that are written to stdout by default.
+	want, err := runMain(t, []string{"generator.yaml"}, pluginEnv("testdata/policy", "compliant.enc.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		env        map[string]string
+		post       string
+		wantStdout bool
+		wantFile   string
+		wantErr    string
+	}{
+		{
+			name:     "output replaces stdout",
+			env:      map[string]string{"KSOPS_DRY_RUN_OUTPUT": ""},
+			wantFile: want,
+		},
+		{
+			name:       "tee writes both",
+			env:        map[string]string{"KSOPS_DRY_RUN_OUTPUT": "", "KSOPS_DRY_RUN_TEE": ""},
+			wantStdout: true,
+			wantFile:   want,
+		},
+		{
+			name:       "tee writes both after post-processing",
+			env:        map[string]string{"KSOPS_DRY_RUN_OUTPUT": "", "KSOPS_DRY_RUN_TEE": ""},
+			post:       "#!/bin/sh\nsed 's/KSOPS_DRY_RUN_PLACEHOLDER/processed/'\n",
+			wantStdout: true,
+			wantFile:   strings.ReplaceAll(want, "KSOPS_DRY_RUN_PLACEHOLDER", "processed"),
+		},
+		{
+			name:    "tee requires output",
+			env:     map[string]string{"KSOPS_DRY_RUN_TEE": ""},
+			wantErr: "KSOPS_DRY_RUN_TEE requires KSOPS_DRY_RUN_OUTPUT to be set",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			filename := filepath.Join(dir, "output.yaml")
+
+			env := pluginEnv("testdata/policy", "compliant.enc.yaml")
+			for name, value := range test.env {
+				if name == "KSOPS_DRY_RUN_OUTPUT" {
+					value = filename
+				}
+				env[name] = value
+			}
+			if test.post != "" {
+				env["KSOPS_DRY_RUN_POST"] = filepath.Join(dir, "post")
+				if err := os.WriteFile(env["KSOPS_DRY_RUN_POST"], []byte(test.post), 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			stdout, err := runMain(t, []string{"generator.yaml"}, env)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected error %q but got %v", test.wantErr, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			body, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != test.wantFile {
+				t.Errorf("expected file:\n%s\nbut got:\n%s", test.wantFile, body)
+			}
+
+			// When teeing, stdout receives exactly the same bytes as the file.
+			wantStdout := ""
+			if test.wantStdout {
+				wantStdout = string(body)
+			}
+			if stdout != wantStdout {
+				t.Errorf("expected stdout:\n%s\nbut got:\n%s", wantStdout, stdout)
+			}
+		})
+	}
+}
+
+func TestOutputCompress(t *testing.T) {
+	// The manifests that are written to stdout by default.
+	want, err := runMain(t, []string{"generator.yaml"}, pluginEnv("testdata/policy", "compliant.enc.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name               string
+		output             string
+		env                map[string]string
+		wantStdout         bool
+		wantCompressed     bool
+		wantCompressedFile bool
+	}{
+		{
+			name:   "uncompressed file",
+			output: "output.yaml",
+		},
+		{
+			name:               "gz file",
+			output:             "output.yaml.gz",
+			wantCompressedFile: true,
+		},
+		{
+			name:               "compressed file",
+			output:             "output.yaml",
+			env:                map[string]string{"KSOPS_DRY_RUN_COMPRESS": ""},
+			wantCompressedFile: true,
+		},
+		{
+			name:           "compressed stdout",
+			env:            map[string]string{"KSOPS_DRY_RUN_COMPRESS": ""},
+			wantStdout:     true,
+			wantCompressed: true,
+		},
+		{
+			name:               "gz file teed to uncompressed stdout",
+			output:             "output.yaml.gz",
+			env:                map[string]string{"KSOPS_DRY_RUN_TEE": ""},
+			wantStdout:         true,
+			wantCompressedFile: true,
+		},
+		{
+			name:               "compressed tee",
+			output:             "output.yaml",
+			env:                map[string]string{"KSOPS_DRY_RUN_COMPRESS": "", "KSOPS_DRY_RUN_TEE": ""},
+			wantStdout:         true,
+			wantCompressed:     true,
+			wantCompressedFile: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := pluginEnv("testdata/policy", "compliant.enc.yaml")
+			for name, value := range test.env {
+				env[name] = value
+			}
+
+			var filename string
+			if test.output != "" {
+				filename = filepath.Join(t.TempDir(), test.output)
+				env["KSOPS_DRY_RUN_OUTPUT"] = filename
+			}
+
+			stdout, err := runMain(t, []string{"generator.yaml"}, env)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !test.wantStdout {
+				if stdout != "" {
+					t.Errorf("expected no stdout but got:\n%s", stdout)
+				}
+			} else if got := decompress(t, []byte(stdout), test.wantCompressed); got != want {
+				t.Errorf("expected stdout to hold:\n%s\nbut got:\n%s", want, got)
+			}
+
+			if filename == "" {
+				return
+			}
+
+			body, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := decompress(t, body, test.wantCompressedFile); got != want {
+				t.Errorf("expected file to hold:\n%s\nbut got:\n%s", want, got)
+			}
+		})
+	}
+}
+
+// decompress returns the given gzip compressed data decompressed, or the data
+// itself if it is expected to be uncompressed.
+func decompress(t *testing.T, data []byte, compressed bool) string {
+	t.Helper()
+
+	// Every gzip stream starts with the same magic bytes, which are never the
+	// start of a yaml document.
+	if isCompressed := bytes.HasPrefix(data, []byte{0x1f, 0x8b}); isCompressed != compressed {
+		t.Fatalf("expected compressed %t but got %t", compressed, isCompressed)
+	}
+	if !compressed {
+		return string(data)
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(body)
+}
@@ -0,0 +1,80 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// resolveEntry returns the path of the given local file entry of a generator
+// config at the given depth. The file is resolved relative to the directory
+// from which it was configured, or else relative to the file prefix, which
+// only applies to the top-level generator config.
+func resolveEntry(root, filename string, opts *options, depth int) string {
+	if opts.filePrefix != "" && depth == 0 {
+		return filepath.Join(opts.filePrefix, filename)
+	}
+
+	return resolveFile(root, filename, opts.searchParents)
+}
+
+// checkFiles returns the combined errors for every local file of the given
+// generator config that does not exist or cannot be read. Skipped, ignored,
+// and remote files are never checked, and neither are the files of nested
+// generator configs, which are checked once they are reached.
+func checkFiles(config *ksopsGeneratorConfig, root string, opts *options, depth int) error {
+	var errs []error
+	for _, filename := range config.Files {
+		if opts.isSkipped(filename) || isRemote(filename) {
+			continue
+		}
+
+		if keyFile, ok := parseKeyFile(filename); ok {
+			filename = resolveEntry(root, keyFile.path, opts, depth)
+		} else {
+			filename = resolveEntry(root, filename, opts, depth)
+		}
+
+		if opts.isSkipped(filename) || opts.ignore.matches(filename) {
+			continue
+		}
+
+		if err := checkFile(filename); err != nil {
+			errs = append(errs, &fileError{file: filename, err: err})
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// checkFile returns an error if the given file does not exist, or cannot be
+// opened for reading. The error does not repeat the filename.
+func checkFile(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		var pathError *fs.PathError
+		if errors.As(err, &pathError) {
+			return pathError.Err
+		}
+
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("is a directory")
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckFiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    []string
+		wantErrs []string
+	}{
+		{
+			name:  "every file exists",
+			files: []string{"secret.enc.yaml", "username=username.enc"},
+		},
+		{
+			name:  "every missing file is reported",
+			files: []string{"secret.enc.yaml", "missing.enc.yaml", "username=missing.enc", "mount"},
+			wantErrs: []string{
+				"testdata/keyfiles/missing.enc.yaml: no such file or directory",
+				"testdata/keyfiles/missing.enc: no such file or directory",
+				"testdata/keyfiles/mount: is a directory",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := runMain(t, []string{"generator.yaml"}, pluginEnv("testdata/keyfiles", test.files...))
+			if len(test.wantErrs) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.Contains(output, "name: app\n") {
+					t.Errorf("expected output to contain the secret but got:\n%s", output)
+				}
+
+				return
+			}
+
+			errs := flattenErrors(err)
+			if len(errs) != len(test.wantErrs) {
+				t.Fatalf("expected %d errors but got %v", len(test.wantErrs), err)
+			}
+			for i, want := range test.wantErrs {
+				if !strings.Contains(errs[i].Error(), want) {
+					t.Errorf("expected error %q but got %q", want, errs[i])
+				}
+			}
+
+			// Nothing is written, not even for the files that exist.
+			if output != "" {
+				t.Errorf("expected no output but got:\n%s", output)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// isRemote returns true if the given filename is an http or https url rather
+// than a local file path.
+func isRemote(filename string) bool {
+	return strings.HasPrefix(filename, "http://") || strings.HasPrefix(filename, "https://")
+}
+
+// openEncryptedFile opens the given encrypted file for reading. Local file
+// paths are opened directly, while urls are fetched over http.
+func openEncryptedFile(filename string, opts *options) (io.ReadCloser, error) {
+	if !isRemote(filename) {
+		return os.Open(filename)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.httpTimeout)
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, filename, nil)
+	if err != nil {
+		cancel()
+
+		return nil, err
+	}
+
+	if opts.httpToken != "" {
+		request.Header.Set("Authorization", "Bearer "+opts.httpToken)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		cancel()
+
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		cancel()
+
+		return nil, fmt.Errorf("fetching %s: unexpected status %s", filename, response.Status)
+	}
+
+	return &cancelReadCloser{ReadCloser: response.Body, cancel: cancel}, nil
+}
+
+// cancelReadCloser releases the request context once the response body has
+// been closed.
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelReadCloser) Close() error {
+	defer c.cancel()
+
+	return c.ReadCloser.Close()
+}
@@ -0,0 +1,99 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRemoteFiles(t *testing.T) {
+	body, err := os.ReadFile("testdata/policy/compliant.enc.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/secret.enc.yaml":
+			w.Write(body)
+		case "/private.enc.yaml":
+			if r.Header.Get("Authorization") != "Bearer letmein" {
+				w.WriteHeader(http.StatusUnauthorized)
+
+				return
+			}
+			w.Write(body)
+		case "/slow.enc.yaml":
+			time.Sleep(500 * time.Millisecond)
+			w.Write(body)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		path    string
+		env     map[string]string
+		wantErr string
+	}{
+		{
+			name: "fetched",
+			path: "/secret.enc.yaml",
+		},
+		{
+			name: "with a bearer token",
+			path: "/private.enc.yaml",
+			env:  map[string]string{"KSOPS_DRY_RUN_HTTP_TOKEN": "letmein"},
+		},
+		{
+			name:    "without a bearer token",
+			path:    "/private.enc.yaml",
+			wantErr: "fetching " + server.URL + "/private.enc.yaml: unexpected status 401 Unauthorized",
+		},
+		{
+			name:    "not found",
+			path:    "/missing.enc.yaml",
+			wantErr: "fetching " + server.URL + "/missing.enc.yaml: unexpected status 404 Not Found",
+		},
+		{
+			name:    "timed out",
+			path:    "/slow.enc.yaml",
+			env:     map[string]string{"KSOPS_DRY_RUN_HTTP_TIMEOUT": "50ms"},
+			wantErr: "context deadline exceeded",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			documents, err := parseKsopsEncryptedSecrets(server.URL+test.path, testOptions(t, test.env))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected error %q but got %v", test.wantErr, err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			secrets := secretsOf(documents)
+			if len(secrets) != 1 || secrets[0].Metadata.Name != "database" {
+				t.Fatalf("expected the database secret but got %v", secrets)
+			}
+			if value := secrets[0].StringData["password"]; value != placeholder {
+				t.Errorf("expected a placeholder value but got %q", value)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// retryBackoff is the delay before the first retry, which doubles for each
+// subsequent retry.
+var retryBackoff = 100 * time.Millisecond
+
+// isRetryable returns true if the given error is likely transient, such as
+// those returned by a flaky networked filesystem. A missing file is never
+// retried.
+func isRetryable(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EIO) || errors.Is(err, syscall.EINTR)
+}
+
+// retry calls the given function until it succeeds, returns an error that is
+// not retryable, or has been retried the given number of times.
+func retry(retries int, fn func() error) error {
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= retries || !isRetryable(err) {
+			return err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// readFile reads the given file, retrying transient errors up to the
+// configured number of times.
+func readFile(filename string, opts *options) ([]byte, error) {
+	var body []byte
+	err := retry(opts.readRetries, func() error {
+		var err error
+		body, err = os.ReadFile(filename)
+
+		return err
+	})
+
+	return body, err
+}
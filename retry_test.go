@@ -0,0 +1,110 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// flakyReader fails with each of the given errors in turn, and then succeeds.
+type flakyReader struct {
+	errs  []error
+	reads int
+}
+
+func (r *flakyReader) read() error {
+	r.reads++
+	if r.reads <= len(r.errs) {
+		return r.errs[r.reads-1]
+	}
+
+	return nil
+}
+
+func TestRetry(t *testing.T) {
+	// Keep the backoff short, as it is not what is under test.
+	backoff := retryBackoff
+	retryBackoff = time.Millisecond
+	t.Cleanup(func() { retryBackoff = backoff })
+
+	tests := []struct {
+		name      string
+		retries   int
+		errs      []error
+		wantReads int
+		wantErr   error
+	}{
+		{
+			name:      "success",
+			retries:   3,
+			wantReads: 1,
+		},
+		{
+			name:      "eagain then success",
+			retries:   3,
+			errs:      []error{syscall.EAGAIN},
+			wantReads: 2,
+		},
+		{
+			name:      "eintr then success",
+			retries:   3,
+			errs:      []error{syscall.EINTR, syscall.EINTR},
+			wantReads: 3,
+		},
+		{
+			name:      "wrapped error then success",
+			retries:   3,
+			errs:      []error{&fs.PathError{Op: "read", Path: "secret.enc.yaml", Err: syscall.EIO}},
+			wantReads: 2,
+		},
+		{
+			name:      "gives up after the limit",
+			retries:   2,
+			errs:      []error{syscall.EAGAIN, syscall.EINTR, syscall.EAGAIN, syscall.EAGAIN},
+			wantReads: 3,
+			wantErr:   syscall.EAGAIN,
+		},
+		{
+			name:      "no retries",
+			errs:      []error{syscall.EAGAIN},
+			wantReads: 1,
+			wantErr:   syscall.EAGAIN,
+		},
+		{
+			name:      "missing file is never retried",
+			retries:   3,
+			errs:      []error{fs.ErrNotExist},
+			wantReads: 1,
+			wantErr:   fs.ErrNotExist,
+		},
+		{
+			name:      "other errors are never retried",
+			retries:   3,
+			errs:      []error{fmt.Errorf("permanent")},
+			wantReads: 1,
+			wantErr:   errors.New("permanent"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader := &flakyReader{errs: test.errs}
+
+			err := retry(test.retries, reader.read)
+			if fmt.Sprint(err) != fmt.Sprint(test.wantErr) {
+				t.Errorf("expected error %v but got %v", test.wantErr, err)
+			}
+			if reader.reads != test.wantReads {
+				t.Errorf("expected %d reads but got %d", test.wantReads, reader.reads)
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+// maxSecretSize is the maximum size of the data of a secret that kubernetes
+// accepts.
+// See https://kubernetes.io/docs/concepts/configuration/secret/#restriction-data-size.
+const maxSecretSize = 1 << 20
+
+// originalSize returns an estimate of the size of the data of the original
+// secret, which is the sum of the lengths of its keys and original values.
+// Values in data are base64 encoded, and are counted once decoded.
+func originalSize(secret secret) int {
+	size := 0
+	for key, value := range secret.StringData {
+		size += len(key) + plaintextLength(value)
+	}
+	for key, value := range secret.Data {
+		size += len(key) + plaintextLength(value)*3/4
+	}
+
+	return size
+}
@@ -0,0 +1,97 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestSecretSize(t *testing.T) {
+	// encrypted returns a sops encrypted value, whose original value is the
+	// given number of bytes.
+	encrypted := func(size int) string {
+		return "ENC[AES256_GCM,data:" + base64.StdEncoding.EncodeToString(make([]byte, size)) + ",iv:aXY=,tag:dGFn,type:str]"
+	}
+
+	secretWith := func(fields string) string {
+		return "apiVersion: v1\nkind: Secret\nmetadata:\n  name: database\n  namespace: prod\n" + fields
+	}
+
+	const exceeds = `secret.enc.yaml: secret "prod/database" is about 1048584 bytes, which exceeds the maximum of 1048576 bytes`
+
+	tests := []struct {
+		name     string
+		env      map[string]string
+		content  string
+		wantWarn string
+		wantErr  string
+	}{
+		{
+			name:    "small",
+			content: secretWith("stringData:\n  password: " + encrypted(16) + "\n"),
+		},
+		{
+			name:    "exactly the limit",
+			content: secretWith("stringData:\n  password: " + encrypted(maxSecretSize-len("password")) + "\n"),
+		},
+		{
+			name:     "over the limit",
+			content:  secretWith("stringData:\n  password: " + encrypted(maxSecretSize) + "\n"),
+			wantWarn: exceeds,
+		},
+		{
+			name:     "over the limit across keys",
+			content:  secretWith("stringData:\n  password: " + encrypted(maxSecretSize/2) + "\n  passphr: " + encrypted(maxSecretSize/2+1) + "\n"),
+			wantWarn: `secret.enc.yaml: secret "prod/database" is about 1048592 bytes, which exceeds the maximum of 1048576 bytes`,
+		},
+		{
+			name:     "over the limit unencrypted",
+			content:  secretWith("stringData:\n  password_unencrypted: " + strings.Repeat("x", maxSecretSize) + "\n"),
+			wantWarn: `secret.enc.yaml: secret "prod/database" is about 1048596 bytes, which exceeds the maximum of 1048576 bytes`,
+		},
+		{
+			name:    "data is counted once decoded",
+			content: secretWith("data:\n  password: " + encrypted(maxSecretSize) + "\n"),
+		},
+		{
+			name:    "over the limit in strict mode",
+			env:     map[string]string{"KSOPS_DRY_RUN_STRICT": ""},
+			content: secretWith("stringData:\n  password: " + encrypted(maxSecretSize) + "\n"),
+			wantErr: exceeds,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := testOptions(t, test.env)
+
+			output, err := stubString(t, test.content, opts)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("expected error %q but got %v", test.wantErr, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			// The secret is always written, even if it is too large, and the
+			// stubbed secret is always small.
+			if !strings.Contains(output, "name: database") || len(output) > 1024 {
+				t.Errorf("expected the stubbed secret to be written but got %d bytes", len(output))
+			}
+
+			err = opts.errs()
+			if test.wantWarn == "" && err != nil {
+				t.Fatalf("expected no warning but got %v", err)
+			} else if test.wantWarn != "" && (err == nil || err.Error() != test.wantWarn) {
+				t.Fatalf("expected warning %q but got %v", test.wantWarn, err)
+			}
+		})
+	}
+}
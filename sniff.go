@@ -0,0 +1,124 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+)
+
+// sopsValue represents the parts of a value encrypted by sops that can be
+// known without decrypting it.
+// See https://github.com/getsops/sops#encryption-protocol.
+type sopsValue struct {
+	// data is the base64 encoded ciphertext.
+	data string
+
+	// typ is the type of the original value.
+	typ string
+}
+
+// parseSopsValue parses a value encrypted by sops, which has the form
+// ENC[algorithm,data:...,iv:...,tag:...,type:...]. It is parsed by hand, as
+// every value is checked, and a regular expression is comparatively slow.
+func parseSopsValue(value string) (sopsValue, bool) {
+	rest, found := strings.CutPrefix(value, "ENC[")
+	if !found {
+		return sopsValue{}, false
+	}
+	if rest, found = strings.CutSuffix(rest, "]"); !found {
+		return sopsValue{}, false
+	}
+
+	// Every field is separated by a comma, which is never part of a field.
+	var fields [5]string
+	for i, prefix := range [5]string{"", "data:", "iv:", "tag:", "type:"} {
+		field := rest
+		if i < len(fields)-1 {
+			if field, rest, found = strings.Cut(rest, ","); !found {
+				return sopsValue{}, false
+			}
+		} else if strings.Contains(field, ",") {
+			return sopsValue{}, false
+		}
+
+		if fields[i], found = strings.CutPrefix(field, prefix); !found {
+			return sopsValue{}, false
+		}
+	}
+
+	if fields[0] == "" || fields[4] == "" || strings.TrimFunc(fields[4], isLower) != "" {
+		return sopsValue{}, false
+	}
+
+	return sopsValue{data: fields[1], typ: fields[4]}, true
+}
+
+// isLower returns true if the given rune is a lowercase ascii letter.
+func isLower(r rune) bool {
+	return 'a' <= r && r <= 'z'
+}
+
+// sniffType returns a description of the shape of the given value, without
+// revealing the value itself. An encrypted value can only be described by the
+// type that sops recorded for it, as its content is unknown.
+func sniffType(value string) string {
+	if sops, ok := parseSopsValue(value); ok {
+		return "sops-" + sops.typ
+	}
+
+	trimmed := strings.TrimSpace(value)
+
+	switch {
+	case trimmed == "":
+		return "empty"
+	case strings.HasPrefix(trimmed, "-----BEGIN "):
+		return "pem"
+	case (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid([]byte(trimmed)):
+		return "json"
+	case len(trimmed)%4 == 0 && isBase64(trimmed):
+		return "base64"
+	default:
+		return "text"
+	}
+}
+
+// isBase64 returns true if the given value is valid standard base64.
+func isBase64(value string) bool {
+	_, err := base64.StdEncoding.DecodeString(value)
+
+	return err == nil
+}
+
+// isStillEncrypted returns true if the given value contains sops encrypted
+// content, without being a single sops encrypted value itself. Such a value is
+// likely an entire sops encrypted file, which would need a further pass to
+// decrypt. Base64 encoded values are checked once decoded.
+func isStillEncrypted(value string) bool {
+	if _, ok := parseSopsValue(value); ok {
+		return false
+	}
+
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value)); err == nil {
+		value = string(decoded)
+	}
+
+	return strings.Contains(value, "ENC[")
+}
+
+// plaintextLength returns the length of the original value of the given
+// value. The ciphertext of a sops encrypted value is the same length as the
+// original value, and an unencrypted value is its own original value.
+func plaintextLength(value string) int {
+	if sops, ok := parseSopsValue(value); ok {
+		if ciphertext, err := base64.StdEncoding.DecodeString(sops.data); err == nil {
+			return len(ciphertext)
+		}
+	}
+
+	return len(value)
+}
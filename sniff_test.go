@@ -0,0 +1,171 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestAnnotateTypes(t *testing.T) {
+	longKey := strings.Repeat("k", 100)
+
+	content := `apiVersion: v1
+kind: Secret
+metadata:
+  name: types
+stringData:
+  ca.crt: |
+    -----BEGIN CERTIFICATE-----
+    MIIB
+    -----END CERTIFICATE-----
+  config.json: '{"enabled": true}'
+  encoded: aGVsbG8gd29ybGQ=
+  empty: ""
+  password: hunter2
+  token: ENC[AES256_GCM,data:dG9rZW4=,iv:aXY=,tag:dGFn,type:str]
+  dropped: hunter2
+  ` + longKey + `: hunter2
+`
+
+	output, err := stubString(t, content, testOptions(t, map[string]string{
+		"KSOPS_DRY_RUN_ANNOTATE_TYPES": "",
+		"KSOPS_DRY_RUN_DROP_KEYS":      "dropped",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var secret secret
+	if err := yaml.Unmarshal([]byte(output), &secret); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every type is kept in a single annotation, regardless of how long the
+	// keys are.
+	for name := range secret.Metadata.Annotations {
+		if name != "ksops-dry-run.joshdk.github.com/types" {
+			t.Errorf("unexpected annotation %q", name)
+		}
+	}
+
+	var types map[string]string
+	if err := json.Unmarshal([]byte(secret.Metadata.Annotations["ksops-dry-run.joshdk.github.com/types"]), &types); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"ca.crt":      "pem",
+		"config.json": "json",
+		"encoded":     "base64",
+		"empty":       "empty",
+		"password":    "text",
+		"token":       "sops-str",
+		longKey:       "text",
+	}
+	if len(types) != len(expected) {
+		t.Errorf("expected types %v but got %v", expected, types)
+	}
+	for key, want := range expected {
+		if got := types[key]; got != want {
+			t.Errorf("expected key %q to have type %q but got %q", key, want, got)
+		}
+	}
+}
+
+func TestIsStillEncrypted(t *testing.T) {
+	file := "password: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]\nsops:\n    version: 3.7.3\n"
+
+	tests := []struct {
+		name  string
+		value string
+		want  bool
+	}{
+		{
+			name:  "single encrypted value",
+			value: "ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]",
+		},
+		{
+			name:  "plain value",
+			value: "hunter2",
+		},
+		{
+			name:  "plain base64 value",
+			value: base64.StdEncoding.EncodeToString([]byte("hunter2")),
+		},
+		{
+			name:  "encrypted file",
+			value: file,
+			want:  true,
+		},
+		{
+			name:  "base64 encoded encrypted file",
+			value: base64.StdEncoding.EncodeToString([]byte(file)),
+			want:  true,
+		},
+		{
+			name:  "encrypted value within text",
+			value: "token=ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]",
+			want:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isStillEncrypted(test.value); got != test.want {
+				t.Errorf("expected %t but got %t", test.want, got)
+			}
+		})
+	}
+}
+
+func TestNestedSops(t *testing.T) {
+	body, err := os.ReadFile("testdata/nested-sops.enc.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := testOptions(t, nil)
+
+	output, err := stubString(t, string(body), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every value is stubbed as normal, including those still encrypted.
+	var stubbed secret
+	if err := yaml.Unmarshal([]byte(output), &stubbed); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"password", "inner.enc.yaml", "encoded.enc.yaml", "plain"} {
+		if value := stubbed.StringData[key]; value != placeholder {
+			t.Errorf("expected key %q to have a placeholder value but got %q", key, value)
+		}
+	}
+	if strings.Contains(output, "ENC[") {
+		t.Errorf("expected no encrypted values in the output but got:\n%s", output)
+	}
+
+	// Only the values that are still encrypted are warned about.
+	err = opts.errs()
+	if err == nil {
+		t.Fatal("expected warnings")
+	}
+	for _, key := range []string{"inner.enc.yaml", "encoded.enc.yaml"} {
+		want := `secret.enc.yaml: secret "prod/nested" key "` + key + `" appears to contain sops encrypted content, which would need to be decrypted again`
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected warning %q but got:\n%v", want, err)
+		}
+	}
+	if count := strings.Count(err.Error(), "appears to contain sops encrypted content"); count != 2 {
+		t.Errorf("expected 2 warnings but got:\n%v", err)
+	}
+}
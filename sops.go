@@ -0,0 +1,126 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// sopsMetadata represents the sops metadata block that is attached to every
+// encrypted file. Only the parts that identify the encryption audience and
+// provenance are modeled, and nothing here is ever decrypted.
+// See https://github.com/getsops/sops#encrypting-using-age.
+type sopsMetadata struct {
+	Age []struct {
+		Recipient string `yaml:"recipient"`
+	} `yaml:"age"`
+	PGP []struct {
+		Fingerprint string `yaml:"fp"`
+	} `yaml:"pgp"`
+	KMS []struct {
+		ARN string `yaml:"arn"`
+	} `yaml:"kms"`
+	GCPKMS []struct {
+		ResourceID string `yaml:"resource_id"`
+	} `yaml:"gcp_kms"`
+	AzureKV []struct {
+		VaultURL string `yaml:"vault_url"`
+		Name     string `yaml:"name"`
+		Version  string `yaml:"version"`
+	} `yaml:"azure_kv"`
+	HCVault []struct {
+		VaultAddress string `yaml:"vault_address"`
+		EnginePath   string `yaml:"engine_path"`
+		KeyName      string `yaml:"key_name"`
+	} `yaml:"hc_vault"`
+	LastModified string `yaml:"lastmodified"`
+	MAC          string `yaml:"mac"`
+	Version      string `yaml:"version"`
+}
+
+// recipients returns an identifier for every key that the file was encrypted
+// to, across all key sources.
+func (m *sopsMetadata) recipients() []string {
+	if m == nil {
+		return nil
+	}
+
+	var recipients []string
+	for _, key := range m.Age {
+		recipients = append(recipients, key.Recipient)
+	}
+	for _, key := range m.PGP {
+		recipients = append(recipients, key.Fingerprint)
+	}
+	for _, key := range m.KMS {
+		recipients = append(recipients, key.ARN)
+	}
+	for _, key := range m.GCPKMS {
+		recipients = append(recipients, key.ResourceID)
+	}
+	for _, key := range m.AzureKV {
+		recipients = append(recipients, key.VaultURL+"/keys/"+key.Name+"/"+key.Version)
+	}
+	for _, key := range m.HCVault {
+		recipients = append(recipients, key.VaultAddress+"/v1/"+key.EnginePath+"/keys/"+key.KeyName)
+	}
+
+	return recipients
+}
+
+// summary returns a compact description of the non-sensitive parts of the
+// metadata, for auditing. Neither the mac nor any encrypted data keys are ever
+// included, only whether a mac is present.
+func (m *sopsMetadata) summary() string {
+	if m == nil {
+		return ""
+	}
+
+	var sources []string
+	for _, source := range []struct {
+		name  string
+		count int
+	}{
+		{"age", len(m.Age)},
+		{"pgp", len(m.PGP)},
+		{"kms", len(m.KMS)},
+		{"gcp_kms", len(m.GCPKMS)},
+		{"azure_kv", len(m.AzureKV)},
+		{"hc_vault", len(m.HCVault)},
+	} {
+		if source.count > 0 {
+			sources = append(sources, source.name)
+		}
+	}
+
+	mac := "false"
+	if m.MAC != "" {
+		mac = "true"
+	}
+
+	return strings.Join([]string{
+		"version=" + m.Version,
+		"lastmodified=" + m.LastModified,
+		"mac=" + mac,
+		"sources=" + strings.Join(sources, "+"),
+	}, ",")
+}
+
+// lastModified returns the time that the file was last modified, and false if
+// there is no such time or it cannot be parsed.
+func (m *sopsMetadata) lastModified() (time.Time, bool) {
+	if m == nil || m.LastModified == "" {
+		return time.Time{}, false
+	}
+
+	modified, err := time.Parse(time.RFC3339, m.LastModified)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return modified, true
+}
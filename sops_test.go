@@ -0,0 +1,238 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestAnnotateRecipients(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		want string
+	}{
+		{
+			name: "unset",
+			file: "recipients.enc.yaml",
+		},
+		{
+			name: "multiple recipients",
+			file: "recipients.enc.yaml",
+			env:  map[string]string{"KSOPS_DRY_RUN_ANNOTATE_RECIPIENTS": ""},
+			want: strings.Join([]string{
+				"age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p",
+				"age1lggyhqrw2nlhcxprm67z43rta597azn8gknawjehu9d9dl0jq3yqqvfafg",
+				"FBC7B9E2A4F9289AC0C1D4843D16CEE4A27381B4",
+				"arn:aws:kms:us-east-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab",
+			}, ","),
+		},
+		{
+			name: "single recipient",
+			file: "policy/pgp-only.enc.yaml",
+			env:  map[string]string{"KSOPS_DRY_RUN_ANNOTATE_RECIPIENTS": ""},
+			want: "FBC7B9E2A4F9289AC0C1D4843D16CEE4A27381B4",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, err := os.ReadFile("testdata/" + test.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			output, err := stubString(t, string(body), testOptions(t, test.env))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var stubbed secret
+			if err := yaml.Unmarshal([]byte(output), &stubbed); err != nil {
+				t.Fatal(err)
+			}
+
+			if value := stubbed.Metadata.Annotations["ksops-dry-run.joshdk.github.com/recipients"]; value != test.want {
+				t.Errorf("expected recipients %q but got %q", test.want, value)
+			}
+
+			// The encrypted data keys are never written.
+			if strings.Contains(output, "AGE ENCRYPTED FILE") {
+				t.Errorf("expected no encrypted data keys in the output but got:\n%s", output)
+			}
+		})
+	}
+}
+
+func TestAnnotateSops(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		want string
+	}{
+		{
+			name: "unset",
+			file: "recipients.enc.yaml",
+		},
+		{
+			name: "multiple key sources",
+			file: "recipients.enc.yaml",
+			env:  map[string]string{"KSOPS_DRY_RUN_ANNOTATE_SOPS": ""},
+			want: "version=3.8.1,lastmodified=2026-01-01T00:00:00Z,mac=true,sources=age+pgp+kms",
+		},
+		{
+			name: "without a mac",
+			file: "server-fields.enc.yaml",
+			env:  map[string]string{"KSOPS_DRY_RUN_ANNOTATE_SOPS": ""},
+			want: "version=3.7.3,lastmodified=,mac=false,sources=",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, err := os.ReadFile("testdata/" + test.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			output, err := stubString(t, string(body), testOptions(t, test.env))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var stubbed secret
+			if err := yaml.Unmarshal([]byte(output), &stubbed); err != nil {
+				t.Fatal(err)
+			}
+
+			if value := stubbed.Metadata.Annotations["ksops-dry-run.joshdk.github.com/sops"]; value != test.want {
+				t.Errorf("expected sops summary %q but got %q", test.want, value)
+			}
+
+			// Neither the mac, the encrypted data keys, nor the recipients
+			// are ever written.
+			for _, sensitive := range []string{"bWFj", "AGE ENCRYPTED FILE", "YWdlLWVuY3J5cHRpb24", "age1", "FBC7B9E2", "arn:aws"} {
+				if strings.Contains(output, sensitive) {
+					t.Errorf("expected no %q in the output but got:\n%s", sensitive, output)
+				}
+			}
+		})
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	// secretModified returns an encrypted secret with the given sops block.
+	secretModified := func(sops string) string {
+		return `apiVersion: v1
+kind: Secret
+metadata:
+  name: database
+  namespace: prod
+stringData:
+  password: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]
+` + sops
+	}
+
+	daysAgo := func(days int) string {
+		return "sops:\n  lastmodified: \"" + time.Now().Add(-time.Duration(days)*24*time.Hour).UTC().Format(time.RFC3339) + "\"\n"
+	}
+
+	tests := []struct {
+		name     string
+		maxAge   string
+		content  string
+		wantErr  string
+		wantWarn string
+	}{
+		{
+			name:    "unset",
+			content: secretModified(daysAgo(365)),
+		},
+		{
+			name:    "fresh",
+			maxAge:  "90d",
+			content: secretModified(daysAgo(30)),
+		},
+		{
+			name:     "stale",
+			maxAge:   "90d",
+			content:  secretModified(daysAgo(120)),
+			wantWarn: `secret.enc.yaml: secret "prod/database" was last modified 120 days ago, which is over the maximum age`,
+		},
+		{
+			name:     "stale by duration",
+			maxAge:   "720h",
+			content:  secretModified(daysAgo(31)),
+			wantWarn: `secret "prod/database" was last modified 31 days ago`,
+		},
+		{
+			name:    "missing timestamp",
+			maxAge:  "90d",
+			content: secretModified("sops:\n  version: 3.8.1\n"),
+		},
+		{
+			name:    "missing sops metadata",
+			maxAge:  "90d",
+			content: secretModified(""),
+		},
+		{
+			name:     "unparseable timestamp",
+			maxAge:   "90d",
+			content:  secretModified("sops:\n  lastmodified: yesterday\n"),
+			wantWarn: `secret.enc.yaml: secret "prod/database" has an invalid sops lastmodified "yesterday"`,
+		},
+		{
+			name:    "invalid max age",
+			maxAge:  "ninety days",
+			wantErr: `expected KSOPS_DRY_RUN_MAX_AGE to be a positive duration but got "ninety days"`,
+		},
+		{
+			name:    "negative max age",
+			maxAge:  "-90d",
+			wantErr: `expected KSOPS_DRY_RUN_MAX_AGE to be a positive duration but got "-90d"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("KSOPS_DRY_RUN_MAX_AGE", test.maxAge)
+
+			opts, err := loadOptions()
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("expected error %q but got %v", test.wantErr, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			opts.warningsAsErrors = true
+
+			// The secret is always written, even if it is stale.
+			output, err := stubString(t, test.content, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(output, "name: database") {
+				t.Errorf("expected the secret to be written but got:\n%s", output)
+			}
+
+			err = opts.errs()
+			if test.wantWarn == "" && err != nil {
+				t.Fatalf("expected no warning but got %v", err)
+			} else if test.wantWarn != "" && (err == nil || !strings.Contains(err.Error(), test.wantWarn)) {
+				t.Fatalf("expected warning %q but got %v", test.wantWarn, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// sortKubectl is the sort order used by kubectl diff.
+const sortKubectl = "kubectl"
+
+// sortDocuments sorts the given documents in the same order that kubectl diff
+// reports resources. kubectl diff writes every resource to a file named
+// [<group>.]<version>.<kind>.<namespace>.<name>, where the group is omitted
+// for the core group, and then diffs the directories of files, so resources
+// are ordered by comparing those names byte by byte.
+// See https://github.com/kubernetes/kubectl/blob/master/pkg/cmd/diff/diff.go.
+func sortDocuments(documents []document) {
+	sort.SliceStable(documents, func(i, j int) bool {
+		return documents[i].diffName() < documents[j].diffName()
+	})
+}
+
+// diffName returns the name that kubectl diff gives to the resource.
+func (d document) diffName() string {
+	var apiVersion, kind, name string
+	if d.secret != nil {
+		apiVersion, kind, name = d.secret.APIVersion, d.secret.Kind, d.secret.Metadata.Name
+	} else {
+		node := d.other
+		if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+			node = node.Content[0]
+		}
+		if value := mappingValue(node, "apiVersion"); value != nil {
+			apiVersion = value.Value
+		}
+		if value := mappingValue(node, "kind"); value != nil {
+			kind = value.Value
+		}
+		if value := mappingValue(mappingValue(node, "metadata"), "name"); value != nil {
+			name = value.Value
+		}
+	}
+
+	// The group is only included, along with a trailing dot, if there is one.
+	var group string
+	version := apiVersion
+	if before, after, found := strings.Cut(apiVersion, "/"); found {
+		group, version = before+".", after
+	}
+
+	return group + strings.Join([]string{version, kind, d.namespace(), name}, ".")
+}
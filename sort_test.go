@@ -0,0 +1,85 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSortDocuments(t *testing.T) {
+	content := `apiVersion: v1
+kind: Secret
+metadata:
+  name: b
+  namespace: prod
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: a
+  namespace: prod
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: prod
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: z
+  namespace: dev
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: a
+  namespace: prod
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: unnamespaced
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: a
+  namespace: prod
+`
+
+	documents, err := stubKsopsEncryptedSecrets(strings.NewReader(content), "secret.enc.yaml", testOptions(t, map[string]string{
+		"KSOPS_DRY_RUN_PASSTHROUGH_OTHERS": "",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sortDocuments(documents)
+
+	// The order that kubectl diff reports the same resources in, where the
+	// names are compared byte by byte, so e.g. a missing namespace sorts
+	// first, and Secret before ServiceAccount.
+	want := []string{
+		"apps.v1.Deployment.prod.web",
+		"v1.ConfigMap.prod.a",
+		"v1.Secret..unnamespaced",
+		"v1.Secret.dev.z",
+		"v1.Secret.prod.a",
+		"v1.Secret.prod.b",
+		"v1.ServiceAccount.prod.a",
+	}
+
+	var got []string
+	for _, document := range documents {
+		got = append(got, document.diffName())
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected order:\n%s\nbut got:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Supported strategies for replacing encrypted values with placeholders.
+const (
+	// strategyPlaceholder replaces every value with the placeholder, except
+	// for empty values which are preserved. This is the default.
+	strategyPlaceholder = "placeholder"
+
+	// strategyHashed replaces every value with the placeholder suffixed by a
+	// hash of the encrypted value, so that changes to a value are visible.
+	strategyHashed = "hashed"
+
+	// strategyHidden replaces every value with the placeholder, including
+	// empty values, so that nothing about the values is revealed.
+	strategyHidden = "hidden"
+
+	// strategyMasked replaces every value with a mask of asterisks, whose
+	// width gives a rough sense of the size of the original value, except
+	// for empty values which are preserved.
+	strategyMasked = "masked"
+
+	// strategyPassthrough writes every original value unchanged, and is only
+	// used for files that are explicitly allowed by KSOPS_DRY_RUN_PASSTHROUGH.
+	// It can never be configured in a strategies file.
+	strategyPassthrough = "passthrough"
+)
+
+// loadStrategies reads and parses the strategies file with the given name,
+// which maps encrypted filenames (as they appear in a generator config) to the
+// strategy used for that file.
+func loadStrategies(filename string) (map[string]string, error) {
+	body, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var strategies map[string]string
+	if err := yaml.Unmarshal(body, &strategies); err != nil {
+		return nil, fmt.Errorf("parsing strategies %s: %w", filename, err)
+	}
+
+	for file, strategy := range strategies {
+		switch strategy {
+		case strategyPlaceholder, strategyHashed, strategyHidden, strategyMasked:
+		default:
+			return nil, fmt.Errorf("parsing strategies %s: unsupported strategy %q for %s", filename, strategy, file)
+		}
+	}
+
+	return strategies, nil
+}
+
+// forFile returns the options to use for the given encrypted filename (as it
+// appears in a generator config), which differ only if there is a strategy
+// configured for that file, or if the file is passed through.
+func (o *options) forFile(filename string) *options {
+	if _, found := o.passthroughFiles[filename]; found {
+		opts := *o
+		opts.strategy = strategyPassthrough
+
+		return &opts
+	}
+
+	strategy, found := o.strategies[filename]
+	if !found {
+		return o
+	}
+
+	opts := *o
+	opts.strategy = strategy
+
+	return &opts
+}
+
+// placeholderFor returns the placeholder used in place of the given value of
+// the given key. Only the placeholder of a sops encrypted value is marked, so
+// that the plaintext values of a partially encrypted file are left unmarked.
+func (o *options) placeholderFor(value string, key placeholderKey) string {
+	stub := o.unmarkedPlaceholderFor(value, key)
+	if _, encrypted := parseSopsValue(value); encrypted && stub != "" {
+		return o.marker + stub
+	}
+
+	return stub
+}
+
+// unmarkedPlaceholderFor returns the placeholder used in place of the given
+// value of the given key, without any marker.
+func (o *options) unmarkedPlaceholderFor(value string, key placeholderKey) string {
+	base := o.placeholder(key)
+
+	switch o.strategy {
+	case strategyHashed:
+		sum := sha256.Sum256([]byte(value))
+
+		return base + "_" + hex.EncodeToString(sum[:])[:10]
+	case strategyHidden:
+		return base
+	case strategyMasked:
+		if value == "" {
+			return ""
+		}
+
+		return strings.Repeat("*", maskWidth(value))
+	default:
+		if value == "" {
+			return ""
+		}
+
+		return base
+	}
+}
+
+// maskBlock is the granularity of mask widths, which are rounded up to it so
+// that the exact length of a value is never revealed.
+const maskBlock = 8
+
+// maskWidth returns the width of the mask for the given value, which is the
+// length of the original value, rounded up to a whole block.
+func maskWidth(value string) int {
+	length := plaintextLength(value)
+	if length == 0 {
+		return maskBlock
+	}
+
+	return (length + maskBlock - 1) / maskBlock * maskBlock
+}
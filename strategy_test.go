@@ -0,0 +1,414 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestEncryptedMarker(t *testing.T) {
+	content := `apiVersion: v1
+kind: Secret
+metadata:
+  name: marked
+  namespace: prod
+stringData:
+  password: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]
+  username_unencrypted: admin
+`
+
+	tests := []struct {
+		name          string
+		env           map[string]string
+		strategy      string
+		wantEncrypted string
+		wantPlaintext string
+	}{
+		{
+			name:          "unmarked",
+			env:           map[string]string{},
+			wantEncrypted: placeholder,
+			wantPlaintext: placeholder,
+		},
+		{
+			name:          "default marker",
+			env:           map[string]string{"KSOPS_DRY_RUN_ENCRYPTED_MARKER": ""},
+			wantEncrypted: "ENCRYPTED_" + placeholder,
+			wantPlaintext: placeholder,
+		},
+		{
+			name:          "custom marker",
+			env:           map[string]string{"KSOPS_DRY_RUN_ENCRYPTED_MARKER": "🔒"},
+			wantEncrypted: "🔒" + placeholder,
+			wantPlaintext: placeholder,
+		},
+		{
+			name:          "masked",
+			env:           map[string]string{"KSOPS_DRY_RUN_ENCRYPTED_MARKER": "🔒"},
+			strategy:      strategyMasked,
+			wantEncrypted: "🔒********",
+			wantPlaintext: "********",
+		},
+		{
+			name:          "template",
+			env:           map[string]string{"KSOPS_DRY_RUN_ENCRYPTED_MARKER": "🔒", "KSOPS_DRY_RUN_TEMPLATE": "{{.Key}}"},
+			wantEncrypted: "🔒password",
+			wantPlaintext: "username_unencrypted",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := testOptions(t, test.env)
+			if test.strategy != "" {
+				opts.strategy = test.strategy
+			}
+
+			output, err := stubString(t, content, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The marked placeholder must remain a valid scalar, which reads
+			// back as exactly the same value.
+			var secret secret
+			if err := yaml.Unmarshal([]byte(output), &secret); err != nil {
+				t.Fatal(err)
+			}
+			if got := secret.StringData["password"]; got != test.wantEncrypted {
+				t.Errorf("expected encrypted value %q but got %q", test.wantEncrypted, got)
+			}
+			if got := secret.StringData["username_unencrypted"]; got != test.wantPlaintext {
+				t.Errorf("expected plaintext value %q but got %q", test.wantPlaintext, got)
+			}
+		})
+	}
+}
+
+func TestStrategiesFile(t *testing.T) {
+	encrypted := func(name string) string {
+		return "apiVersion: v1\nkind: Secret\nmetadata:\n  name: " + name + "\n  namespace: prod\nstringData:\n  password: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]\n  empty: \"\"\n"
+	}
+
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"hashed.enc.yaml":  encrypted("hashed"),
+		"hidden.enc.yaml":  encrypted("hidden"),
+		"default.enc.yaml": encrypted("default"),
+		"strategies.yaml":  "hashed.enc.yaml: hashed\nhidden.enc.yaml: hidden\n",
+	})
+
+	env := pluginEnv(root, "hashed.enc.yaml", "hidden.enc.yaml", "default.enc.yaml")
+	env["KSOPS_DRY_RUN_STRATEGIES"] = filepath.Join(root, "strategies.yaml")
+
+	output, err := runMain(t, nil, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stubbed := make(map[string]map[string]string)
+	decoder := yaml.NewDecoder(strings.NewReader(output))
+	for {
+		var document secret
+		if err := decoder.Decode(&document); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		stubbed[document.Metadata.Name] = document.StringData
+	}
+
+	hashed := regexp.MustCompile("^" + placeholder + "_[0-9a-f]{10}$")
+
+	tests := []struct {
+		name      string
+		password  func(string) bool
+		empty     func(string) bool
+		wantShape string
+	}{
+		{
+			name:      "hashed",
+			password:  hashed.MatchString,
+			empty:     hashed.MatchString,
+			wantShape: "a hashed placeholder",
+		},
+		{
+			name:      "hidden",
+			password:  func(value string) bool { return value == placeholder },
+			empty:     func(value string) bool { return value == placeholder },
+			wantShape: "the placeholder, even when empty",
+		},
+		{
+			name:      "default",
+			password:  func(value string) bool { return value == placeholder },
+			empty:     func(value string) bool { return value == "" },
+			wantShape: "the placeholder, or empty when empty",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			values, found := stubbed[test.name]
+			if !found {
+				t.Fatalf("expected secret %q but got:\n%s", test.name, output)
+			}
+
+			if !test.password(values["password"]) || !test.empty(values["empty"]) {
+				t.Errorf("expected values to be %s but got password %q and empty %q", test.wantShape, values["password"], values["empty"])
+			}
+		})
+	}
+
+	// Hashed placeholders differ only when the encrypted values do.
+	if stubbed["hashed"]["password"] == stubbed["hashed"]["empty"] {
+		t.Errorf("expected different values to have different hashes but got %q", stubbed["hashed"]["password"])
+	}
+}
+
+func TestStrategiesFileInvalid(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"strategies.yaml": "secret.enc.yaml: passthrough\n",
+	})
+
+	t.Setenv("KSOPS_DRY_RUN_STRATEGIES", filepath.Join(root, "strategies.yaml"))
+
+	_, err := loadOptions()
+	if err == nil || !strings.Contains(err.Error(), `unsupported strategy "passthrough" for secret.enc.yaml`) {
+		t.Fatalf("expected an unsupported strategy error but got %v", err)
+	}
+}
+
+func TestMaskWidth(t *testing.T) {
+	encrypted := func(data string) string {
+		return "ENC[AES256_GCM,data:" + data + ",iv:aXY=,tag:dGFn,type:str]"
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		want  int
+	}{
+		{
+			name:  "shorter than a block",
+			value: encrypted("9Cn4cx8="), // 5 bytes
+			want:  8,
+		},
+		{
+			name:  "exactly a block",
+			value: encrypted("MTIzNDU2Nzg="), // 8 bytes
+			want:  8,
+		},
+		{
+			name:  "just over a block",
+			value: encrypted("MTIzNDU2Nzg5"), // 9 bytes
+			want:  16,
+		},
+		{
+			name:  "several blocks",
+			value: encrypted("aGVsbG8sIHRoaXMgaXMgYSBsb25nZXIgdmFsdWU="), // 28 bytes
+			want:  32,
+		},
+		{
+			name:  "empty ciphertext",
+			value: encrypted(""),
+			want:  8,
+		},
+		{
+			name:  "invalid ciphertext",
+			value: encrypted("!!!!"), // the whole value, 51 bytes
+			want:  56,
+		},
+		{
+			name:  "plaintext",
+			value: "admin-username", // 14 bytes
+			want:  16,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := maskWidth(test.value); got != test.want {
+				t.Errorf("expected width %d but got %d", test.want, got)
+			}
+		})
+	}
+}
+
+func TestMaskedStrategy(t *testing.T) {
+	const (
+		short = "ENC[AES256_GCM,data:9Cn4cx8=,iv:c2hvcnQtaXY=,tag:c2hvcnQtdGFn,type:str]"
+		long  = "ENC[AES256_GCM,data:aGVsbG8sIHRoaXMgaXMgYSBsb25nZXIgdmFsdWU=,iv:bG9uZy1pdg==,tag:bG9uZy10YWc=,type:str]"
+	)
+
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"masked.enc.yaml": "apiVersion: v1\nkind: Secret\nmetadata:\n  name: masked\nstringData:\n  short: " + short + "\n  long: " + long + "\n  empty: \"\"\n",
+		"strategies.yaml": "masked.enc.yaml: masked\n",
+	})
+
+	env := pluginEnv(root, "masked.enc.yaml")
+	env["KSOPS_DRY_RUN_STRATEGIES"] = filepath.Join(root, "strategies.yaml")
+
+	output, err := runMain(t, nil, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stubbed secret
+	if err := yaml.Unmarshal([]byte(output), &stubbed); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{
+			key:  "short",
+			want: "********",
+		},
+		{
+			key:  "long",
+			want: "********************************",
+		},
+		{
+			key:  "empty",
+			want: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.key, func(t *testing.T) {
+			if got := stubbed.StringData[test.key]; got != test.want {
+				t.Errorf("expected mask %q but got %q", test.want, got)
+			}
+		})
+	}
+
+	// No ciphertext, iv, or tag of any encrypted value is ever included, only
+	// the width of its ciphertext.
+	for _, leaked := range []string{"ENC[", "9Cn4cx8=", "c2hvcnQtaXY=", "c2hvcnQtdGFn", "aGVsbG8sIHRoaXMgaXMgYSBsb25nZXIgdmFsdWU=", "bG9uZy1pdg==", "bG9uZy10YWc="} {
+		if strings.Contains(output, leaked) {
+			t.Errorf("expected no %q in the output but got:\n%s", leaked, output)
+		}
+	}
+	if strings.Contains(output, placeholder) {
+		t.Errorf("expected no placeholder in the output but got:\n%s", output)
+	}
+}
+
+func TestPassthrough(t *testing.T) {
+	plaintext := func(name string) string {
+		return "apiVersion: v1\nkind: Secret\nmetadata:\n  name: " + name + "\n  namespace: prod\nstringData:\n  log-level: debug\ndata:\n  replicas: Mw==\n"
+	}
+
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"allowed.enc.yaml":   plaintext("allowed"),
+		"listed.enc.yaml":    plaintext("listed"),
+		"encrypted.enc.yaml": "apiVersion: v1\nkind: Secret\nmetadata:\n  name: encrypted\nstringData:\n  password: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]\nsops:\n  version: 3.7.3\n",
+	})
+
+	tests := []struct {
+		name            string
+		files           []string
+		env             map[string]string
+		wantPassthrough map[string]bool
+		wantErr         string
+	}{
+		{
+			name:            "allowed file",
+			files:           []string{"allowed.enc.yaml", "listed.enc.yaml"},
+			env:             map[string]string{"KSOPS_DRY_RUN_PASSTHROUGH": "", "KSOPS_DRY_RUN_PASSTHROUGH_FILES": "allowed.enc.yaml"},
+			wantPassthrough: map[string]bool{"allowed": true, "listed": false},
+		},
+		{
+			name:            "several allowed files",
+			files:           []string{"allowed.enc.yaml", "listed.enc.yaml"},
+			env:             map[string]string{"KSOPS_DRY_RUN_PASSTHROUGH": "", "KSOPS_DRY_RUN_PASSTHROUGH_FILES": "allowed.enc.yaml, listed.enc.yaml"},
+			wantPassthrough: map[string]bool{"allowed": true, "listed": true},
+		},
+		{
+			name:            "no allowed files",
+			files:           []string{"allowed.enc.yaml", "listed.enc.yaml"},
+			wantPassthrough: map[string]bool{"allowed": false, "listed": false},
+		},
+		{
+			name:    "flag without files",
+			files:   []string{"allowed.enc.yaml"},
+			env:     map[string]string{"KSOPS_DRY_RUN_PASSTHROUGH": ""},
+			wantErr: "KSOPS_DRY_RUN_PASSTHROUGH requires KSOPS_DRY_RUN_PASSTHROUGH_FILES to be set",
+		},
+		{
+			name:    "files without flag",
+			files:   []string{"allowed.enc.yaml"},
+			env:     map[string]string{"KSOPS_DRY_RUN_PASSTHROUGH_FILES": "allowed.enc.yaml"},
+			wantErr: "KSOPS_DRY_RUN_PASSTHROUGH_FILES requires KSOPS_DRY_RUN_PASSTHROUGH to be set",
+		},
+		{
+			name:    "allowed file that is encrypted",
+			files:   []string{"encrypted.enc.yaml"},
+			env:     map[string]string{"KSOPS_DRY_RUN_PASSTHROUGH": "", "KSOPS_DRY_RUN_PASSTHROUGH_FILES": "encrypted.enc.yaml", "KSOPS_DRY_RUN_WARNINGS_AS_ERRORS": ""},
+			wantErr: `secret "encrypted" is passed through, but appears to be sops encrypted`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := pluginEnv(root, test.files...)
+			for name, value := range test.env {
+				env[name] = value
+			}
+
+			output, err := runMain(t, nil, env)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected error %q but got %v", test.wantErr, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			var count int
+			decoder := yaml.NewDecoder(strings.NewReader(output))
+			for ; ; count++ {
+				var document secret
+				if err := decoder.Decode(&document); errors.Is(err, io.EOF) {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+
+				name := document.Metadata.Name
+				want, found := test.wantPassthrough[name]
+				if !found {
+					t.Fatalf("unexpected secret %q", name)
+				}
+
+				// Only an allowed file keeps its original values, and is
+				// marked as such.
+				annotated := document.Metadata.Annotations["ksops-dry-run.joshdk.github.com/passthrough"] == "true"
+				original := document.StringData["log-level"] == "debug" && document.Data["replicas"] == "Mw=="
+				if annotated != want || original != want {
+					t.Errorf("expected secret %q to be passed through: %t, but got annotated: %t, original values: %t", name, want, annotated, original)
+				}
+			}
+
+			if count != len(test.wantPassthrough) {
+				t.Errorf("expected %d secrets but got:\n%s", len(test.wantPassthrough), output)
+			}
+		})
+	}
+}
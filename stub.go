@@ -0,0 +1,77 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// stubCmd writes the stubbed secrets for exactly the given encrypted files,
+// without needing a ksops generator config or to be run by kustomize. Every
+// local file is checked to exist before any are processed, and the secrets are
+// checked, filtered, and sorted in the same way as when run by kustomize.
+func stubCmd(filenames []string) error {
+	if len(filenames) == 0 {
+		return fmt.Errorf("usage: ksops-dry-run stub FILE...")
+	}
+
+	opts, err := loadOptions()
+	if err != nil {
+		return err
+	}
+
+	// If the KSOPS_DRY_RUN_POLICY environment variable is set, then it names a
+	// policy file that every encrypted secret is checked against.
+	var compliance *policy
+	if filename := os.Getenv("KSOPS_DRY_RUN_POLICY"); filename != "" {
+		if compliance, err = loadPolicy(filename); err != nil {
+			return err
+		}
+	}
+
+	var errs []error
+	for _, filename := range filenames {
+		if isRemote(filename) {
+			continue
+		}
+		if err := checkFile(filename); err != nil {
+			errs = append(errs, &fileError{file: filename, err: err})
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	var documents []document
+	for _, filename := range filenames {
+		// Use the placeholder strategy configured for this file, if any.
+		parsed, err := parseKsopsEncryptedSecrets(filename, opts.forFile(filename))
+		if err != nil {
+			return err
+		}
+
+		documents = append(documents, parsed...)
+	}
+
+	// Check, filter, and sort the documents, before anything is written. The
+	// files are relative to the working directory.
+	documents, err = finishDocuments(documents, ".", compliance, opts)
+	if err != nil {
+		return err
+	}
+
+	err = writeOutput(opts, func(output io.Writer) error {
+		return writeDocuments(output, documents, opts)
+	})
+	if err != nil {
+		return err
+	}
+
+	return opts.errs()
+}
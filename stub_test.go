@@ -0,0 +1,139 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestStubCmd(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    []string
+		env      map[string]string
+		want     []string
+		wantErrs []string
+	}{
+		{
+			name:  "every file",
+			files: []string{"testdata/policy/compliant.enc.yaml", "testdata/policy/wrong-recipient.enc.yaml"},
+			want:  []string{"database", "cache"},
+		},
+		{
+			name:  "only",
+			files: []string{"testdata/policy/compliant.enc.yaml", "testdata/policy/wrong-recipient.enc.yaml"},
+			env:   map[string]string{"KSOPS_DRY_RUN_ONLY": "cache"},
+			want:  []string{"cache"},
+		},
+		{
+			name:  "sorted",
+			files: []string{"testdata/policy/compliant.enc.yaml", "testdata/policy/wrong-recipient.enc.yaml"},
+			env:   map[string]string{"KSOPS_DRY_RUN_SORT": "kubectl"},
+			want:  []string{"cache", "database"},
+		},
+		{
+			name:  "compliant with the policy",
+			files: []string{"testdata/policy/compliant.enc.yaml"},
+			env:   map[string]string{"KSOPS_DRY_RUN_POLICY": "testdata/policy/policy.yaml"},
+			want:  []string{"database"},
+		},
+		{
+			name:  "not compliant with the policy",
+			files: []string{"testdata/policy/compliant.enc.yaml", "testdata/policy/wrong-recipient.enc.yaml"},
+			env:   map[string]string{"KSOPS_DRY_RUN_POLICY": "testdata/policy/policy.yaml"},
+			wantErrs: []string{
+				`testdata/policy/wrong-recipient.enc.yaml: secret "prod/cache" is missing required recipients age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p`,
+			},
+		},
+		{
+			name:  "missing files",
+			files: []string{"testdata/policy/compliant.enc.yaml", "testdata/policy/missing.enc.yaml", "testdata/missing.enc.yaml"},
+			wantErrs: []string{
+				"testdata/policy/missing.enc.yaml: no such file or directory",
+				"testdata/missing.enc.yaml: no such file or directory",
+			},
+		},
+		{
+			name:     "no files",
+			wantErrs: []string{"usage: ksops-dry-run stub FILE..."},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := runMain(t, append([]string{"stub"}, test.files...), test.env)
+			if len(test.wantErrs) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var names []string
+				decoder := yaml.NewDecoder(strings.NewReader(output))
+				for {
+					var document secret
+					if err := decoder.Decode(&document); errors.Is(err, io.EOF) {
+						break
+					} else if err != nil {
+						t.Fatal(err)
+					}
+					names = append(names, document.Metadata.Name)
+				}
+
+				// The secrets are written in the order that the files were
+				// given, unless sorted.
+				if got, want := strings.Join(names, ","), strings.Join(test.want, ","); got != want {
+					t.Errorf("expected secrets %s but got %s", want, got)
+				}
+
+				return
+			}
+
+			errs := flattenErrors(err)
+			if len(errs) != len(test.wantErrs) {
+				t.Fatalf("expected %d errors but got %v", len(test.wantErrs), err)
+			}
+			for i, want := range test.wantErrs {
+				if !strings.Contains(errs[i].Error(), want) {
+					t.Errorf("expected error %q but got %q", want, errs[i])
+				}
+			}
+			if output != "" {
+				t.Errorf("expected no output but got:\n%s", output)
+			}
+		})
+	}
+}
+
+func TestStubCmdAudit(t *testing.T) {
+	files := []string{"testdata/policy/compliant.enc.yaml", "testdata/policy/wrong-recipient.enc.yaml"}
+	env := map[string]string{"KSOPS_DRY_RUN_AUDIT_LOG": filepath.Join(t.TempDir(), "audit.log")}
+
+	if _, err := runMain(t, append([]string{"stub"}, files...), env); err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := os.ReadFile(env["KSOPS_DRY_RUN_AUDIT_LOG"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(body), "\n") != 1 {
+		t.Fatalf("expected a single record but got:\n%s", body)
+	}
+
+	// Every given file is recorded.
+	for _, file := range files {
+		if !strings.Contains(string(body), `"file":"`+file+`"`) {
+			t.Errorf("expected %s to be recorded but got:\n%s", file, body)
+		}
+	}
+}
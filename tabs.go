@@ -0,0 +1,48 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// checkTabs returns an error for the first line of the given yaml that is
+// indented with a tab, which yaml does not allow, but which the decoder may
+// tolerate with a subtly different result. Tabs within the content of block
+// scalars, and before comments, are allowed.
+func checkTabs(body []byte) error {
+	// scalar is the indentation of the line that started the current block
+	// scalar, or -1 if not in a block scalar.
+	scalar := -1
+
+	for i, line := range bytes.Split(body, []byte("\n")) {
+		trimmed := bytes.TrimRight(line, "\r")
+		content := bytes.TrimLeft(trimmed, " \t")
+		column := len(trimmed) - len(bytes.TrimLeft(trimmed, " "))
+
+		// Blank lines and comments have no indentation to speak of.
+		if len(content) == 0 || content[0] == '#' {
+			continue
+		}
+
+		if scalar >= 0 && column <= scalar {
+			scalar = -1
+		}
+
+		// A tab that follows the indentation of a block scalar is content.
+		indentation := trimmed[:len(trimmed)-len(content)]
+		if tab := bytes.IndexByte(indentation, '\t'); tab >= 0 && (scalar < 0 || tab <= scalar) {
+			return fmt.Errorf("line %d is indented with a tab, which yaml does not allow", i+1)
+		}
+
+		if scalar < 0 && blockScalarPattern.Match(content) {
+			scalar = column
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStrictYAML(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		strict  bool
+		wantErr string
+	}{
+		{
+			name:    "tab indentation is rejected",
+			file:    "testdata/tabs/tab-indented.enc.yaml",
+			strict:  true,
+			wantErr: "testdata/tabs/tab-indented.enc.yaml: line 8 is indented with a tab, which yaml does not allow",
+		},
+		{
+			name:   "tabs within a block scalar are allowed",
+			file:   "testdata/tabs/block-scalar.enc.yaml",
+			strict: true,
+		},
+		{
+			name: "tabs are not checked by default",
+			file: "testdata/tabs/block-scalar.enc.yaml",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := map[string]string{}
+			if test.strict {
+				env["KSOPS_DRY_RUN_STRICT_YAML"] = ""
+			}
+
+			documents, err := parseKsopsEncryptedSecrets(test.file, testOptions(t, env))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected error %q but got %v", test.wantErr, err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(secretsOf(documents)) != 1 {
+				t.Errorf("expected a single secret but got %d", len(secretsOf(documents)))
+			}
+		})
+	}
+}
+
+func TestStrictYAMLDefault(t *testing.T) {
+	// Without strict parsing, the tab is left for the decoder to handle, in
+	// whichever way it does.
+	_, err := parseKsopsEncryptedSecrets("testdata/tabs/tab-indented.enc.yaml", testOptions(t, nil))
+	if err != nil && strings.Contains(err.Error(), "indented with a tab") {
+		t.Errorf("expected tabs to not be checked but got %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// includeKey is the key used in place of secret data that was entirely
+// replaced by a custom tag, such as !include, whose contents are unknown.
+const includeKey = "KSOPS_DRY_RUN_INCLUDE"
+
+// isCustomTag returns true if the given tag is a custom tag (e.g. !include)
+// as opposed to one of the standard yaml tags (e.g. !!str).
+func isCustomTag(tag string) bool {
+	return strings.HasPrefix(tag, "!") && !strings.HasPrefix(tag, "!!")
+}
+
+// stripCustomTags rewrites the given document so that any custom tags no
+// longer prevent it from being decoded. A custom tagged value in place of a
+// secret's data or stringData is replaced by a mapping with a single opaque
+// key, and every other custom tag is dropped so that the node is decoded as
+// its plain value.
+func stripCustomTags(document *yaml.Node) {
+	if document.Kind == yaml.DocumentNode && len(document.Content) == 1 {
+		resource := document.Content[0]
+		if resource.Kind == yaml.MappingNode {
+			for i := 0; i+1 < len(resource.Content); i += 2 {
+				key, value := resource.Content[i], resource.Content[i+1]
+				if (key.Value == "data" || key.Value == "stringData") && value.Kind == yaml.ScalarNode && isCustomTag(value.Tag) {
+					resource.Content[i+1] = &yaml.Node{
+						Kind: yaml.MappingNode,
+						Tag:  "!!map",
+						Content: []*yaml.Node{
+							{Kind: yaml.ScalarNode, Tag: "!!str", Value: includeKey},
+							{Kind: yaml.ScalarNode, Tag: "!!str", Value: value.Value},
+						},
+					}
+				}
+			}
+		}
+	}
+
+	stripTags(document)
+}
+
+// stripTags recursively drops every custom tag from the given node.
+func stripTags(node *yaml.Node) {
+	if isCustomTag(node.Tag) {
+		switch node.Kind {
+		case yaml.ScalarNode:
+			node.Tag = "!!str"
+		case yaml.MappingNode:
+			node.Tag = "!!map"
+		case yaml.SequenceNode:
+			node.Tag = "!!seq"
+		default:
+			node.Tag = ""
+		}
+		node.Style &^= yaml.TaggedStyle
+	}
+
+	for _, child := range node.Content {
+		stripTags(child)
+	}
+}
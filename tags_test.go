@@ -0,0 +1,64 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTolerateTags(t *testing.T) {
+	body, err := os.ReadFile("testdata/tags/include.enc.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    []string
+		wantErr string
+	}{
+		{
+			name:    "rejected by default",
+			wantErr: "cannot unmarshal",
+		},
+		{
+			name: "tolerated",
+			env:  map[string]string{"KSOPS_DRY_RUN_TOLERATE_TAGS": ""},
+			want: []string{
+				"KSOPS_DRY_RUN_INCLUDE: KSOPS_DRY_RUN_PLACEHOLDER\n",
+				"token: KSOPS_DRY_RUN_PLACEHOLDER\n",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := stubString(t, string(body), testOptions(t, test.env))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected error %q but got %v", test.wantErr, err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, want := range test.want {
+				if !strings.Contains(output, want) {
+					t.Errorf("expected output to contain %q but got:\n%s", want, output)
+				}
+			}
+			if strings.Contains(output, "!") {
+				t.Errorf("expected no custom tags in the output but got:\n%s", output)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// placeholderKey describes the key whose value is being replaced, and is the
+// data that a placeholder template is rendered with.
+type placeholderKey struct {
+	// Secret is the name (or generateName) of the secret.
+	Secret string
+
+	// Namespace is the namespace of the secret, if any.
+	Namespace string
+
+	// Key is the name of the key.
+	Key string
+
+	// Field is the field that the key was read from, either stringData or
+	// data.
+	Field string
+}
+
+// parseTemplate parses the given placeholder template. The template is also
+// rendered once, so that a reference to an unknown field is reported now,
+// rather than for every key.
+func parseTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("placeholder").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing KSOPS_DRY_RUN_TEMPLATE: %w", err)
+	}
+
+	sample := placeholderKey{Secret: "secret", Namespace: "namespace", Key: "key", Field: "stringData"}
+	if err := tmpl.Execute(&strings.Builder{}, sample); err != nil {
+		return nil, fmt.Errorf("parsing KSOPS_DRY_RUN_TEMPLATE: %w", err)
+	}
+
+	return tmpl, nil
+}
+
+// placeholder returns the placeholder used in place of the value of the given
+// key, which is rendered from the template if there is one.
+func (o *options) placeholder(key placeholderKey) string {
+	if o.template == nil {
+		return o.encryptedPlaceholder
+	}
+
+	var rendered strings.Builder
+	if err := o.template.Execute(&rendered, key); err != nil {
+		// The template was already rendered successfully when parsed, so it
+		// can only fail here on e.g. a function that fails for some keys but
+		// not others, in which case the static placeholder is used instead.
+		o.warnf("", "rendering KSOPS_DRY_RUN_TEMPLATE for key %q of secret %q: %v", key.Key, key.Secret, err)
+
+		return o.encryptedPlaceholder
+	}
+
+	return rendered.String()
+}
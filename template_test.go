@@ -0,0 +1,175 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestPlaceholderTemplate(t *testing.T) {
+	content := `apiVersion: v1
+kind: Secret
+metadata:
+  name: database
+  namespace: prod
+data:
+  tls.key: !!binary ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:bytes]
+  ca.crt: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]
+stringData:
+  password: ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]
+  empty: ""
+`
+
+	tests := []struct {
+		name           string
+		env            map[string]string
+		wantStringData map[string]string
+		wantData       map[string]string
+		wantErr        string
+	}{
+		{
+			name: "unset",
+			wantStringData: map[string]string{
+				"ca.crt":   "KSOPS_DRY_RUN_PLACEHOLDER",
+				"empty":    "",
+				"password": "KSOPS_DRY_RUN_PLACEHOLDER",
+			},
+			wantData: map[string]string{"tls.key": "KSOPS_DRY_RUN_PLACEHOLDER"},
+		},
+		{
+			name: "every field",
+			env:  map[string]string{"KSOPS_DRY_RUN_TEMPLATE": "<redacted:{{.Namespace}}/{{.Secret}}.{{.Field}}.{{.Key}}>"},
+			wantStringData: map[string]string{
+				"ca.crt":   "<redacted:prod/database.data.ca.crt>",
+				"empty":    "",
+				"password": "<redacted:prod/database.stringData.password>",
+			},
+			wantData: map[string]string{"tls.key": "<redacted:prod/database.data.tls.key>"},
+		},
+		{
+			name: "with marker",
+			env:  map[string]string{"KSOPS_DRY_RUN_TEMPLATE": "{{.Key}}", "KSOPS_DRY_RUN_ENCRYPTED_MARKER": ""},
+			wantStringData: map[string]string{
+				"ca.crt":   "ENCRYPTED_ca.crt",
+				"empty":    "",
+				"password": "ENCRYPTED_password",
+			},
+			wantData: map[string]string{"tls.key": "ENCRYPTED_tls.key"},
+		},
+		{
+			name: "with template functions",
+			env:  map[string]string{"KSOPS_DRY_RUN_TEMPLATE": `{{printf "%s-%d" .Key (len .Secret)}}`},
+			wantStringData: map[string]string{
+				"ca.crt":   "ca.crt-8",
+				"empty":    "",
+				"password": "password-8",
+			},
+			wantData: map[string]string{"tls.key": "tls.key-8"},
+		},
+		{
+			name:    "failing to render",
+			env:     map[string]string{"KSOPS_DRY_RUN_TEMPLATE": "{{slice .Namespace 0 (len .Key)}}"},
+			wantErr: `rendering KSOPS_DRY_RUN_TEMPLATE for key "password" of secret "database": template: placeholder:1:2: executing "placeholder" at <slice .Namespace 0 (len .Key)>: error calling slice: index out of range: 8`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := testOptions(t, test.env)
+
+			output, err := stubString(t, content, opts)
+			if err == nil {
+				err = opts.errs()
+			}
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected error %q but got %v", test.wantErr, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			var stubbed secret
+			if err := yaml.Unmarshal([]byte(output), &stubbed); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := fmt.Sprint(stubbed.StringData), fmt.Sprint(test.wantStringData); got != want {
+				t.Errorf("expected stringData %s but got %s", want, got)
+			}
+
+			// Binary values are rendered in the same way, and then encoded.
+			data := make(map[string]string, len(stubbed.Data))
+			for key, value := range stubbed.Data {
+				decoded, err := base64.StdEncoding.DecodeString(value)
+				if err != nil {
+					t.Fatal(err)
+				}
+				data[key] = string(decoded)
+			}
+			if got, want := fmt.Sprint(data), fmt.Sprint(test.wantData); got != want {
+				t.Errorf("expected data %s but got %s", want, got)
+			}
+		})
+	}
+}
+
+func TestParseTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		wantErr string
+	}{
+		{
+			name: "valid",
+			text: "<redacted:{{.Secret}}.{{.Key}}>",
+		},
+		{
+			name:    "syntax error",
+			text:    "<redacted:{{.Secret}>",
+			wantErr: "parsing KSOPS_DRY_RUN_TEMPLATE: template: placeholder:1: bad character",
+		},
+		{
+			name:    "unknown field",
+			text:    "{{.Name}}",
+			wantErr: "parsing KSOPS_DRY_RUN_TEMPLATE: template: placeholder:1:2: executing \"placeholder\" at <.Name>: can't evaluate field Name",
+		},
+		{
+			name:    "unknown function",
+			text:    "{{upper .Key}}",
+			wantErr: `parsing KSOPS_DRY_RUN_TEMPLATE: template: placeholder:1: function "upper" not defined`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Invalid templates are an error when the options are loaded,
+			// before any file is read.
+			t.Setenv("KSOPS_DRY_RUN_TEMPLATE", test.text)
+
+			opts, err := loadOptions()
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if opts.template == nil {
+					t.Error("expected a template")
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("expected error %q but got %v", test.wantErr, err)
+			}
+		})
+	}
+}
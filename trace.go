@@ -0,0 +1,67 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// tracePrefixes are the prefixes of the environment variables that are
+// relevant to the original ksops plugin, and so are traced.
+var tracePrefixes = []string{"KSOPS_", "KUSTOMIZE_", "SOPS_", "XDG_CONFIG_HOME"}
+
+// traceSensitive are the substrings of environment variable names whose values
+// are redacted when traced.
+var traceSensitive = []string{"SECRET", "TOKEN", "KEY"}
+
+// traceExec writes the path, arguments, and relevant environment variables
+// that the original ksops plugin is about to be exec'd with to the given
+// output. Secret-looking variables, such as credentials that sops may need,
+// are always written, but with their values redacted.
+func traceExec(output io.Writer, path string, args, environ []string) {
+	fmt.Fprintf(output, "ksops-dry-run: trace: path: %s\n", path)
+	fmt.Fprintf(output, "ksops-dry-run: trace: args: %q\n", args)
+
+	for _, variable := range environ {
+		name, value, _ := strings.Cut(variable, "=")
+		switch {
+		case isSensitive(name):
+			value = "[redacted]"
+		case !isTraced(name):
+			continue
+		}
+
+		fmt.Fprintf(output, "ksops-dry-run: trace: env: %s=%q\n", name, value)
+	}
+}
+
+// isTraced returns true if the given environment variable name is relevant to
+// the original ksops plugin.
+func isTraced(name string) bool {
+	name = strings.TrimPrefix(name, envName(""))
+	for _, prefix := range tracePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isSensitive returns true if the given environment variable name looks like
+// it holds a secret value.
+func isSensitive(name string) bool {
+	name = strings.ToUpper(name)
+	for _, substring := range traceSensitive {
+		if strings.Contains(name, substring) {
+			return true
+		}
+	}
+
+	return false
+}
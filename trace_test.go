@@ -0,0 +1,96 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestTraceExec(t *testing.T) {
+	environ := []string{
+		"FOO_TOKEN=abc",
+		"PATH=/usr/bin",
+		"HOME=/home/user",
+		"KSOPS_PATH=/opt/ksops",
+		"KUSTOMIZE_PLUGIN_CONFIG_ROOT=/work",
+		"SOPS_AGE_KEY_FILE=/home/user/keys.txt",
+		"AWS_SECRET_ACCESS_KEY=hunter2",
+		"XDG_CONFIG_HOME=/home/user/.config",
+		"KSOPS_DRY_RUN_HTTP_TOKEN=",
+	}
+
+	var output bytes.Buffer
+	traceExec(&output, "/opt/ksops", []string{"ksops", "/tmp/config"}, environ)
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{
+			name: "path",
+			want: "ksops-dry-run: trace: path: /opt/ksops",
+		},
+		{
+			name: "args",
+			want: `ksops-dry-run: trace: args: ["ksops" "/tmp/config"]`,
+		},
+		{
+			name: "sensitive variable",
+			want: `ksops-dry-run: trace: env: FOO_TOKEN="[redacted]"`,
+		},
+		{
+			name: "sensitive relevant variable",
+			want: `ksops-dry-run: trace: env: SOPS_AGE_KEY_FILE="[redacted]"`,
+		},
+		{
+			name: "sensitive empty variable",
+			want: `ksops-dry-run: trace: env: KSOPS_DRY_RUN_HTTP_TOKEN="[redacted]"`,
+		},
+		{
+			name: "sensitive credential",
+			want: `ksops-dry-run: trace: env: AWS_SECRET_ACCESS_KEY="[redacted]"`,
+		},
+		{
+			name: "ksops variable",
+			want: `ksops-dry-run: trace: env: KSOPS_PATH="/opt/ksops"`,
+		},
+		{
+			name: "kustomize variable",
+			want: `ksops-dry-run: trace: env: KUSTOMIZE_PLUGIN_CONFIG_ROOT="/work"`,
+		},
+		{
+			name: "xdg variable",
+			want: `ksops-dry-run: trace: env: XDG_CONFIG_HOME="/home/user/.config"`,
+		},
+	}
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			found := false
+			for _, line := range lines {
+				if line == test.want {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("expected line %q but got:\n%s", test.want, output.String())
+			}
+		})
+	}
+
+	// Irrelevant variables are never written, and secret values never are.
+	if len(lines) != len(tests) {
+		t.Errorf("expected %d lines but got:\n%s", len(tests), output.String())
+	}
+	for _, value := range []string{"abc", "hunter2", "keys.txt", "/usr/bin", "env: PATH=", "env: HOME="} {
+		if strings.Contains(output.String(), value) {
+			t.Errorf("expected no %q in the trace but got:\n%s", value, output.String())
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// validateConfigCmd parses a ksops generator config, and reports whether it
+// is valid, without reading any of the encrypted files that it references.
+// The config is read from the given file (or stdin if "-"), or else from the
+// KUSTOMIZE_PLUGIN_CONFIG_STRING environment variable, or else from stdin.
+func validateConfigCmd(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: ksops-dry-run validate-config [GENERATOR]")
+	}
+
+	var (
+		source string
+		body   []byte
+		err    error
+	)
+
+	switch {
+	case len(args) == 1 && args[0] != "-":
+		source = args[0]
+		body, err = os.ReadFile(source)
+	case len(args) == 0 && os.Getenv("KUSTOMIZE_PLUGIN_CONFIG_STRING") != "":
+		source = "KUSTOMIZE_PLUGIN_CONFIG_STRING"
+		body = []byte(os.Getenv("KUSTOMIZE_PLUGIN_CONFIG_STRING"))
+	default:
+		source = "stdin"
+		body, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := parseKsopsGenerator(body); err != nil {
+		return &fileError{file: source, err: err}
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: valid\n", source)
+
+	return nil
+}
@@ -0,0 +1,107 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	// The referenced files never exist, as they are never read.
+	const (
+		valid     = "apiVersion: viaduct.ai/v1\nkind: ksops\nmetadata:\n  name: generator\nfiles:\n  - missing.enc.yaml\n"
+		wrongKind = "apiVersion: viaduct.ai/v1\nkind: Secret\nmetadata:\n  name: generator\nfiles:\n  - missing.enc.yaml\n"
+	)
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"valid.yaml":      valid,
+		"wrong-kind.yaml": wrongKind,
+	})
+
+	tests := []struct {
+		name       string
+		args       []string
+		env        map[string]string
+		stdin      []string
+		wantSource string
+		wantErr    string
+	}{
+		{
+			name:       "valid file",
+			args:       []string{filepath.Join(dir, "valid.yaml")},
+			wantSource: filepath.Join(dir, "valid.yaml"),
+		},
+		{
+			name:    "wrong kind file",
+			args:    []string{filepath.Join(dir, "wrong-kind.yaml")},
+			wantErr: filepath.Join(dir, "wrong-kind.yaml") + `: expected ksops generator config kind "ksops" but got "Secret"`,
+		},
+		{
+			name:    "missing file",
+			args:    []string{filepath.Join(dir, "missing.yaml")},
+			wantErr: "no such file or directory",
+		},
+		{
+			name:       "valid config string",
+			env:        map[string]string{"KUSTOMIZE_PLUGIN_CONFIG_STRING": valid},
+			wantSource: "KUSTOMIZE_PLUGIN_CONFIG_STRING",
+		},
+		{
+			name:    "wrong kind config string",
+			env:     map[string]string{"KUSTOMIZE_PLUGIN_CONFIG_STRING": wrongKind},
+			wantErr: `KUSTOMIZE_PLUGIN_CONFIG_STRING: expected ksops generator config kind "ksops" but got "Secret"`,
+		},
+		{
+			name:       "valid stdin",
+			args:       []string{"-"},
+			env:        map[string]string{"KUSTOMIZE_PLUGIN_CONFIG_STRING": wrongKind},
+			stdin:      []string{valid},
+			wantSource: "stdin",
+		},
+		{
+			name:    "wrong kind stdin",
+			stdin:   []string{wrongKind},
+			wantErr: `stdin: expected ksops generator config kind "ksops" but got "Secret"`,
+		},
+		{
+			name:    "too many arguments",
+			args:    []string{"valid.yaml", "wrong-kind.yaml"},
+			wantErr: "usage: ksops-dry-run validate-config [GENERATOR]",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := map[string]string{"KUSTOMIZE_PLUGIN_CONFIG_STRING": ""}
+			for name, value := range test.env {
+				env[name] = value
+			}
+
+			var err error
+			stderr := captureStderr(t, func() {
+				_, err = runMain(t, append([]string{"validate-config"}, test.args...), env, test.stdin...)
+			})
+
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected error %q but got %v", test.wantErr, err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want := test.wantSource + ": valid\n"; stderr != want {
+				t.Errorf("expected %q but got %q", want, stderr)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchCmd writes the stubbed secrets for the given ksops generator config,
+// and then writes them again every time that the generator config or any of
+// its files change. It never returns unless the arguments are invalid.
+func watchCmd(args []string) error {
+	flags := flag.NewFlagSet("watch", flag.ContinueOnError)
+	outputFile := flags.String("output", "", "write to the given file instead of stdout")
+	debounce := flags.Duration("debounce", 100*time.Millisecond, "how long files must stop changing for before writing again")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 || *debounce <= 0 {
+		return fmt.Errorf("usage: ksops-dry-run watch [-output FILE] [-debounce DURATION] GENERATOR")
+	}
+
+	opts, err := loadOptions()
+	if err != nil {
+		return err
+	}
+
+	// Watching never exits, so warnings are always written rather than being
+	// collected to be reported as errors.
+	opts.warningsAsErrors = false
+
+	return watchGenerator(flags.Arg(0), *outputFile, *debounce, opts, nil)
+}
+
+// watchGenerator writes the stubbed secrets for the given ksops generator
+// config to stdout, or the given output file, and then writes them again
+// every time that the generator config or any of its files change, until the
+// given channel is closed.
+func watchGenerator(generator, outputFile string, debounce time.Duration, opts *options, done <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	// Every render after the first is separated from the previous one when
+	// writing to stdout, so that the output remains a valid yaml stream.
+	renders := 0
+	render := func() {
+		var buffer bytes.Buffer
+		if err := watchRender(&buffer, generator, opts); err != nil {
+			opts.warnf(generator, "%v", err)
+
+			return
+		}
+
+		if outputFile != "" {
+			if err := os.WriteFile(outputFile, buffer.Bytes(), 0o644); err != nil {
+				opts.warnf(outputFile, "%v", err)
+			}
+
+			return
+		}
+
+		if renders > 0 {
+			fmt.Fprintln(os.Stdout, "---")
+		}
+		renders++
+		if _, err := os.Stdout.Write(buffer.Bytes()); err != nil {
+			opts.warnf("", "%v", err)
+		}
+	}
+
+	watched := watchFiles(watcher, generator, opts)
+	render()
+
+	// Changes are debounced by waiting until the files have stopped changing
+	// for a full period, as editors often write files in several steps.
+	var settled <-chan time.Time
+	for {
+		select {
+		case <-done:
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if _, found := watched[filepath.Clean(event.Name)]; found {
+				settled = time.After(debounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			opts.warnf("", "%v", err)
+		case <-settled:
+			settled = nil
+
+			// The generator config may now reference different files.
+			watched = watchFiles(watcher, generator, opts)
+			render()
+		}
+	}
+}
+
+// watchRender writes the stubbed secrets for the given ksops generator config
+// to the given output.
+func watchRender(output *bytes.Buffer, generator string, opts *options) error {
+	body, err := os.ReadFile(generator)
+	if err != nil {
+		return err
+	}
+
+	config, err := parseKsopsGenerator(body)
+	if err != nil {
+		return err
+	}
+
+	// Every render is audited as a separate run, so files recorded by an
+	// earlier render that failed are forgotten.
+	opts.audit.reset()
+
+	// Encrypted secret files are relative to the directory containing the
+	// generator.
+	root := filepath.Dir(generator)
+	documents, err := generateSecrets(config, root, opts)
+	if err != nil {
+		return err
+	}
+
+	if err := opts.audit.write(root); err != nil {
+		return err
+	}
+
+	return writeDocuments(output, documents, opts)
+}
+
+// watchFiles returns the given ksops generator config, and every local file
+// that it references, and watches the directories that contain them. The
+// directories are watched, rather than the files themselves, so that files
+// which are deleted, created, or replaced by a rename are all noticed.
+func watchFiles(watcher *fsnotify.Watcher, generator string, opts *options) map[string]struct{} {
+	filenames := []string{generator}
+	if body, err := os.ReadFile(generator); err == nil {
+		if config, err := parseKsopsGenerator(body); err == nil {
+			filenames = append(filenames, watchEntries(config, filepath.Dir(generator), opts, 0)...)
+		}
+	}
+
+	watched := make(map[string]struct{}, len(filenames))
+	for _, filename := range filenames {
+		watched[filepath.Clean(filename)] = struct{}{}
+
+		// Adding a directory that is already watched has no effect.
+		if err := watcher.Add(filepath.Dir(filename)); err != nil {
+			opts.warnf(filename, "cannot be watched: %v", err)
+		}
+	}
+
+	return watched
+}
+
+// watchEntries returns every local file of the given generator config at the
+// given depth, resolved in the same way as when the secrets are generated,
+// including the files of any nested generator configs.
+func watchEntries(config *ksopsGeneratorConfig, root string, opts *options, depth int) []string {
+	var filenames []string
+	for _, filename := range config.Files {
+		if opts.isSkipped(filename) || isRemote(filename) {
+			continue
+		}
+
+		if keyFile, ok := parseKeyFile(filename); ok {
+			filename = resolveEntry(root, keyFile.path, opts, depth)
+		} else {
+			filename = resolveEntry(root, filename, opts, depth)
+		}
+
+		if opts.isSkipped(filename) || opts.ignore.matches(filename) {
+			continue
+		}
+		filenames = append(filenames, filename)
+
+		// The files of a nested generator config are relative to its own
+		// directory.
+		if body, err := os.ReadFile(filename); err == nil && depth+1 <= maxGeneratorDepth {
+			if nested, ok := parseNestedKsopsGenerator(body); ok {
+				filenames = append(filenames, watchEntries(nested, filepath.Dir(filename), opts, depth+1)...)
+			}
+		}
+	}
+
+	return filenames
+}
@@ -0,0 +1,205 @@
+// Copyright Josh Komoroske. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// a copy of which can be found in the LICENSE.txt file.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchSecret returns an encrypted secret with the given key.
+func watchSecret(key string) string {
+	return "apiVersion: v1\nkind: Secret\nmetadata:\n    name: app\n    namespace: prod\nstringData:\n    " + key + ": ENC[AES256_GCM,data:9Cn4cx8=,iv:aXY=,tag:dGFn,type:str]\n"
+}
+
+// waitForOutput waits for the given file to contain the given content.
+func waitForOutput(t *testing.T, filename, want string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if body, err := os.ReadFile(filename); err == nil && strings.Contains(string(body), want) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	body, _ := os.ReadFile(filename)
+	t.Fatalf("timed out waiting for %q in output:\n%s", want, body)
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	generator := filepath.Join(dir, "generator.yaml")
+	secret := filepath.Join(dir, "secret.enc.yaml")
+	output := filepath.Join(t.TempDir(), "output.yaml")
+
+	writeFiles(t, dir, map[string]string{
+		"generator.yaml":  "apiVersion: viaduct.ai/v1\nkind: ksops\nfiles:\n    - secret.enc.yaml\n",
+		"secret.enc.yaml": watchSecret("first"),
+	})
+
+	opts := testOptions(t, nil)
+	done := make(chan struct{})
+	stopped := make(chan error)
+	go func() {
+		stopped <- watchGenerator(generator, output, 10*time.Millisecond, opts, done)
+	}()
+	defer func() {
+		close(done)
+		if err := <-stopped; err != nil {
+			t.Error(err)
+		}
+	}()
+
+	waitForOutput(t, output, "first: KSOPS_DRY_RUN_PLACEHOLDER")
+
+	// Editors often save by writing a new file and renaming it over the
+	// original.
+	t.Run("replaced by rename", func(t *testing.T) {
+		writeFiles(t, dir, map[string]string{"secret.enc.yaml.tmp": watchSecret("second")})
+		if err := os.Rename(secret+".tmp", secret); err != nil {
+			t.Fatal(err)
+		}
+
+		waitForOutput(t, output, "second: KSOPS_DRY_RUN_PLACEHOLDER")
+	})
+
+	// A deleted file fails to render, but watching continues until it is
+	// created again.
+	t.Run("deleted and created", func(t *testing.T) {
+		if err := os.Remove(secret); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+
+		writeFiles(t, dir, map[string]string{"secret.enc.yaml": watchSecret("third")})
+
+		waitForOutput(t, output, "third: KSOPS_DRY_RUN_PLACEHOLDER")
+	})
+}
+
+func TestWatchKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	generator := filepath.Join(dir, "generator.yaml")
+	keyFile := filepath.Join(dir, "keys", "password.enc")
+	output := filepath.Join(t.TempDir(), "output.yaml")
+
+	writeFiles(t, dir, map[string]string{
+		"generator.yaml":    "apiVersion: viaduct.ai/v1\nkind: ksops\nmetadata:\n    name: credentials\nfiles:\n    - password=keys/password.enc\n",
+		"keys/password.enc": "ENC[AES256_GCM,data:cGFzcw==,iv:aXY=,tag:dGFn,type:str]\n",
+	})
+
+	opts := testOptions(t, nil)
+	done := make(chan struct{})
+	stopped := make(chan error)
+	go func() {
+		stopped <- watchGenerator(generator, output, 10*time.Millisecond, opts, done)
+	}()
+	defer func() {
+		close(done)
+		if err := <-stopped; err != nil {
+			t.Error(err)
+		}
+	}()
+
+	waitForOutput(t, output, "password: KSOPS_DRY_RUN_PLACEHOLDER")
+
+	// The key file fails to render once it is deleted, so the output is only
+	// written again once the key file itself is noticed being created.
+	if err := os.Remove(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := os.Remove(output); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFiles(t, dir, map[string]string{"keys/password.enc": "ENC[AES256_GCM,data:cGFzcw==,iv:aXY=,tag:dGFn,type:str]\n"})
+
+	waitForOutput(t, output, "password: KSOPS_DRY_RUN_PLACEHOLDER")
+}
+
+func TestWatchFiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		env   map[string]string
+		want  []string
+	}{
+		{
+			name: "plain path entry",
+			files: map[string]string{
+				"generator.yaml": "apiVersion: viaduct.ai/v1\nkind: ksops\nfiles:\n    - secret.enc.yaml\n",
+			},
+			want: []string{"generator.yaml", "secret.enc.yaml"},
+		},
+		{
+			name: "key=path entry",
+			files: map[string]string{
+				"generator.yaml":    "apiVersion: viaduct.ai/v1\nkind: ksops\nfiles:\n    - password=keys/password.enc\n",
+				"keys/password.enc": "",
+			},
+			want: []string{"generator.yaml", "keys/password.enc"},
+		},
+		{
+			name: "nested generator",
+			files: map[string]string{
+				"generator.yaml":        "apiVersion: viaduct.ai/v1\nkind: ksops\nfiles:\n    - nested/generator.yaml\n",
+				"nested/generator.yaml": "apiVersion: viaduct.ai/v1\nkind: ksops\nfiles:\n    - secret.enc.yaml\n    - password=password.enc\n",
+			},
+			want: []string{"generator.yaml", "nested/generator.yaml", "nested/secret.enc.yaml", "nested/password.enc"},
+		},
+		{
+			name: "skipped and remote entries",
+			files: map[string]string{
+				"generator.yaml": "apiVersion: viaduct.ai/v1\nkind: ksops\nfiles:\n    - skipped.enc.yaml\n    - https://example.com/secret.enc.yaml\n",
+			},
+			env:  map[string]string{"KSOPS_DRY_RUN_SKIP_FILES": "skipped.enc.yaml"},
+			want: []string{"generator.yaml"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, test.files)
+
+			watcher, err := fsnotify.NewWatcher()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer watcher.Close()
+
+			// Files that do not exist yet are watched too, so that their
+			// creation is noticed.
+			opts := testOptions(t, test.env)
+			opts.warningsAsErrors = false
+			watched := watchFiles(watcher, filepath.Join(dir, "generator.yaml"), opts)
+
+			want := make(map[string]struct{}, len(test.want))
+			for _, filename := range test.want {
+				want[filepath.Join(dir, filename)] = struct{}{}
+			}
+
+			for filename := range want {
+				if _, found := watched[filename]; !found {
+					t.Errorf("expected %s to be watched", filename)
+				}
+			}
+			for filename := range watched {
+				if _, found := want[filename]; !found {
+					t.Errorf("expected %s to not be watched", filename)
+				}
+			}
+		})
+	}
+}